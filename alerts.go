@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultAlertsFile keeps suppressions and maintenance windows
+const defaultAlertsFile = "alerts.json"
+
+// AlertsConfig decides which alerts are sent and when
+type AlertsConfig struct {
+	// File holds the suppressions, maintenance windows and held alerts, and
+	// is rewritten when they change
+	File string `yaml:"file"`
+	// Dedup reports each open port, service and violation once, until the
+	// port is seen closed or Renotify has passed
+	Dedup    bool          `yaml:"dedup"`
+	Renotify time.Duration `yaml:"renotify"`
+}
+
+// Suppression types. An acknowledgment silences one finding, a port on an
+// address; a suppression rule may cover any host or port.
+const (
+	SuppressAck  = "ack"
+	SuppressRule = "suppress"
+)
+
+// Maintenance window actions
+const (
+	WindowHold = "hold"
+	WindowDrop = "drop"
+)
+
+// Suppression silences findings on Host and Port until Expires. Host is an
+// address, CIDR or host name, and may be empty to match every host, as Port
+// may be 0 to match every port.
+type Suppression struct {
+	ID      string     `json:"id"`
+	Type    string     `json:"type"`
+	Host    string     `json:"host,omitempty"`
+	Port    int        `json:"port,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+
+	ipRange *ipRange
+}
+
+// MaintenanceWindow holds or drops every alert from Start to End, or for
+// Duration each time Schedule fires
+type MaintenanceWindow struct {
+	Name     string     `json:"name"`
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+	Schedule string     `json:"schedule,omitempty"`
+	Duration string     `json:"duration,omitempty"`
+	Action   string     `json:"action"`
+
+	schedule Schedule
+	duration time.Duration
+}
+
+var errWindowExists = errors.New("maintenance window already exists")
+
+// alertRules filters notifications, or is nil to send them all
+var alertRules *AlertRules
+
+// AlertRules applies suppressions, deduplication and maintenance windows to
+// notifications
+type AlertRules struct {
+	path     string
+	dedup    bool
+	renotify time.Duration
+	now      func() time.Time
+
+	mu           sync.Mutex
+	suppressions []Suppression
+	windows      []MaintenanceWindow
+	reported     map[alertPort]map[string]time.Time
+	held         []Email
+}
+
+// alertPort is a port on an address, under which its reported findings are
+// kept until it closes
+type alertPort struct {
+	ip   string
+	port int
+}
+
+// alertsFile is the format of AlertsConfig.File. Held alerts are kept
+// rendered, so they survive a restart as they would have been sent.
+type alertsFile struct {
+	Suppressions []Suppression       `json:"suppressions"`
+	Windows      []MaintenanceWindow `json:"maintenance_windows"`
+	Held         []Email             `json:"held_alerts,omitempty"`
+}
+
+// AlertsSnapshot is the JSON view of the alert rules
+type AlertsSnapshot struct {
+	alertsFile
+	ActiveWindow string `json:"active_window,omitempty"`
+	Held         int    `json:"held"`
+	Reported     int    `json:"reported"`
+}
+
+// loadAlertRules reads the suppressions and windows of cfg.File, which may
+// not exist yet
+func loadAlertRules(cfg AlertsConfig) (*AlertRules, error) {
+	a := &AlertRules{
+		path:     cfg.File,
+		dedup:    cfg.Dedup,
+		renotify: cfg.Renotify,
+		now:      time.Now,
+		reported: make(map[alertPort]map[string]time.Time),
+	}
+	if cfg.File == "" {
+		return a, nil
+	}
+	data, err := os.ReadFile(cfg.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f alertsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", cfg.File, err)
+	}
+	var errs []error
+	names := make(map[string]bool)
+	for _, s := range f.Suppressions {
+		if err := s.parse(); err != nil {
+			errs = append(errs, fmt.Errorf("suppression %s: %w", s.ID, err))
+			continue
+		}
+		a.suppressions = append(a.suppressions, s)
+	}
+	for _, w := range f.Windows {
+		if names[w.Name] {
+			errs = append(errs, fmt.Errorf("%w: %s", errWindowExists, w.Name))
+			continue
+		}
+		names[w.Name] = true
+		if err := w.parse(); err != nil {
+			errs = append(errs, fmt.Errorf("window %s: %w", w.Name, err))
+			continue
+		}
+		a.windows = append(a.windows, w)
+	}
+	a.held = f.Held
+	return a, errors.Join(errs...)
+}
+
+// parse checks s and fills in its defaults
+func (s *Suppression) parse() error {
+	if s.Type == "" {
+		s.Type = SuppressRule
+	}
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("port %d is out of range 1-65535", s.Port)
+	}
+	switch s.Type {
+	case SuppressAck:
+		if net.ParseIP(s.Host) == nil || s.Port == 0 {
+			return errors.New("an acknowledgment needs the address and port of the finding")
+		}
+	case SuppressRule:
+	default:
+		return fmt.Errorf("unknown type %q, want %s or %s", s.Type, SuppressAck, SuppressRule)
+	}
+	s.ipRange = nil
+	ip := net.ParseIP(s.Host)
+	switch {
+	case s.Host == "" || ip != nil && ip.To4() == nil:
+		// Any host, or an IPv6 address compared as is
+	case ip != nil || strings.Contains(s.Host, "/"):
+		ipr, err := parseIPRange(s.Host)
+		if err != nil {
+			return err
+		}
+		s.ipRange = &ipr
+	default:
+		return validHostname(s.Host)
+	}
+	return nil
+}
+
+// expired reports whether s has run out at now
+func (s Suppression) expired(now time.Time) bool {
+	return s.Expires != nil && !now.Before(*s.Expires)
+}
+
+// matches reports whether s covers a finding on ip:port of host name
+func (s Suppression) matches(ip, hostname string, port int, now time.Time) bool {
+	if s.expired(now) {
+		return false
+	}
+	if s.Port != 0 && s.Port != port {
+		return false
+	}
+	switch {
+	case s.Host == "":
+		return true
+	case s.ipRange != nil:
+		if net.ParseIP(ip).To4() == nil {
+			return false
+		}
+		n := ipToUint32(ip)
+		return n >= s.ipRange.lo && n <= s.ipRange.hi
+	case net.ParseIP(s.Host) != nil:
+		return net.ParseIP(s.Host).Equal(net.ParseIP(ip))
+	default:
+		return strings.EqualFold(strings.TrimSuffix(s.Host, "."), strings.TrimSuffix(hostname, "."))
+	}
+}
+
+// parse checks w and fills in its defaults
+func (w *MaintenanceWindow) parse() error {
+	if w.Name == "" {
+		return errors.New("window name is required")
+	}
+	if w.Action == "" {
+		w.Action = WindowHold
+	}
+	if w.Action != WindowHold && w.Action != WindowDrop {
+		return fmt.Errorf("unknown action %q, want %s or %s", w.Action, WindowHold, WindowDrop)
+	}
+	switch {
+	case w.Schedule != "" && (w.Start != nil || w.End != nil):
+		return errors.New("set either start and end, or schedule and duration")
+	case w.Schedule != "":
+		sched, err := parseSchedule(w.Schedule)
+		if err != nil {
+			return err
+		}
+		if _, ok := sched.(intervalSchedule); ok {
+			return fmt.Errorf("schedule %q must be a cron expression", w.Schedule)
+		}
+		d, err := time.ParseDuration(w.Duration)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q", w.Duration)
+		}
+		w.schedule, w.duration = sched, d
+	case w.Start == nil || w.End == nil:
+		return errors.New("start and end, or schedule and duration, are required")
+	case !w.End.After(*w.Start):
+		return fmt.Errorf("end %s is not after start %s", w.End.Format(time.RFC3339), w.Start.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// active reports whether w covers t
+func (w MaintenanceWindow) active(t time.Time) bool {
+	if w.schedule != nil {
+		// The last start before t, if it was within duration
+		next := w.schedule.Next(t.Add(-w.duration))
+		return !next.IsZero() && !next.After(t)
+	}
+	return !t.Before(*w.Start) && t.Before(*w.End)
+}
+
+// activeWindow returns the window covering t, preferring one that drops
+// alerts; callers hold mu
+func (a *AlertRules) activeWindow(t time.Time) *MaintenanceWindow {
+	var found *MaintenanceWindow
+	for i := range a.windows {
+		if w := &a.windows[i]; w.active(t) && (found == nil || w.Action == WindowDrop) {
+			found = w
+		}
+	}
+	return found
+}
+
+// admit filters data for notify, returning what is left to send now.
+// Suppressed and already reported findings are removed; during a
+// maintenance window the message is dropped or held for later.
+func (a *AlertRules) admit(kind string, data any) (any, bool) {
+	if a == nil {
+		return data, true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+
+	data, ok := filterFindings(data, func(ip, hostname string, port int, _ string) bool {
+		for _, s := range a.suppressions {
+			if s.matches(ip, hostname, port, now) {
+				return false
+			}
+		}
+		return true
+	})
+	if !ok {
+		return nil, false
+	}
+	w := a.activeWindow(now)
+	if w != nil && w.Action == WindowDrop {
+		slog.Info("dropping alert in maintenance window", "kind", kind, "window", w.Name)
+		return nil, false
+	}
+	if a.dedup {
+		data, ok = filterFindings(data, func(ip, _ string, port int, service string) bool {
+			return a.firstReport(alertPort{ip, port}, kind+"|"+service, now)
+		})
+		if !ok {
+			return nil, false
+		}
+	}
+	if w != nil {
+		slog.Info("holding alert until the maintenance window ends", "kind", kind, "window", w.Name)
+		a.held = append(a.held, renderEmails(kind, data)...)
+		a.save()
+		return nil, false
+	}
+	return data, true
+}
+
+// firstReport records a finding, reporting whether it is new or due to be
+// repeated; callers hold mu
+func (a *AlertRules) firstReport(p alertPort, key string, now time.Time) bool {
+	findings := a.reported[p]
+	if findings == nil {
+		findings = make(map[string]time.Time)
+		a.reported[p] = findings
+	}
+	last, seen := findings[key]
+	if seen && (a.renotify == 0 || now.Sub(last) < a.renotify) {
+		return false
+	}
+	findings[key] = now
+	return true
+}
+
+// filterFindings keeps the findings of data that keep accepts. It reports
+// false when data had findings and none were kept; other messages pass.
+func filterFindings(data any, keep func(ip, hostname string, port int, service string) bool) (any, bool) {
+	switch d := data.(type) {
+	case ScanResult:
+		return d, keep(d.IP, d.Hostname, d.Port, d.CPE)
+	case summaryData:
+		if len(d.Results) == 0 {
+			return d, true
+		}
+		var kept []ScanResult
+		for _, r := range d.Results {
+			if keep(r.IP, r.Hostname, r.Port, r.CPE) {
+				kept = append(kept, r)
+			}
+		}
+		d.Results = kept
+		return d, len(kept) > 0
+	case violationsData:
+		var kept []Violation
+		for _, v := range d.Violations {
+			if keep(v.IP, v.Hostname, v.Port, v.Type) {
+				kept = append(kept, v)
+			}
+		}
+		d.Violations = kept
+		return d, len(kept) > 0
+	}
+	return data, true
+}
+
+// observe forgets the findings reported on a port once it is seen closed,
+// so they are reported again if it reopens
+func (a *AlertRules) observe(r ScanResult) {
+	if a == nil || r.State() != StateClosed {
+		return
+	}
+	a.mu.Lock()
+	delete(a.reported, alertPort{r.IP, r.Port})
+	a.mu.Unlock()
+}
+
+// Run drops expired suppressions and sends held alerts once no window holds
+// them, until ctx is done
+func (a *AlertRules) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			if a.prune() {
+				a.save()
+			}
+			a.mu.Unlock()
+			a.release()
+		}
+	}
+}
+
+// release sends the held alerts if no window is active
+func (a *AlertRules) release() {
+	a.mu.Lock()
+	if len(a.held) == 0 || a.activeWindow(a.now()) != nil {
+		a.mu.Unlock()
+		return
+	}
+	held := a.held
+	a.held = nil
+	a.save()
+	a.mu.Unlock()
+
+	slog.Info("maintenance window over, sending held alerts", "alerts", len(held))
+	for _, e := range held {
+		send(e)
+	}
+}
+
+// prune drops expired suppressions, reporting whether there were any;
+// callers hold mu
+func (a *AlertRules) prune() bool {
+	now := a.now()
+	live := a.suppressions[:0]
+	for _, s := range a.suppressions {
+		if !s.expired(now) {
+			live = append(live, s)
+		}
+	}
+	pruned := len(live) != len(a.suppressions)
+	clear(a.suppressions[len(live):])
+	a.suppressions = live
+	return pruned
+}
+
+// AddSuppression validates and saves s, returning it with its ID
+func (a *AlertRules) AddSuppression(s Suppression) (Suppression, error) {
+	if err := s.parse(); err != nil {
+		return s, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s.ID = newJobID()
+	s.Created = a.now()
+	if s.Expires != nil && !s.Expires.After(s.Created) {
+		return s, fmt.Errorf("expires %s is in the past", s.Expires.Format(time.RFC3339))
+	}
+	a.prune()
+	a.suppressions = append(a.suppressions, s)
+	a.save()
+	return s, nil
+}
+
+// RemoveSuppression deletes the suppression with id
+func (a *AlertRules) RemoveSuppression(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, s := range a.suppressions {
+		if s.ID == id {
+			a.suppressions = append(a.suppressions[:i], a.suppressions[i+1:]...)
+			a.prune()
+			a.save()
+			return true
+		}
+	}
+	return false
+}
+
+// AddWindow validates and saves w
+func (a *AlertRules) AddWindow(w MaintenanceWindow) (MaintenanceWindow, error) {
+	if err := w.parse(); err != nil {
+		return w, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, existing := range a.windows {
+		if existing.Name == w.Name {
+			return w, fmt.Errorf("%w: %s", errWindowExists, w.Name)
+		}
+	}
+	a.windows = append(a.windows, w)
+	a.prune()
+	a.save()
+	return w, nil
+}
+
+// RemoveWindow deletes the window called name. Alerts it held are sent at
+// the next check.
+func (a *AlertRules) RemoveWindow(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, w := range a.windows {
+		if w.Name == name {
+			a.windows = append(a.windows[:i], a.windows[i+1:]...)
+			a.prune()
+			a.save()
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot returns the suppressions that have not expired and the windows
+func (a *AlertRules) Snapshot() AlertsSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	snap := AlertsSnapshot{
+		alertsFile: alertsFile{Suppressions: []Suppression{}, Windows: append([]MaintenanceWindow{}, a.windows...)},
+		Held:       len(a.held),
+	}
+	for _, s := range a.suppressions {
+		if !s.expired(now) {
+			snap.Suppressions = append(snap.Suppressions, s)
+		}
+	}
+	if w := a.activeWindow(now); w != nil {
+		snap.ActiveWindow = w.Name
+	}
+	for _, findings := range a.reported {
+		snap.Reported += len(findings)
+	}
+	return snap
+}
+
+// save writes the rules to the alerts file; callers hold mu
+func (a *AlertRules) save() {
+	if a.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(alertsFile{Suppressions: a.suppressions, Windows: a.windows, Held: a.held}, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(a.path, data)
+	}
+	if err != nil {
+		slog.Error("saving alert rules", "path", a.path, "error", err)
+	}
+}
+
+func registerAlertAPI(mux *http.ServeMux, a *AlertRules) {
+	mux.HandleFunc("GET /alerts", a.handleList)
+	mux.HandleFunc("POST /alerts/suppressions", a.handleAddSuppression)
+	mux.HandleFunc("DELETE /alerts/suppressions/{id}", a.handleRemoveSuppression)
+	mux.HandleFunc("POST /alerts/windows", a.handleAddWindow)
+	mux.HandleFunc("DELETE /alerts/windows/{name}", a.handleRemoveWindow)
+}
+
+func (a *AlertRules) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Snapshot())
+}
+
+func (a *AlertRules) handleAddSuppression(w http.ResponseWriter, r *http.Request) {
+	var s Suppression
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid suppression: %v", err)
+		return
+	}
+	s, err := a.AddSuppression(s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid suppression: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s)
+}
+
+func (a *AlertRules) handleRemoveSuppression(w http.ResponseWriter, r *http.Request) {
+	if !a.RemoveSuppression(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, "suppression not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AlertRules) handleAddWindow(w http.ResponseWriter, r *http.Request) {
+	var window MaintenanceWindow
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&window); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid maintenance window: %v", err)
+		return
+	}
+	window, err := a.AddWindow(window)
+	switch {
+	case errors.Is(err, errWindowExists):
+		writeError(w, http.StatusConflict, "%v", err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "invalid maintenance window: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, window)
+}
+
+func (a *AlertRules) handleRemoveWindow(w http.ResponseWriter, r *http.Request) {
+	if !a.RemoveWindow(r.PathValue("name")) {
+		writeError(w, http.StatusNotFound, "maintenance window not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
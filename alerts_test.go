@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestSuppressions tests which findings suppressions and acknowledgments
+// silence, and deduplication
+func TestSuppressions(t *testing.T) {
+	a, err := loadAlertRules(AlertsConfig{Dedup: true, Renotify: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	expires := now.Add(time.Hour)
+	for _, s := range []Suppression{
+		{Type: SuppressAck, Host: "10.0.0.5", Port: 22, Reason: "bastion"},
+		{Host: "10.0.1.0/24"},
+		{Host: "build.example.test", Port: 8080},
+		{Port: 9100, Expires: &expires},
+	} {
+		if _, err := a.AddSuppression(s); err != nil {
+			t.Fatalf("AddSuppression(%+v) failed: %v", s, err)
+		}
+	}
+	for _, s := range []Suppression{
+		{Type: SuppressAck, Host: "10.0.0.0/24", Port: 22},
+		{Type: SuppressAck, Host: "10.0.0.5"},
+		{Type: "ignore"},
+		{Host: "*.example.test"},
+		{Port: 70000},
+		{Port: 22, Expires: &now},
+	} {
+		if _, err := a.AddSuppression(s); err == nil {
+			t.Errorf("AddSuppression(%+v) succeeded", s)
+		}
+	}
+
+	tests := []struct {
+		result ScanResult
+		sent   bool
+	}{
+		{ScanResult{IP: "10.0.0.5", Port: 22}, false},
+		{ScanResult{IP: "10.0.0.5", Port: 80}, true},
+		{ScanResult{IP: "10.0.1.77", Port: 443}, false},
+		{ScanResult{IP: "10.0.2.8", Hostname: "BUILD.example.test", Port: 8080}, false},
+		{ScanResult{IP: "10.0.2.9", Port: 9100}, false},
+		// Reported already
+		{ScanResult{IP: "10.0.0.5", Port: 80}, false},
+		// A different service on the same port is news
+		{ScanResult{IP: "10.0.0.5", Port: 80, CPE: "cpe:2.3:a:nginx:nginx:1.25.3"}, true},
+	}
+	for _, tt := range tests {
+		if _, sent := a.admit(MsgOpenPort, tt.result); sent != tt.sent {
+			t.Errorf("admit(%s:%d) = %v, want %v", tt.result.IP, tt.result.Port, sent, tt.sent)
+		}
+	}
+	if _, sent := a.admit(MsgVulnerable, ScanResult{IP: "10.0.0.5", Port: 80}); !sent {
+		t.Error("admit() deduplicated a different kind of alert")
+	}
+
+	// Summaries keep the findings not suppressed or already summarized
+	a.admit(MsgSummary, summaryData{Results: []ScanResult{{IP: "10.0.0.5", Port: 80}}})
+	data, sent := a.admit(MsgSummary, summaryData{Results: []ScanResult{{IP: "10.0.0.5", Port: 80}, {IP: "10.0.1.1", Port: 80}, {IP: "10.0.0.6", Port: 25}}})
+	if !sent || len(data.(summaryData).Results) != 1 || data.(summaryData).Results[0].IP != "10.0.0.6" {
+		t.Errorf("admit(summary) = %+v, %v", data, sent)
+	}
+	if _, sent := a.admit(MsgSummary, summaryData{Results: []ScanResult{{IP: "10.0.0.6", Port: 25}}}); sent {
+		t.Error("admit() sent a summary of reported findings")
+	}
+	if _, sent := a.admit(MsgSummary, summaryData{}); !sent {
+		t.Error("admit() held back an empty summary")
+	}
+
+	// The findings are reported again after the port closes or after an hour
+	a.observe(ScanResult{IP: "10.0.0.5", Port: 80, Error: syscall.ECONNREFUSED})
+	if _, sent := a.admit(MsgOpenPort, ScanResult{IP: "10.0.0.5", Port: 80}); !sent {
+		t.Error("admit() deduplicated a port that closed and reopened")
+	}
+	a.observe(ScanResult{IP: "10.0.0.6", Port: 25, Error: timeoutError{}})
+	if _, sent := a.admit(MsgSummary, summaryData{Results: []ScanResult{{IP: "10.0.0.6", Port: 25}}}); sent {
+		t.Error("admit() forgot a finding on a filtered port")
+	}
+	now = now.Add(time.Hour)
+	if _, sent := a.admit(MsgSummary, summaryData{Results: []ScanResult{{IP: "10.0.0.6", Port: 25}}}); !sent {
+		t.Error("admit() did not renotify after an hour")
+	}
+	// The temporary suppression has expired
+	if _, sent := a.admit(MsgOpenPort, ScanResult{IP: "10.0.2.9", Port: 9100}); !sent {
+		t.Error("admit() applied an expired suppression")
+	}
+	if snap := a.Snapshot(); len(snap.Suppressions) != 3 {
+		t.Errorf("Snapshot() has %d suppressions, want 3 once one expired", len(snap.Suppressions))
+	}
+	// Reading leaves the rules alone; the next change drops the expired one
+	if len(a.suppressions) != 4 {
+		t.Errorf("Snapshot() pruned the rules to %d", len(a.suppressions))
+	}
+	if _, err := a.AddSuppression(Suppression{Port: 3389}); err != nil || len(a.suppressions) != 4 {
+		t.Errorf("AddSuppression() left %d suppressions, want 4 after pruning, %v", len(a.suppressions), err)
+	}
+}
+
+// TestMaintenanceWindows tests holding and dropping alerts
+func TestMaintenanceWindows(t *testing.T) {
+	originalRules, originalSend, originalImpl := alertRules, sendFunc, sendImpl
+	defer func() { alertRules, sendFunc, sendImpl = originalRules, originalSend, originalImpl }()
+	var sent []string
+	sendFunc = func(e Email) { sent = append(sent, e.Subject) }
+	sendImpl = func(e Email) (int, time.Duration) { return 200, 0 }
+
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	a, _ := loadAlertRules(AlertsConfig{File: path})
+	alertRules = a
+	now := time.Date(2026, 3, 7, 1, 30, 0, 0, time.UTC) // a Saturday
+	a.now = func() time.Time { return now }
+
+	start, end := now.Add(-time.Minute), now.Add(time.Hour)
+	for _, w := range []MaintenanceWindow{
+		{Name: "upgrade", Start: &start, End: &end},
+		{Name: "backups", Schedule: "0 2 * * 6", Duration: "2h", Action: WindowDrop},
+	} {
+		if _, err := a.AddWindow(w); err != nil {
+			t.Fatalf("AddWindow(%s) failed: %v", w.Name, err)
+		}
+	}
+	for _, w := range []MaintenanceWindow{
+		{Name: "upgrade", Start: &start, End: &end},
+		{Name: "reversed", Start: &end, End: &start},
+		{Name: "interval", Schedule: "@every 1h", Duration: "1h"},
+		{Name: "no-duration", Schedule: "@daily"},
+		{Name: "both", Schedule: "@daily", Duration: "1h", Start: &start, End: &end},
+		{Name: "unknown", Start: &start, End: &end, Action: "mute"},
+		{Start: &start, End: &end},
+	} {
+		if _, err := a.AddWindow(w); err == nil {
+			t.Errorf("AddWindow(%s) succeeded", w.Name)
+		}
+	}
+	if _, err := a.AddWindow(MaintenanceWindow{Name: "upgrade", Start: &start, End: &end}); !errors.Is(err, errWindowExists) {
+		t.Errorf("AddWindow() of a duplicate = %v", err)
+	}
+
+	// Held during the upgrade
+	notify(MsgOpenPort, ScanResult{IP: "10.0.0.1", Port: 22, Open: true})
+	notify(MsgUpdate, nil)
+	if len(sent) != 0 || a.Snapshot().Held != 2 || a.Snapshot().ActiveWindow != "upgrade" {
+		t.Fatalf("sent %v during the window, snapshot %+v", sent, a.Snapshot())
+	}
+	a.release()
+	if len(sent) != 0 {
+		t.Fatalf("release() sent %v during the window", sent)
+	}
+
+	// Held alerts are kept in the alerts file across a restart
+	a, err := loadAlertRules(AlertsConfig{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alertRules = a
+	a.now = func() time.Time { return now }
+	if a.Snapshot().Held != 2 {
+		t.Fatalf("reloaded rules hold %d alerts, want 2", a.Snapshot().Held)
+	}
+
+	// Dropped during the backups, which also keep the held alerts
+	now = time.Date(2026, 3, 7, 2, 30, 0, 0, time.UTC)
+	notify(MsgUpdate, nil)
+	a.release()
+	if len(sent) != 0 || a.Snapshot().Held != 2 || a.Snapshot().ActiveWindow != "backups" {
+		t.Fatalf("sent %v during the backups, snapshot %+v", sent, a.Snapshot())
+	}
+
+	now = time.Date(2026, 3, 7, 4, 0, 0, 0, time.UTC)
+	a.release()
+	if strings.Join(sent, "|") != "Open port found|Update" || a.Snapshot().Held != 0 {
+		t.Errorf("release() sent %v, snapshot %+v", sent, a.Snapshot())
+	}
+	if reloaded, _ := loadAlertRules(AlertsConfig{File: path}); reloaded.Snapshot().Held != 0 {
+		t.Error("released alerts still in the alerts file")
+	}
+	// Next Saturday's backups
+	if w := a.activeWindow(time.Date(2026, 3, 14, 3, 59, 0, 0, time.UTC)); w == nil || w.Name != "backups" {
+		t.Errorf("activeWindow() = %+v, want backups", w)
+	}
+}
+
+// TestAlertAPI tests managing suppressions and windows over HTTP and
+// keeping them in the alerts file
+func TestAlertAPI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	a, err := loadAlertRules(AlertsConfig{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerAlertAPI(mux, a)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/alerts/suppressions", `{"type":"ack","host":"10.0.0.5","port":22,"reason":"jump host"}`)
+	var s Suppression
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &s) != nil || s.ID == "" {
+		t.Fatalf("POST /alerts/suppressions = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/alerts/suppressions", `{"host":"10.0.0.0/40"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST of a bad suppression = %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/alerts/suppressions", `{"hots":"10.0.0.1"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST with an unknown field = %d", rec.Code)
+	}
+	window := `{"name":"patching","schedule":"0 22 * * 3","duration":"3h"}`
+	if rec := do(http.MethodPost, "/alerts/windows", window); rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"action":"hold"`) {
+		t.Errorf("POST /alerts/windows = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/alerts/windows", window); rec.Code != http.StatusConflict {
+		t.Errorf("POST of a duplicate window = %d", rec.Code)
+	}
+
+	// The file is reloaded on restart
+	reloaded, err := loadAlertRules(AlertsConfig{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := reloaded.Snapshot()
+	if len(snap.Suppressions) != 1 || snap.Suppressions[0].ID != s.ID || len(snap.Windows) != 1 || snap.Windows[0].schedule == nil {
+		t.Errorf("reloaded rules = %+v", snap)
+	}
+
+	// Listing the rules does not rewrite the file, even with one expired
+	expired := time.Now().Add(-time.Minute)
+	reloaded.suppressions = append(reloaded.suppressions, Suppression{ID: "old", Expires: &expired})
+	reloaded.path = filepath.Join(t.TempDir(), "untouched.json")
+	if snap := reloaded.Snapshot(); len(snap.Suppressions) != 1 {
+		t.Errorf("Snapshot() listed %d suppressions, want 1", len(snap.Suppressions))
+	}
+	if _, err := os.Stat(reloaded.path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Snapshot() wrote the alerts file: %v", err)
+	}
+
+	rec = do(http.MethodGet, "/alerts", "")
+	var list AlertsSnapshot
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &list) != nil || len(list.Suppressions) != 1 || list.Windows[0].Name != "patching" {
+		t.Errorf("GET /alerts = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodDelete, "/alerts/suppressions/"+s.ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE suppression = %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/alerts/windows/patching", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE window = %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/alerts/windows/patching", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a missing window = %d", rec.Code)
+	}
+	if reloaded, _ := loadAlertRules(AlertsConfig{File: path}); len(reloaded.Snapshot().Suppressions) != 0 {
+		t.Error("deleted suppression still in the alerts file")
+	}
+}
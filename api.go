@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// MarshalJSON renders the error as a string and includes the derived state
+func (r ScanResult) MarshalJSON() ([]byte, error) {
+	type alias ScanResult
+	errMsg := ""
+	if r.Error != nil {
+		errMsg = r.Error.Error()
+	}
+	var started *time.Time
+	if !r.Started.IsZero() {
+		started = &r.Started
+	}
+	return json.Marshal(struct {
+		alias
+		State    string     `json:"state"`
+		Error    string     `json:"error,omitempty"`
+		Severity string     `json:"severity,omitempty"`
+		Started  *time.Time `json:"started,omitempty"`
+		Latency  float64    `json:"latency_ms,omitempty"`
+	}{alias: alias(r), State: r.State(), Error: errMsg, Severity: resultSeverity(r), Started: started, Latency: milliseconds(r.Latency)})
+}
+
+// UnmarshalJSON restores a result rendered by MarshalJSON. The error comes
+// back as a remoteError that keeps the filtered state, or a hopError.
+func (r *ScanResult) UnmarshalJSON(data []byte) error {
+	type alias ScanResult
+	var v struct {
+		alias
+		State   string     `json:"state"`
+		Error   string     `json:"error"`
+		Started *time.Time `json:"started"`
+		Latency float64    `json:"latency_ms"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = ScanResult(v.alias)
+	if v.Started != nil {
+		r.Started = *v.Started
+	}
+	r.Latency = fromMilliseconds(v.Latency)
+	switch {
+	case v.State == StateError:
+		r.Error = hopError{err: errors.New(v.Error)}
+	case v.Error != "" || v.State == StateFiltered:
+		r.Error = remoteError{msg: v.Error, timeout: v.State == StateFiltered}
+	}
+	return nil
+}
+
+// remoteError is a probe error received from another process
+type remoteError struct {
+	msg     string
+	timeout bool
+}
+
+func (e remoteError) Error() string   { return e.msg }
+func (e remoteError) Timeout() bool   { return e.timeout }
+func (e remoteError) Temporary() bool { return false }
+
+// resultSeverity rates results with known CVEs
+func resultSeverity(r ScanResult) string {
+	if len(r.CVEs) == 0 {
+		return ""
+	}
+	return severity(r.CVSS)
+}
+
+type resultsPage struct {
+	Total   int          `json:"total"`
+	Offset  int          `json:"offset"`
+	Limit   int          `json:"limit"`
+	Results []ScanResult `json:"results"`
+}
+
+// registerAPI adds the status, results and event stream endpoints to mux
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /status", handleStatus)
+	mux.HandleFunc("GET /results", handleResults)
+	mux.HandleFunc("GET /results/open", handleOpenResults)
+	mux.HandleFunc("GET /results/hosts", handleHostResults)
+	mux.HandleFunc("GET /events", handleEvents)
+	mux.HandleFunc("GET /compliance", handleCompliance)
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	snap := status.Snapshot()
+	if scheduler != nil {
+		snap.Schedules = scheduler.List()
+	}
+	if outbox != nil {
+		stats := outbox.Stats()
+		snap.Notifications = &stats
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
+
+func handleResults(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := ResultFilter{IP: q.Get("ip"), State: q.Get("state")}
+
+	if p := q.Get("port"); p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			writeError(w, http.StatusBadRequest, "invalid port %q", p)
+			return
+		}
+		filter.Port = port
+	}
+	switch filter.State {
+	case "", StateOpen, StateClosed, StateFiltered, StateError:
+	default:
+		writeError(w, http.StatusBadRequest, "invalid state %q", filter.State)
+		return
+	}
+	if s := q.Get("min_cvss"); s != "" {
+		score, err := strconv.ParseFloat(s, 64)
+		if err != nil || score < 0 || score > 10 {
+			writeError(w, http.StatusBadRequest, "invalid min_cvss %q", s)
+			return
+		}
+		filter.MinCVSS = score
+	}
+	if s := q.Get("severity"); s != "" {
+		score, err := parseSeverity(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		filter.MinCVSS = max(filter.MinCVSS, score)
+	}
+
+	offset, limit, err := parsePage(q.Get("offset"), q.Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	page, total := results.Query(filter, offset, limit)
+	if page == nil {
+		page = []ScanResult{}
+	}
+	writeJSON(w, http.StatusOK, resultsPage{Total: total, Offset: offset, Limit: limit, Results: page})
+}
+
+func handleOpenResults(w http.ResponseWriter, r *http.Request) {
+	open := results.Open()
+	if open == nil {
+		open = []ScanResult{}
+	}
+	writeJSON(w, http.StatusOK, open)
+}
+
+func parsePage(offsetStr, limitStr string) (int, int, error) {
+	offset, limit := 0, defaultPageLimit
+	if offsetStr != "" {
+		n, err := strconv.Atoi(offsetStr)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", offsetStr)
+		}
+		offset = n
+	}
+	if limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit %q", limitStr)
+		}
+		limit = min(n, maxPageLimit)
+	}
+	return offset, limit, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("encoding response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, format string, args ...any) {
+	writeJSON(w, code, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func seedResults() {
+	results.Reset()
+	results.Add(ScanResult{IP: "10.0.0.1", Port: 22, Open: true})
+	results.Add(ScanResult{IP: "10.0.0.1", Port: 80, Error: fmt.Errorf("connection refused")})
+	results.Add(ScanResult{IP: "10.0.0.2", Port: 22, Error: timeoutError{}})
+	results.Add(ScanResult{IP: "10.0.0.2", Port: 80, Open: true})
+}
+
+// TestResultState tests open/closed/filtered classification
+func TestResultState(t *testing.T) {
+	cases := map[string]ScanResult{
+		StateOpen:     {Open: true},
+		StateClosed:   {Error: fmt.Errorf("connection refused")},
+		StateFiltered: {Error: &os.SyscallError{Syscall: "connect", Err: timeoutError{}}},
+	}
+	for want, r := range cases {
+		if got := r.State(); got != want {
+			t.Errorf("State() = %s, want %s", got, want)
+		}
+	}
+}
+
+// TestResultsEndpoint tests filtering and pagination of /results
+func TestResultsEndpoint(t *testing.T) {
+	defer results.Reset()
+	seedResults()
+	mux := http.NewServeMux()
+	registerAPI(mux)
+
+	tests := []struct {
+		query string
+		total int
+		page  int
+	}{
+		{"", 4, 4},
+		{"?ip=10.0.0.1", 2, 2},
+		{"?port=22", 2, 2},
+		{"?state=filtered", 1, 1},
+		{"?state=open&port=80", 1, 1},
+		{"?limit=3&offset=2", 4, 2},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/results"+tc.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /results%s returned %d", tc.query, rec.Code)
+		}
+		var page resultsPage
+		if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		if page.Total != tc.total || len(page.Results) != tc.page {
+			t.Errorf("GET /results%s = total %d, page %d; want %d, %d", tc.query, page.Total, len(page.Results), tc.total, tc.page)
+		}
+	}
+
+	for _, bad := range []string{"?port=abc", "?state=bogus", "?limit=-1"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/results"+bad, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET /results%s returned %d, want 400", bad, rec.Code)
+		}
+	}
+}
+
+// TestOpenResultsEndpoint tests /results/open
+func TestOpenResultsEndpoint(t *testing.T) {
+	defer results.Reset()
+	seedResults()
+	mux := http.NewServeMux()
+	registerAPI(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/results/open", nil))
+	var open []map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&open); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(open) != 2 || open[0]["state"] != StateOpen {
+		t.Errorf("GET /results/open returned %+v", open)
+	}
+}
+
+// TestStatusEndpoint tests progress reporting
+func TestStatusEndpoint(t *testing.T) {
+	original := status
+	defer func() { status = original }()
+	status = NewScanStatus()
+	status.nextIteration()
+	status.begin("10.0.0.1", "10.0.0.4", 4)
+	status.record(ScanResult{Open: true})
+	status.record(ScanResult{})
+
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/status", nil))
+
+	var snap StatusSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&snap); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if snap.Iteration != 1 || !snap.Running || snap.Completed != 2 || snap.Open != 1 || snap.PercentDone != 50 {
+		t.Errorf("GET /status returned %+v", snap)
+	}
+	if snap.StartIP != "10.0.0.1" || snap.EndIP != "10.0.0.4" {
+		t.Errorf("GET /status returned wrong range: %+v", snap)
+	}
+}
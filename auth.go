@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// unauthenticatedPaths are served without credentials
+var unauthenticatedPaths = map[string]bool{
+	"/health": true,
+}
+
+// AuthConfig holds the credentials accepted by the HTTP server. Either a
+// bearer token, basic auth credentials, or both may be set.
+type AuthConfig struct {
+	Token    string `yaml:"token"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+func (a AuthConfig) Enabled() bool {
+	return a.Token != "" || a.Username != ""
+}
+
+func (a AuthConfig) authorized(r *http.Request) bool {
+	if a.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureEqual(token, a.Token) {
+			return true
+		}
+	}
+	if a.Username != "" {
+		if user, pass, ok := r.BasicAuth(); ok && secureEqual(user, a.Username) && secureEqual(pass, a.Password) {
+			return true
+		}
+	}
+	return false
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// requireAuth rejects requests without valid credentials, except for the
+// unauthenticated paths. With no credentials configured it is a no-op.
+func requireAuth(next http.Handler, auth AuthConfig) http.Handler {
+	if !auth.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unauthenticatedPaths[r.URL.Path] || auth.authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		slog.Warn("rejected unauthenticated request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		if auth.Token != "" {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="port-scanner"`)
+		}
+		if auth.Username != "" {
+			w.Header().Add("WWW-Authenticate", `Basic realm="port-scanner"`)
+		}
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+	})
+}
+
+// rejectCrossOrigin refuses state-changing requests sent from another site.
+// Browsers attach basic auth credentials to those, so any page could
+// otherwise start scans. Bodies must be JSON, which a cross-site form cannot
+// send without the server's consent.
+func rejectCrossOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := checkOrigin(r); err != nil {
+			slog.Warn("rejected cross-origin request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "error", err)
+			writeError(w, http.StatusForbidden, "%v", err)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkOrigin returns an error if a browser says r comes from another site.
+// Clients that are not browsers send neither header.
+func checkOrigin(r *http.Request) error {
+	switch site := r.Header.Get("Sec-Fetch-Site"); site {
+	case "", "same-origin", "none":
+	default:
+		return fmt.Errorf("cross-site request refused (Sec-Fetch-Site: %s)", site)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return fmt.Errorf("cross-origin request refused (Origin %s, Host %s)", origin, r.Host)
+		}
+	}
+	return nil
+}
+
+// TLSOptions selects how the HTTP server is secured
+type TLSOptions struct {
+	CertFile   string
+	KeyFile    string
+	SelfSigned bool
+}
+
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != "" || o.SelfSigned
+}
+
+// tlsConfig loads the configured certificate or generates a self-signed one
+func (o TLSOptions) tlsConfig() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case o.CertFile != "":
+		if o.KeyFile == "" {
+			return nil, fmt.Errorf("TLS certificate %s given without a key file", o.CertFile)
+		}
+		cert, err = tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	case o.SelfSigned:
+		cert, err = selfSignedCert()
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// selfSignedCert creates a short-lived certificate for localhost and the
+// machine's hostname
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	dnsNames := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil {
+		dnsNames = append(dnsNames, hostname)
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Port Scanner"}, CommonName: "port-scanner"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRequireAuth tests bearer and basic auth with an open health check
+func TestRequireAuth(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := requireAuth(mux, AuthConfig{Token: "secret", Username: "admin", Password: "hunter2"})
+
+	tests := []struct {
+		name  string
+		path  string
+		setup func(*http.Request)
+		want  int
+	}{
+		{"health without credentials", "/health", func(r *http.Request) {}, http.StatusOK},
+		{"no credentials", "/status", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"valid bearer", "/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"invalid bearer", "/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"valid basic", "/status", func(r *http.Request) { r.SetBasicAuth("admin", "hunter2") }, http.StatusOK},
+		{"invalid basic", "/status", func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }, http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		tc.setup(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+
+	if requireAuth(mux, AuthConfig{}) != http.Handler(mux) {
+		t.Error("requireAuth() wrapped handler with no credentials configured")
+	}
+}
+
+// TestRejectCrossOrigin tests that a page on another site cannot submit a
+// scan with the browser's basic auth credentials
+func TestRejectCrossOrigin(t *testing.T) {
+	m := NewJobManager(1, 10, defaultJobsKept)
+	mux := http.NewServeMux()
+	registerJobAPI(mux, m)
+	handler := requireAuth(rejectCrossOrigin(mux), AuthConfig{Username: "admin", Password: "hunter2"})
+
+	body := `{"start":"10.0.0.1","end":"10.0.0.1","ports":[22]}`
+	tests := []struct {
+		name, method, target, contentType string
+		headers                           map[string]string
+		want                              int
+	}{
+		{"cross-origin POST", "POST", "/scans", "application/json", map[string]string{"Origin": "https://evil.example", "Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"cross-origin POST from an old browser", "POST", "/scans", "application/json", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"POST from a sandboxed page", "POST", "/scans", "application/json", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"same-site POST from another port", "POST", "/scans", "application/json", map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"cross-origin DELETE", "DELETE", "/scans/x", "", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"form POST", "POST", "/scans", "application/x-www-form-urlencoded", nil, http.StatusUnsupportedMediaType},
+		{"text POST", "POST", "/scans", "text/plain", map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusUnsupportedMediaType},
+		{"dashboard POST", "POST", "/scans", "application/json", map[string]string{"Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"}, http.StatusAccepted},
+		{"API client POST", "POST", "/scans", "application/json; charset=utf-8", nil, http.StatusAccepted},
+		{"dashboard DELETE", "DELETE", "/scans/x", "", map[string]string{"Origin": "http://example.com"}, http.StatusNotFound},
+		{"cross-origin GET", "GET", "/scans", "", map[string]string{"Origin": "https://evil.example", "Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(body))
+		req.SetBasicAuth("admin", "hunter2")
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+		}
+	}
+	if n := len(m.List()); n != 2 {
+		t.Errorf("%d jobs submitted, want 2", n)
+	}
+}
+
+// TestSelfSignedTLS tests serving over an auto-generated certificate
+func TestSelfSignedTLS(t *testing.T) {
+	cfg, err := TLSOptions{SelfSigned: true}.tlsConfig()
+	if err != nil {
+		t.Fatalf("tlsConfig() failed: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	if _, err := (TLSOptions{CertFile: "cert.pem"}).tlsConfig(); err == nil {
+		t.Error("tlsConfig() accepted a certificate without a key")
+	}
+	if cfg, _ := (TLSOptions{}).tlsConfig(); cfg != nil {
+		t.Error("tlsConfig() returned a config with TLS disabled")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxBannerLength = 256
+
+// bannerTimeout is how long to wait for a service banner on an open port.
+// Zero disables banner grabbing.
+var bannerTimeout time.Duration
+
+// grabBanner reads what the service sends on connect. Services that wait for
+// the client, such as web servers, are sent a HEAD request instead.
+func grabBanner(conn net.Conn, timeout time.Duration) string {
+	buf := make([]byte, 1024)
+	if conn.SetReadDeadline(time.Now().Add(timeout)) != nil {
+		return ""
+	}
+	n, _ := conn.Read(buf)
+	if n == 0 {
+		if conn.SetDeadline(time.Now().Add(timeout)) != nil {
+			return ""
+		}
+		if _, err := conn.Write([]byte("HEAD / HTTP/1.0\r\n\r\n")); err != nil {
+			return ""
+		}
+		n, _ = conn.Read(buf)
+	}
+	return cleanBanner(string(buf[:n]))
+}
+
+// cleanBanner keeps the banner printable and short, with line breaks
+// folded to " | "
+func cleanBanner(s string) string {
+	lines := strings.FieldsFunc(s, func(r rune) bool { return r == '\r' || r == '\n' })
+	s = strings.Join(lines, " | ")
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == 0xfffd {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > maxBannerLength {
+		// Cut on a rune boundary so no character is split
+		cut := maxBannerLength
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut]
+	}
+	return strings.TrimSpace(s)
+}
+
+// fingerprints map banners to CPE vendor and product names. The first
+// submatch is the version.
+var fingerprints = []struct {
+	re              *regexp.Regexp
+	vendor, product string
+}{
+	{regexp.MustCompile(`^SSH-[\d.]+-OpenSSH_([\w.]+)`), "openbsd", "openssh"},
+	{regexp.MustCompile(`^SSH-[\d.]+-dropbear_([\w.]+)`), "dropbear_ssh_project", "dropbear_ssh"},
+	{regexp.MustCompile(`vsFTPd ([\d.]+)`), "vsftpd_project", "vsftpd"},
+	{regexp.MustCompile(`ProFTPD ([\d.]+\w*)`), "proftpd", "proftpd"},
+	{regexp.MustCompile(`Exim ([\d.]+)`), "exim", "exim"},
+	{regexp.MustCompile(`(?i)Server: Apache/([\d.]+)`), "apache", "http_server"},
+	{regexp.MustCompile(`(?i)Server: nginx/([\d.]+)`), "f5", "nginx"},
+	{regexp.MustCompile(`(?i)Server: Microsoft-IIS/([\d.]+)`), "microsoft", "internet_information_services"},
+	{regexp.MustCompile(`(?i)Server: lighttpd/([\d.]+)`), "lighttpd", "lighttpd"},
+}
+
+// fingerprint returns the CPE 2.3 name of the product and version in
+// banner, or "" if it is not recognized
+func fingerprint(banner string) string {
+	for _, fp := range fingerprints {
+		if m := fp.re.FindStringSubmatch(banner); m != nil {
+			return "cpe:2.3:a:" + fp.vendor + ":" + fp.product + ":" + m[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy violation types
+const (
+	ViolationUnexpectedOpen = "unexpected_open"
+	ViolationExpectedDown   = "expected_down"
+)
+
+// BaselineRule lists the ports allowed, and those required, to be open on
+// a set of hosts
+type BaselineRule struct {
+	Name     string   `yaml:"name" json:"name"`
+	Targets  []string `yaml:"targets" json:"targets"`
+	Allowed  []int    `yaml:"allowed" json:"allowed"`
+	Required []int    `yaml:"required" json:"required"`
+
+	ranges []ipRange
+}
+
+// Baseline is the expected state of the network. Each host is checked
+// against the most specific rule covering it. With Strict set, open ports on
+// hosts no rule covers are violations too.
+type Baseline struct {
+	Strict bool           `yaml:"strict"`
+	Rules  []BaselineRule `yaml:"rules"`
+}
+
+// Violation is a difference between a scan and the baseline
+type Violation struct {
+	Type     string `json:"type"`
+	Rule     string `json:"rule,omitempty"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname,omitempty"`
+	Port     int    `json:"port"`
+	State    string `json:"state"`
+}
+
+func (v Violation) String() string {
+	rule := v.Rule
+	if rule == "" {
+		rule = "no rule"
+	}
+	host := ScanResult{IP: v.IP, Hostname: v.Hostname}.Host()
+	if v.Type == ViolationExpectedDown {
+		return fmt.Sprintf("Port %d on %s should be open but is %s (%s)", v.Port, host, v.State, rule)
+	}
+	return fmt.Sprintf("Port %d on %s is open but not allowed (%s)", v.Port, host, rule)
+}
+
+// RuleCompliance summarizes one rule's hosts
+type RuleCompliance struct {
+	Name       string `json:"name"`
+	Hosts      int    `json:"hosts"`
+	Open       int    `json:"open"`
+	Violations int    `json:"violations"`
+}
+
+// ComplianceReport is the result of checking a scan against the baseline
+type ComplianceReport struct {
+	ScanID      string           `json:"scan_id"`
+	GeneratedAt time.Time        `json:"generated_at"`
+	Compliant   bool             `json:"compliant"`
+	Hosts       int              `json:"hosts"`
+	Unmanaged   int              `json:"unmanaged_hosts"`
+	Rules       []RuleCompliance `json:"rules"`
+	Violations  []Violation      `json:"violations"`
+}
+
+// baseline is the loaded policy, or nil when none is configured
+var baseline *Baseline
+
+// compliance holds the latest compliance report
+var compliance complianceStore
+
+type complianceStore struct {
+	mu     sync.RWMutex
+	report *ComplianceReport
+}
+
+func (s *complianceStore) Set(r ComplianceReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report = &r
+}
+
+func (s *complianceStore) Get() (ComplianceReport, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.report == nil {
+		return ComplianceReport{}, false
+	}
+	return *s.report, true
+}
+
+// loadBaseline reads and validates a baseline policy file
+func loadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading baseline: %w", err)
+	}
+	var b Baseline
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&b); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parsing baseline %s: %w", path, err)
+	}
+	if err := b.validate(); err != nil {
+		return nil, fmt.Errorf("baseline %s: %w", path, err)
+	}
+	return &b, nil
+}
+
+func (b *Baseline) validate() error {
+	var errs []error
+	if len(b.Rules) == 0 {
+		errs = append(errs, errors.New("no rules"))
+	}
+	for i := range b.Rules {
+		rule := &b.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(rule.Targets) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no targets", rule.Name))
+		}
+		rule.ranges = nil
+		for _, target := range rule.Targets {
+			r, err := parseIPRange(target)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", rule.Name, err))
+				continue
+			}
+			rule.ranges = append(rule.ranges, r)
+		}
+		for _, p := range append(slices.Clone(rule.Allowed), rule.Required...) {
+			if p <= 0 || p > 65535 {
+				errs = append(errs, fmt.Errorf("%s: port %d is out of range 1-65535", rule.Name, p))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// match returns the rule with the smallest target range covering n, the
+// earliest rule winning ties
+func (b *Baseline) match(n uint32) *BaselineRule {
+	var best *BaselineRule
+	var bestSize uint64
+	for i := range b.Rules {
+		for _, r := range b.Rules[i].ranges {
+			if n >= r.lo && n <= r.hi && (best == nil || r.size() < bestSize) {
+				best, bestSize = &b.Rules[i], r.size()
+			}
+		}
+	}
+	return best
+}
+
+// needs reports whether r is a probe of a required port, which Evaluate
+// must see even when closed results are not kept
+func (b *Baseline) needs(r ScanResult) bool {
+	if b == nil || net.ParseIP(r.IP).To4() == nil {
+		return false
+	}
+	rule := b.match(ipToUint32(r.IP))
+	return rule != nil && slices.Contains(rule.Required, r.Port)
+}
+
+// Evaluate checks results against the baseline. Required ports only count
+// as down if they were probed.
+func (b *Baseline) Evaluate(scanID string, results []ScanResult) ComplianceReport {
+	report := ComplianceReport{ScanID: scanID, GeneratedAt: time.Now(), Violations: []Violation{}}
+	stats := make(map[string]*RuleCompliance, len(b.Rules))
+	for _, rule := range b.Rules {
+		report.Rules = append(report.Rules, RuleCompliance{Name: rule.Name})
+	}
+	for i := range report.Rules {
+		stats[report.Rules[i].Name] = &report.Rules[i]
+	}
+
+	byHost := make(map[string]map[int]ScanResult)
+	names := make(map[string]string)
+	for _, r := range results {
+		// Probes that failed in a proxy chain were not answered
+		if r.State() == StateError {
+			continue
+		}
+		if byHost[r.IP] == nil {
+			byHost[r.IP] = make(map[int]ScanResult)
+		}
+		byHost[r.IP][r.Port] = r
+		if r.Hostname != "" {
+			names[r.IP] = r.Hostname
+		}
+	}
+	hosts := make([]string, 0, len(byHost))
+	for ip := range byHost {
+		hosts = append(hosts, ip)
+	}
+	sort.Slice(hosts, func(a, c int) bool { return compareIPs(hosts[a], hosts[c]) < 0 })
+
+	for _, ip := range hosts {
+		ports := byHost[ip]
+		open := openPorts(ports)
+		report.Hosts++
+		var rule *BaselineRule
+		if net.ParseIP(ip).To4() != nil {
+			rule = b.match(ipToUint32(ip))
+		}
+		if rule == nil {
+			report.Unmanaged++
+			if b.Strict {
+				for _, p := range open {
+					report.Violations = append(report.Violations, Violation{Type: ViolationUnexpectedOpen, IP: ip, Hostname: names[ip], Port: p, State: StateOpen})
+				}
+			}
+			continue
+		}
+
+		st := stats[rule.Name]
+		st.Hosts++
+		st.Open += len(open)
+		for _, p := range open {
+			if !slices.Contains(rule.Allowed, p) && !slices.Contains(rule.Required, p) {
+				report.Violations = append(report.Violations, Violation{Type: ViolationUnexpectedOpen, Rule: rule.Name, IP: ip, Hostname: names[ip], Port: p, State: StateOpen})
+				st.Violations++
+			}
+		}
+		for _, p := range rule.Required {
+			if r, probed := ports[p]; probed && !r.Open {
+				report.Violations = append(report.Violations, Violation{Type: ViolationExpectedDown, Rule: rule.Name, IP: ip, Hostname: names[ip], Port: p, State: r.State()})
+				st.Violations++
+			}
+		}
+	}
+	report.Compliant = len(report.Violations) == 0
+	return report
+}
+
+func openPorts(ports map[int]ScanResult) []int {
+	var open []int
+	for p, r := range ports {
+		if r.Open {
+			open = append(open, p)
+		}
+	}
+	sort.Ints(open)
+	return open
+}
+
+// sendScanSummary sends the end-of-scan email for rs. Without a baseline it
+// lists every open port. With one, violations are published and alerted on
+// their own and the compliance report is sent instead of the port list.
+// Either links the run's HTML report, written from st and rs.
+func sendScanSummary(jobID string, ports []int, st *ScanStatus, rs *ResultStore) {
+	scanID := jobID
+	if scanID == "" {
+		scanID = status.ScanID()
+	}
+	reportURL := saveRunReport(scanID, jobID, ports, st, rs)
+	if baseline == nil {
+		hosts := make(map[string]HostStats)
+		for _, h := range rs.Hosts() {
+			hosts[h.IP] = h
+		}
+		notify(MsgSummary, summaryData{ScanID: scanID, JobID: jobID, Results: rs.Open(), ReportURL: reportURL, Hosts: hosts})
+		return
+	}
+
+	report := baseline.Evaluate(scanID, rs.All())
+	compliance.Set(report)
+	slog.Info("compliance checked", "scan_id", scanID, "hosts", report.Hosts, "violations", len(report.Violations))
+
+	if len(report.Violations) > 0 {
+		for _, v := range report.Violations {
+			events.Publish(EventPolicyViolation, jobID, v)
+			slog.Warn("policy violation", "scan_id", scanID, "ip", v.IP, "port", v.Port, "state", v.State, "type", v.Type, "rule", v.Rule)
+		}
+		notify(MsgViolations, violationsData{ScanID: scanID, JobID: jobID, Violations: report.Violations})
+	}
+	notify(MsgCompliance, complianceData{JobID: jobID, Report: report, ReportURL: reportURL})
+}
+
+func handleCompliance(w http.ResponseWriter, r *http.Request) {
+	if baseline == nil {
+		writeError(w, http.StatusNotFound, "no baseline policy configured")
+		return
+	}
+	report, ok := compliance.Get()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no scan has been checked yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
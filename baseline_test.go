@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoadBaseline tests parsing and validating baseline files
+func TestLoadBaseline(t *testing.T) {
+	b, err := loadBaseline("baseline.example.yaml")
+	if err != nil {
+		t.Fatalf("loadBaseline() failed on the example: %v", err)
+	}
+	if len(b.Rules) != 3 || b.match(ipToUint32("192.168.1.10")).Name != "primary web server" {
+		t.Errorf("loadBaseline() = %+v", b)
+	}
+
+	for _, bad := range []string{
+		"rules: []",
+		"rules:\n  - name: x\n    allowed: [80]",
+		"rules:\n  - targets: [10.0.0.0/33]",
+		"rules:\n  - targets: [10.0.0.1]\n    allowed: [0]",
+		"rules:\n  - targets: [10.0.0.1]\n    denied: [22]",
+	} {
+		if _, err := loadBaseline(writeConfig(t, bad)); err == nil {
+			t.Errorf("loadBaseline() accepted %q", bad)
+		}
+	}
+}
+
+// TestBaselineEvaluate tests unexpected open ports, required ports that are
+// down and strict mode
+func TestBaselineEvaluate(t *testing.T) {
+	b := &Baseline{Rules: []BaselineRule{
+		{Name: "web", Targets: []string{"10.0.0.0/24"}, Allowed: []int{80, 443}},
+		{Name: "db", Targets: []string{"10.0.0.20"}, Required: []int{5432}},
+	}}
+	if err := b.validate(); err != nil {
+		t.Fatal(err)
+	}
+	scan := []ScanResult{
+		{IP: "10.0.0.5", Port: 80, Open: true},
+		{IP: "10.0.0.5", Port: 22, Open: true},
+		{IP: "10.0.0.20", Port: 80, Open: true},
+		{IP: "10.0.0.20", Port: 5432, Error: timeoutError{}},
+		{IP: "10.0.0.21", Port: 5432},
+		{IP: "10.9.0.1", Port: 22, Open: true},
+	}
+
+	report := b.Evaluate("loop-1", scan)
+	want := []Violation{
+		{Type: ViolationUnexpectedOpen, Rule: "web", IP: "10.0.0.5", Port: 22, State: StateOpen},
+		{Type: ViolationUnexpectedOpen, Rule: "db", IP: "10.0.0.20", Port: 80, State: StateOpen},
+		{Type: ViolationExpectedDown, Rule: "db", IP: "10.0.0.20", Port: 5432, State: StateFiltered},
+	}
+	if len(report.Violations) != len(want) {
+		t.Fatalf("violations = %+v", report.Violations)
+	}
+	for i := range want {
+		if report.Violations[i] != want[i] {
+			t.Errorf("violation %d = %+v, want %+v", i, report.Violations[i], want[i])
+		}
+	}
+	if report.Compliant || report.Hosts != 4 || report.Unmanaged != 1 || report.Rules[0].Hosts != 2 || report.Rules[1].Violations != 2 {
+		t.Errorf("report = %+v", report)
+	}
+	msg, err := defaultTemplates.render(MsgCompliance, complianceData{Report: report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := msg.Text; !strings.Contains(text, "3 violation(s)") || !strings.Contains(text, "Port 5432 on 10.0.0.20 should be open but is filtered (db)") {
+		t.Errorf("Text() = %q", text)
+	}
+
+	b.Strict = true
+	if report := b.Evaluate("loop-1", scan); len(report.Violations) != 4 || report.Violations[3].IP != "10.9.0.1" {
+		t.Errorf("strict violations = %+v", report.Violations)
+	}
+
+	if report := b.Evaluate("loop-2", scan[:1]); !report.Compliant {
+		t.Errorf("compliant scan reported %+v", report.Violations)
+	}
+}
+
+// TestSendScanSummaryWithBaseline tests violation alerts, events and the
+// compliance endpoint
+func TestSendScanSummaryWithBaseline(t *testing.T) {
+	originalSend := sendFunc
+	originalImpl := sendImpl
+	originalBaseline := baseline
+	originalEvents := events
+	defer func() {
+		sendFunc = originalSend
+		sendImpl = originalImpl
+		baseline = originalBaseline
+		events = originalEvents
+	}()
+	events = NewEventBus(16)
+	var sent []Email
+	sendFunc = func(e Email) { sent = append(sent, e) }
+	sendImpl = func(e Email) (int, time.Duration) { return 200, 0 }
+
+	rec := httptest.NewRecorder()
+	handleCompliance(rec, httptest.NewRequest(http.MethodGet, "/compliance", nil))
+	if baseline == nil && rec.Code != http.StatusNotFound {
+		t.Errorf("GET /compliance without a baseline = %d", rec.Code)
+	}
+
+	baseline = &Baseline{Rules: []BaselineRule{{Name: "web", Targets: []string{"10.0.0.0/24"}, Allowed: []int{443}}}}
+	if err := baseline.validate(); err != nil {
+		t.Fatal(err)
+	}
+	rs := NewResultStore()
+	rs.Add(ScanResult{IP: "10.0.0.1", Port: 443, Open: true})
+	rs.Add(ScanResult{IP: "10.0.0.1", Port: 3389, Open: true})
+	rs.Add(ScanResult{IP: "10.0.0.2", Port: 3389, Error: errors.New("connection refused")})
+
+	sendScanSummary("job1", nil, NewScanStatus(), rs)
+
+	if len(sent) != 2 || sent[0].Subject != "Policy violations: 1 found" || !strings.Contains(sent[0].Msg, "Port 3389 on 10.0.0.1") {
+		t.Fatalf("alerts sent = %+v", sent)
+	}
+	if sent[1].Subject != "Compliance report for job job1" || strings.Contains(sent[1].Msg, "Port 443 on") {
+		t.Errorf("report email = %+v", sent[1])
+	}
+
+	replay, _, cancel := events.Subscribe(0)
+	cancel()
+	if len(replay) != 1 || replay[0].Type != EventPolicyViolation || replay[0].JobID != "job1" {
+		t.Errorf("events = %+v, want one policy_violation", replay)
+	}
+
+	rec = httptest.NewRecorder()
+	handleCompliance(rec, httptest.NewRequest(http.MethodGet, "/compliance", nil))
+	var report ComplianceReport
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&report) != nil || report.ScanID != "job1" || len(report.Violations) != 1 {
+		t.Errorf("GET /compliance = %d %+v", rec.Code, report)
+	}
+}
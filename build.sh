@@ -3,4 +3,4 @@ set -e
 set -x          
 clear
 
-go build -o portscanner email.go portscanner.go structs.go helpers.go
\ No newline at end of file
+go build -o portscanner .
\ No newline at end of file
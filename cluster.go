@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cluster modes
+const (
+	ModeCoordinator = "coordinator"
+	ModeWorker      = "worker"
+)
+
+// Work unit states
+const (
+	unitPending = "pending"
+	unitLeased  = "leased"
+	unitDone    = "done"
+)
+
+const (
+	defaultLeaseTimeout = 2 * time.Minute
+	workerBatchSize     = 1000
+	workerPollInterval  = 2 * time.Second
+)
+
+var (
+	errLeaseLost         = errors.New("lease lost")
+	errUnitNotFound      = errors.New("work unit not found")
+	errCoordinatorClosed = errors.New("coordinator closed")
+	errDispatchRunning   = errors.New("a distributed scan is already running")
+)
+
+// ClusterConfig selects standalone, coordinator or worker mode
+type ClusterConfig struct {
+	Mode           string        `yaml:"mode"`
+	CoordinatorURL string        `yaml:"coordinator_url"`
+	WorkerID       string        `yaml:"worker_id"`
+	LeaseTimeout   time.Duration `yaml:"lease_timeout"`
+}
+
+// WorkUnit is one chunk of a distributed scan. Randomized scans hand out
+// runs of permutation positions instead of address ranges.
+type WorkUnit struct {
+	ID           string        `json:"id"`
+	Start        string        `json:"start"`
+	End          string        `json:"end"`
+	Ports        []int         `json:"ports"`
+	Timeout      time.Duration `json:"timeout"`
+	Concurrent   int           `json:"concurrent"`
+	Random       bool          `json:"random,omitempty"`
+	Seed         int64         `json:"seed,omitempty"`
+	From         uint64        `json:"from,omitempty"`
+	To           uint64        `json:"to,omitempty"`
+	LeaseTimeout time.Duration `json:"lease_timeout"`
+}
+
+// planUnits splits a scan into work units the same way scanRange splits it
+// into chunks
+func planUnits(startIP, endIP string, ports []int, timeout time.Duration, maxConcurrent, chunkSize int, order TargetOrder) []WorkUnit {
+	start, end := ipToUint32(startIP), ipToUint32(endIP)
+	if start > end {
+		return nil
+	}
+	base := WorkUnit{Start: startIP, End: endIP, Ports: ports, Timeout: timeout, Concurrent: maxConcurrent}
+	var units []WorkUnit
+	if order.Random {
+		space := newTargetSpace(start, end, ports, order.Seed)
+		step := uint64(chunkSize) * uint64(len(ports))
+		for pos := uint64(0); pos < space.Len(); pos += step {
+			u := base
+			u.Random, u.Seed = true, order.Seed
+			u.From, u.To = pos, min(pos+step, space.Len())-1
+			units = append(units, u)
+		}
+		return units
+	}
+	for chunkStart := uint64(start); chunkStart <= uint64(end); chunkStart += uint64(chunkSize) {
+		u := base
+		u.Start = uint32ToIP(uint32(chunkStart))
+		u.End = uint32ToIP(uint32(min(chunkStart+uint64(chunkSize)-1, uint64(end))))
+		units = append(units, u)
+	}
+	return units
+}
+
+type leasedUnit struct {
+	WorkUnit
+	state    string
+	worker   string
+	expires  time.Time
+	attempts int
+	// space numbers the unit's probes and delivered has a bit set for each
+	// one already passed on, kept across reassignments
+	space     *targetSpace
+	delivered []uint64
+}
+
+// probe returns the number of r among the unit's probes, or false if r is
+// not one of them
+func (u *leasedUnit) probe(r ScanResult) (uint64, bool) {
+	if u.space == nil {
+		if u.Random {
+			u.space = newTargetSpace(ipToUint32(u.Start), ipToUint32(u.End), u.Ports, u.Seed)
+		} else {
+			u.space = newTargetSpace(ipToUint32(u.Start), ipToUint32(u.End), u.Ports, 0)
+		}
+	}
+	if !u.Random {
+		return u.space.index(r.IP, r.Port)
+	}
+	pos, ok := u.space.Position(r.IP, r.Port)
+	if !ok || pos < u.From || pos > u.To {
+		return 0, false
+	}
+	return pos - u.From, true
+}
+
+// fresh returns the results of batch that belong to the unit and were not
+// passed on before, marking them delivered
+func (u *leasedUnit) fresh(batch []ScanResult) []ScanResult {
+	var out []ScanResult
+	for _, r := range batch {
+		i, ok := u.probe(r)
+		if !ok {
+			slog.Warn("dropping result outside work unit", "unit", u.ID, "ip", r.IP, "port", r.Port)
+			continue
+		}
+		if u.delivered == nil {
+			u.delivered = make([]uint64, (u.count()+63)/64)
+		}
+		if u.delivered[i/64]&(1<<(i%64)) != 0 {
+			continue
+		}
+		u.delivered[i/64] |= 1 << (i % 64)
+		out = append(out, r)
+	}
+	return out
+}
+
+// count returns the number of probes in the unit
+func (u *leasedUnit) count() uint64 {
+	if u.Random {
+		return u.To - u.From + 1
+	}
+	return u.space.Len()
+}
+
+// Coordinator hands work units to workers under leases. A lease is renewed
+// by every report; units whose lease runs out are given to the next worker
+// that asks. Results are passed on as they are reported, and probes of a
+// reassigned unit that were already reported are not passed on again.
+type Coordinator struct {
+	mu           sync.Mutex
+	units        []*leasedUnit
+	byID         map[string]*leasedUnit
+	remaining    int
+	done         chan struct{}
+	workers      map[string]time.Time
+	closed       bool
+	leaseTimeout time.Duration
+	onResult     func(ScanResult)
+	onUnitDone   func(WorkUnit)
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func NewCoordinator(leaseTimeout time.Duration, onResult func(ScanResult), onUnitDone func(WorkUnit)) *Coordinator {
+	if leaseTimeout <= 0 {
+		leaseTimeout = defaultLeaseTimeout
+	}
+	return &Coordinator{
+		byID:         make(map[string]*leasedUnit),
+		workers:      make(map[string]time.Time),
+		leaseTimeout: leaseTimeout,
+		onResult:     onResult,
+		onUnitDone:   onUnitDone,
+		now:          time.Now,
+	}
+}
+
+// Dispatch offers units to workers and blocks until every unit is done or
+// ctx is done
+func (c *Coordinator) Dispatch(ctx context.Context, units []WorkUnit) error {
+	c.mu.Lock()
+	if c.remaining > 0 {
+		c.mu.Unlock()
+		return errDispatchRunning
+	}
+	batch := newJobID()
+	c.units = make([]*leasedUnit, len(units))
+	c.byID = make(map[string]*leasedUnit, len(units))
+	for i, u := range units {
+		u.ID = fmt.Sprintf("%s-%d", batch, i)
+		c.units[i] = &leasedUnit{WorkUnit: u, state: unitPending}
+		c.byID[u.ID] = c.units[i]
+	}
+	c.remaining = len(units)
+	done := make(chan struct{})
+	c.done = done
+	if c.remaining == 0 {
+		close(done)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		c.units, c.byID, c.remaining = nil, map[string]*leasedUnit{}, 0
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Close makes further lease requests fail so workers exit
+func (c *Coordinator) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+}
+
+// expireLocked returns units with expired leases to the pending pool
+func (c *Coordinator) expireLocked(now time.Time) {
+	for _, u := range c.units {
+		if u.state == unitLeased && now.After(u.expires) {
+			slog.Warn("lease expired, reassigning unit", "unit", u.ID, "worker", u.worker)
+			u.state, u.worker = unitPending, ""
+		}
+	}
+}
+
+// Lease assigns the next pending unit to worker. It returns false if no unit
+// is available right now.
+func (c *Coordinator) Lease(worker string) (WorkUnit, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return WorkUnit{}, false, errCoordinatorClosed
+	}
+	now := c.now()
+	c.workers[worker] = now
+	c.expireLocked(now)
+	for _, u := range c.units {
+		if u.state != unitPending {
+			continue
+		}
+		u.state, u.worker = unitLeased, worker
+		u.expires = now.Add(c.leaseTimeout)
+		u.attempts++
+		unit := u.WorkUnit
+		unit.LeaseTimeout = c.leaseTimeout
+		return unit, true, nil
+	}
+	return WorkUnit{}, false, nil
+}
+
+// Report records results from the worker holding the lease on unit id and
+// renews the lease, passing the results on. With done set the unit is
+// complete.
+func (c *Coordinator) Report(id, worker string, batch []ScanResult, done bool) error {
+	c.mu.Lock()
+	now := c.now()
+	c.workers[worker] = now
+	u, ok := c.byID[id]
+	if !ok {
+		c.mu.Unlock()
+		return errUnitNotFound
+	}
+	if u.state == unitLeased && now.After(u.expires) {
+		c.expireLocked(now)
+	}
+	if u.state != unitLeased || u.worker != worker {
+		c.mu.Unlock()
+		return errLeaseLost
+	}
+	u.expires = now.Add(c.leaseTimeout)
+	fresh := u.fresh(batch)
+	if done {
+		u.state = unitDone
+		u.space, u.delivered = nil, nil
+	}
+	unit := u.WorkUnit
+	c.mu.Unlock()
+
+	for _, r := range fresh {
+		if c.onResult != nil {
+			c.onResult(r)
+		}
+	}
+	if !done {
+		return nil
+	}
+	if c.onUnitDone != nil {
+		c.onUnitDone(unit)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Dispatch may have been abandoned while the results were delivered
+	if c.byID[id] == u {
+		c.remaining--
+		if c.remaining == 0 {
+			close(c.done)
+		}
+	}
+	return nil
+}
+
+// WorkerSnapshot is the JSON view of a worker
+type WorkerSnapshot struct {
+	ID       string    `json:"id"`
+	LastSeen time.Time `json:"last_seen"`
+	Units    []string  `json:"units"`
+}
+
+// ClusterSnapshot is the JSON view of the coordinator
+type ClusterSnapshot struct {
+	Pending  int              `json:"pending"`
+	Leased   int              `json:"leased"`
+	Done     int              `json:"done"`
+	Attempts int              `json:"attempts"`
+	Workers  []WorkerSnapshot `json:"workers"`
+}
+
+func (c *Coordinator) Snapshot() ClusterSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked(c.now())
+
+	var snap ClusterSnapshot
+	leases := make(map[string][]string)
+	for _, u := range c.units {
+		snap.Attempts += u.attempts
+		switch u.state {
+		case unitPending:
+			snap.Pending++
+		case unitLeased:
+			snap.Leased++
+			leases[u.worker] = append(leases[u.worker], u.ID)
+		case unitDone:
+			snap.Done++
+		}
+	}
+	snap.Workers = make([]WorkerSnapshot, 0, len(c.workers))
+	for id, seen := range c.workers {
+		snap.Workers = append(snap.Workers, WorkerSnapshot{ID: id, LastSeen: seen, Units: append([]string{}, leases[id]...)})
+	}
+	sort.Slice(snap.Workers, func(a, b int) bool { return snap.Workers[a].ID < snap.Workers[b].ID })
+	return snap
+}
+
+type leaseRequest struct {
+	Worker string `json:"worker"`
+}
+
+type resultReport struct {
+	Worker  string       `json:"worker"`
+	Results []ScanResult `json:"results"`
+	Done    bool         `json:"done"`
+}
+
+// registerClusterAPI adds the coordinator endpoints to mux
+func registerClusterAPI(mux *http.ServeMux, c *Coordinator) {
+	mux.HandleFunc("POST /cluster/lease", c.handleLease)
+	mux.HandleFunc("POST /cluster/units/{id}/results", c.handleReport)
+	mux.HandleFunc("GET /cluster/status", c.handleStatus)
+}
+
+func (c *Coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil || req.Worker == "" {
+		writeError(w, http.StatusBadRequest, "a worker ID is required")
+		return
+	}
+	unit, ok, err := c.Lease(req.Worker)
+	switch {
+	case errors.Is(err, errCoordinatorClosed):
+		writeError(w, http.StatusGone, "%v", err)
+	case !ok:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusOK, unit)
+	}
+}
+
+func (c *Coordinator) handleReport(w http.ResponseWriter, r *http.Request) {
+	var report resultReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<20)).Decode(&report); err != nil || report.Worker == "" {
+		writeError(w, http.StatusBadRequest, "invalid report: %v", err)
+		return
+	}
+	err := c.Report(r.PathValue("id"), report.Worker, report.Results, report.Done)
+	switch {
+	case errors.Is(err, errUnitNotFound):
+		writeError(w, http.StatusNotFound, "%v", err)
+	case errors.Is(err, errLeaseLost):
+		writeError(w, http.StatusConflict, "%v", err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (c *Coordinator) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, c.Snapshot())
+}
+
+// scanCluster is scanRange for coordinator mode: the scan is split into
+// units for workers and their results are recorded as they complete
+func scanCluster(ctx context.Context, c *Coordinator, startIP, endIP string, ports []int, timeout time.Duration, maxConcurrent, chunkSize int) error {
+	status.begin(startIP, endIP, scope.Count(ipToUint32(startIP), ipToUint32(endIP), ports))
+	defer status.finish()
+
+	units := planUnits(startIP, endIP, ports, timeout, maxConcurrent, chunkSize, targetOrder)
+	slog.Info("waiting for workers", "scan_id", status.ScanID(), "units", len(units))
+	if err := c.Dispatch(ctx, units); err != nil {
+		return err
+	}
+
+	snap := status.Snapshot()
+	events.Publish(EventScanComplete, "", ScanComplete{Start: startIP, End: endIP, Probes: snap.Completed, Open: snap.Open, ElapsedSeconds: snap.ElapsedSeconds})
+	return nil
+}
+
+// publishUnitDone reports a finished unit as a completed chunk
+func publishUnitDone(u WorkUnit) {
+	events.Publish(EventChunkComplete, "", ChunkComplete{Start: u.Start, End: u.End, FromPosition: u.From, ToPosition: u.To})
+}
+
+// Worker leases units from a coordinator, scans them and reports back
+type Worker struct {
+	ID           string
+	URL          string
+	Auth         AuthConfig
+	Client       *http.Client
+	PollInterval time.Duration
+}
+
+func NewWorker(coordinatorURL, id string, auth AuthConfig) *Worker {
+	if id == "" {
+		host, _ := os.Hostname()
+		id = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	return &Worker{
+		ID:           id,
+		URL:          strings.TrimRight(coordinatorURL, "/"),
+		Auth:         auth,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+		PollInterval: workerPollInterval,
+	}
+}
+
+// Run works until ctx is done or the coordinator shuts down
+func (w *Worker) Run(ctx context.Context) error {
+	logger := slog.With("worker", w.ID)
+	logger.Info("polling coordinator", "url", w.URL)
+	for ctx.Err() == nil {
+		unit, ok, err := w.lease(ctx)
+		if errors.Is(err, errCoordinatorClosed) {
+			logger.Info("coordinator closed, exiting")
+			return nil
+		}
+		if err != nil {
+			logger.Error("leasing work", "error", err)
+		}
+		if err != nil || !ok {
+			w.sleep(ctx)
+			continue
+		}
+		unitLogger := logger.With("unit", unit.ID)
+		unitLogger.Info("leased unit")
+		if err := w.work(withLogger(ctx, unitLogger), unit); err != nil {
+			unitLogger.Warn("unit abandoned", "error", err)
+		}
+	}
+	return ctx.Err()
+}
+
+func (w *Worker) sleep(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(w.PollInterval):
+	}
+}
+
+// work scans unit, reporting results in batches. Reports double as lease
+// renewals, so one is sent at least every third of the lease timeout.
+func (w *Worker) work(ctx context.Context, unit WorkUnit) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resultChan := make(chan ScanResult, max(unit.Concurrent, 1))
+	go func() {
+		defer close(resultChan)
+		if unit.Random {
+			space := newTargetSpace(ipToUint32(unit.Start), ipToUint32(unit.End), unit.Ports, unit.Seed)
+			scanPositions(ctx, space, unit.From, unit.To, unit.Timeout, unit.Concurrent, resultChan)
+		} else {
+			scanChunkContext(ctx, ipToUint32(unit.Start), ipToUint32(unit.End), unit.Ports, unit.Timeout, unit.Concurrent, resultChan)
+		}
+	}()
+	abandon := func(err error) error {
+		cancel()
+		for range resultChan {
+		}
+		return err
+	}
+
+	heartbeat := time.NewTicker(max(unit.LeaseTimeout/3, 10*time.Millisecond))
+	defer heartbeat.Stop()
+	var batch []ScanResult
+	// failing holds reports back to the heartbeat while the coordinator
+	// is not accepting them
+	failing := false
+	for {
+		select {
+		case r, ok := <-resultChan:
+			if !ok {
+				if ctx.Err() != nil {
+					// Interrupted: let the lease expire so another worker
+					// rescans the whole unit
+					return ctx.Err()
+				}
+				return w.finish(ctx, unit.ID, batch)
+			}
+			batch = append(batch, r)
+			if len(batch) < workerBatchSize || failing {
+				continue
+			}
+		case <-heartbeat.C:
+		}
+		err := w.report(ctx, unit.ID, batch, false)
+		if errors.Is(err, errLeaseLost) {
+			return abandon(err)
+		}
+		if err != nil {
+			// Keep the batch and try again on the next heartbeat
+			loggerFrom(ctx).Error("reporting results", "error", err)
+			failing = true
+			continue
+		}
+		batch, failing = nil, false
+	}
+}
+
+// finish sends the final report, retrying until it is accepted or the lease
+// is known to be lost
+func (w *Worker) finish(ctx context.Context, id string, batch []ScanResult) error {
+	for {
+		err := w.report(ctx, id, batch, true)
+		if err == nil || errors.Is(err, errLeaseLost) || ctx.Err() != nil {
+			return err
+		}
+		loggerFrom(ctx).Error("reporting results", "error", err)
+		w.sleep(ctx)
+	}
+}
+
+func (w *Worker) lease(ctx context.Context) (WorkUnit, bool, error) {
+	var unit WorkUnit
+	resp, err := w.post(ctx, "/cluster/lease", leaseRequest{Worker: w.ID})
+	if err != nil {
+		return unit, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&unit); err != nil {
+			return unit, false, err
+		}
+		return unit, true, nil
+	case http.StatusNoContent:
+		return unit, false, nil
+	case http.StatusGone:
+		return unit, false, errCoordinatorClosed
+	}
+	return unit, false, fmt.Errorf("lease request failed: %s", resp.Status)
+}
+
+func (w *Worker) report(ctx context.Context, id string, batch []ScanResult, done bool) error {
+	if batch == nil {
+		batch = []ScanResult{}
+	}
+	resp, err := w.post(ctx, "/cluster/units/"+id+"/results", resultReport{Worker: w.ID, Results: batch, Done: done})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusConflict, http.StatusNotFound:
+		return errLeaseLost
+	}
+	return fmt.Errorf("report failed: %s", resp.Status)
+}
+
+func (w *Worker) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Auth.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Auth.Token)
+	} else if w.Auth.Username != "" {
+		req.SetBasicAuth(w.Auth.Username, w.Auth.Password)
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, errors.New("coordinator rejected the worker's credentials")
+	}
+	return resp, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPlanUnits tests splitting a scan into work units
+func TestPlanUnits(t *testing.T) {
+	units := planUnits("10.0.0.0", "10.0.0.9", []int{80, 443}, time.Second, 10, 4, TargetOrder{})
+	if len(units) != 3 || units[0].End != "10.0.0.3" || units[2].Start != "10.0.0.8" || units[2].End != "10.0.0.9" {
+		t.Errorf("planUnits() = %+v", units)
+	}
+
+	random := planUnits("10.0.0.0", "10.0.0.9", []int{80, 443}, time.Second, 10, 4, TargetOrder{Random: true, Seed: 5})
+	if len(random) != 3 || random[1].From != 8 || random[1].To != 15 || random[2].To != 19 || !random[2].Random || random[2].Seed != 5 {
+		t.Errorf("planUnits() in random order = %+v", random)
+	}
+}
+
+// TestScanResultJSONRoundTrip tests that results survive the trip from a
+// worker to the coordinator
+func TestScanResultJSONRoundTrip(t *testing.T) {
+	in := []ScanResult{
+		{IP: "10.0.0.1", Port: 22, Open: true},
+		{IP: "10.0.0.1", Port: 23, Error: errors.New("connection refused")},
+		{IP: "10.0.0.1", Port: 25, Error: timeoutError{}},
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []ScanResult
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	for i := range in {
+		if out[i].IP != in[i].IP || out[i].Port != in[i].Port || out[i].State() != in[i].State() {
+			t.Errorf("result %d = %+v (%s), want %+v (%s)", i, out[i], out[i].State(), in[i], in[i].State())
+		}
+	}
+	if out[1].Error == nil || out[1].Error.Error() != "connection refused" {
+		t.Errorf("error not restored: %v", out[1].Error)
+	}
+}
+
+// TestCoordinatorLeases tests lease expiry and reassignment
+func TestCoordinatorLeases(t *testing.T) {
+	var mu sync.Mutex
+	var got []ScanResult
+	c := NewCoordinator(time.Minute, func(r ScanResult) {
+		mu.Lock()
+		got = append(got, r)
+		mu.Unlock()
+	}, nil)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	dispatched := make(chan error, 1)
+	go func() {
+		dispatched <- c.Dispatch(context.Background(), planUnits("10.0.0.0", "10.0.0.3", []int{80}, time.Second, 1, 2, TargetOrder{}))
+	}()
+	var a, b WorkUnit
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		var ok bool
+		if a, ok, _ = c.Lease("a"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no unit to lease")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	b, ok, _ := c.Lease("b")
+	if !ok || b.ID == a.ID || b.LeaseTimeout != time.Minute {
+		t.Fatalf("second lease = %+v, %v", b, ok)
+	}
+	if _, ok, _ := c.Lease("c"); ok {
+		t.Fatal("Lease() handed out a unit that is already leased")
+	}
+
+	// b keeps its lease alive, a goes silent
+	now = now.Add(40 * time.Second)
+	if err := c.Report(b.ID, "b", []ScanResult{{IP: "10.0.0.2", Port: 80}}, false); err != nil {
+		t.Fatalf("Report() heartbeat failed: %v", err)
+	}
+	now = now.Add(40 * time.Second)
+	if snap := c.Snapshot(); snap.Pending != 1 || snap.Leased != 1 {
+		t.Errorf("Snapshot() after expiry = %+v", snap)
+	}
+	if err := c.Report(a.ID, "a", []ScanResult{{IP: "10.0.0.0", Port: 80}}, true); !errors.Is(err, errLeaseLost) {
+		t.Errorf("Report() from expired worker = %v, want errLeaseLost", err)
+	}
+	retry, ok, _ := c.Lease("c")
+	if !ok || retry.ID != a.ID {
+		t.Fatalf("expired unit was not reassigned: %+v", retry)
+	}
+
+	if err := c.Report(b.ID, "b", []ScanResult{{IP: "10.0.0.3", Port: 80}}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Report(retry.ID, "c", []ScanResult{{IP: "10.0.0.0", Port: 80}, {IP: "10.0.0.1", Port: 80, Open: true}}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-dispatched; err != nil {
+		t.Fatalf("Dispatch() = %v", err)
+	}
+	if len(got) != 4 {
+		t.Errorf("coordinator recorded %d results, want 4: %+v", len(got), got)
+	}
+	if snap := c.Snapshot(); snap.Done != 2 || snap.Attempts != 3 || len(snap.Workers) != 3 {
+		t.Errorf("final Snapshot() = %+v", snap)
+	}
+
+	c.Close()
+	if _, _, err := c.Lease("a"); !errors.Is(err, errCoordinatorClosed) {
+		t.Errorf("Lease() after Close() = %v", err)
+	}
+}
+
+// TestCoordinatorStreamsResults tests that results are passed on as they
+// are reported and that a reassigned unit's probes are only passed on once
+func TestCoordinatorStreamsResults(t *testing.T) {
+	for _, order := range []TargetOrder{{}, {Random: true, Seed: 7}} {
+		var mu sync.Mutex
+		got := make(map[string]int)
+		c := NewCoordinator(time.Minute, func(r ScanResult) {
+			mu.Lock()
+			got[fmt.Sprintf("%s:%d", r.IP, r.Port)]++
+			mu.Unlock()
+		}, nil)
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		c.now = func() time.Time { return now }
+
+		units := planUnits("10.0.0.0", "10.0.0.3", []int{22, 80}, time.Second, 1, 4, order)
+		if len(units) != 1 {
+			t.Fatalf("planUnits() = %d units, want 1", len(units))
+		}
+		dispatched := make(chan error, 1)
+		go func() { dispatched <- c.Dispatch(context.Background(), units) }()
+		var u WorkUnit
+		for deadline := time.Now().Add(2 * time.Second); ; {
+			var ok bool
+			if u, ok, _ = c.Lease("a"); ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("no unit to lease")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		var all []ScanResult
+		for _, ip := range []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+			all = append(all, ScanResult{IP: ip, Port: 22}, ScanResult{IP: ip, Port: 80})
+		}
+		if err := c.Report(u.ID, "a", all[:3], false); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 3 {
+			t.Errorf("%+v: %d results passed on before the unit finished, want 3", order, len(got))
+		}
+
+		// a dies and c rescans the whole unit
+		now = now.Add(2 * time.Minute)
+		retry, ok, _ := c.Lease("c")
+		if !ok || retry.ID != u.ID {
+			t.Fatalf("expired unit was not reassigned: %+v", retry)
+		}
+		stray := ScanResult{IP: "10.0.0.4", Port: 22}
+		if err := c.Report(retry.ID, "c", append(all, stray), true); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-dispatched; err != nil {
+			t.Fatalf("Dispatch() = %v", err)
+		}
+		if len(got) != len(all) {
+			t.Errorf("%+v: %d distinct results passed on, want %d: %v", order, len(got), len(all), got)
+		}
+		for k, n := range got {
+			if n != 1 {
+				t.Errorf("%+v: %s passed on %d times", order, k, n)
+			}
+		}
+	}
+}
+
+// TestWorkerReportBackoff tests that a worker whose reports fail waits for
+// the heartbeat instead of reporting every new result
+func TestWorkerReportBackoff(t *testing.T) {
+	originalDial, originalScope := dialTimeout, scope
+	defer func() { dialTimeout, scope = originalDial, originalScope }()
+	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	scope, _ = loadScope(ScopeConfig{Override: true})
+
+	var mu sync.Mutex
+	failed, final := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report resultReport
+		json.NewDecoder(r.Body).Decode(&report)
+		mu.Lock()
+		defer mu.Unlock()
+		if !report.Done {
+			failed++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		final = len(report.Results)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	w := NewWorker(server.URL, "w", AuthConfig{})
+	unit := WorkUnit{ID: "u", Start: "10.0.0.0", End: "10.0.3.255", Ports: []int{80, 443, 8080, 8443}, Timeout: time.Millisecond, Concurrent: 64, LeaseTimeout: time.Minute}
+	if err := w.work(context.Background(), unit); err != nil {
+		t.Fatalf("work() = %v", err)
+	}
+	if failed != 1 {
+		t.Errorf("worker sent %d reports to a failing coordinator, want 1", failed)
+	}
+	if final != 4096 {
+		t.Errorf("final report carried %d results, want 4096", final)
+	}
+}
+
+// TestClusterWorkerProcess is run as a child process by TestClusterProcesses
+func TestClusterWorkerProcess(t *testing.T) {
+	coordinatorURL := os.Getenv("PORTSCANNER_TEST_COORDINATOR")
+	if coordinatorURL == "" {
+		t.Skip("helper process for TestClusterProcesses")
+	}
+	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		if _, port, _ := net.SplitHostPort(address); port == "22" {
+			return &net.TCPConn{}, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+	scope, _ = loadScope(ScopeConfig{Override: true})
+
+	w := NewWorker(coordinatorURL, os.Getenv("PORTSCANNER_TEST_WORKER"), AuthConfig{Token: "cluster-secret"})
+	w.PollInterval = 10 * time.Millisecond
+	if os.Getenv("PORTSCANNER_TEST_CRASH") != "" {
+		// Take a unit and die without reporting it
+		if _, ok, err := w.lease(context.Background()); !ok || err != nil {
+			t.Fatalf("crashing worker got no unit: %v", err)
+		}
+		os.Exit(3)
+	}
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("worker failed: %v", err)
+	}
+}
+
+// TestClusterProcesses runs a coordinator against several worker processes,
+// one of which dies holding a lease
+func TestClusterProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("spawns worker processes")
+	}
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	open := 0
+	c := NewCoordinator(300*time.Millisecond, func(r ScanResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[fmt.Sprintf("%s:%d", r.IP, r.Port)]++
+		if r.Open {
+			open++
+		}
+	}, nil)
+	mux := http.NewServeMux()
+	registerClusterAPI(mux, c)
+	server := httptest.NewServer(requireAuth(mux, AuthConfig{Token: "cluster-secret"}))
+	defer server.Close()
+
+	start := func(id string, crash bool) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestClusterWorkerProcess$")
+		cmd.Env = append(os.Environ(), "PORTSCANNER_TEST_COORDINATOR="+server.URL, "PORTSCANNER_TEST_WORKER="+id)
+		if crash {
+			cmd.Env = append(cmd.Env, "PORTSCANNER_TEST_CRASH=1")
+		}
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("starting worker %s: %v", id, err)
+		}
+		return cmd
+	}
+
+	units := planUnits("10.9.0.0", "10.9.0.63", []int{22, 80}, time.Millisecond, 8, 8, TargetOrder{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	dispatched := make(chan error, 1)
+	go func() { dispatched <- c.Dispatch(ctx, units) }()
+
+	// Wait for the units to be published before the crashing worker asks
+	for c.Snapshot().Pending == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	crashed := start("crash", true)
+	if err := crashed.Wait(); err == nil {
+		t.Fatal("crashing worker exited cleanly")
+	}
+	workers := []*exec.Cmd{start("w1", false), start("w2", false), start("w3", false)}
+
+	if err := <-dispatched; err != nil {
+		t.Fatalf("Dispatch() = %v", err)
+	}
+	c.Close()
+	for _, w := range workers {
+		if err := w.Wait(); err != nil {
+			t.Errorf("worker exited with %v", err)
+		}
+	}
+
+	if len(seen) != 128 || open != 64 {
+		t.Errorf("coordinator saw %d targets and %d open ports, want 128 and 64", len(seen), open)
+	}
+	for target, n := range seen {
+		if n != 1 {
+			t.Errorf("%s recorded %d times", target, n)
+		}
+	}
+	snap := c.Snapshot()
+	if snap.Done != len(units) || snap.Attempts != len(units)+1 {
+		t.Errorf("Snapshot() = %+v, want every unit done and one retry", snap)
+	}
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the full scanner configuration. Values are layered: built-in
+// defaults, then the YAML config file, then environment variables, then
+// command-line flags.
+type Config struct {
+	Scan      ScanConfig      `yaml:"scan"`
+	Jobs      JobsConfig      `yaml:"jobs"`
+	Schedules []ScheduledScan `yaml:"schedules"`
+	Scope     ScopeConfig     `yaml:"scope"`
+	Notify    NotifyConfig    `yaml:"notify"`
+	Output    OutputConfig    `yaml:"output"`
+	Server    ServerConfig    `yaml:"server"`
+	Cluster   ClusterConfig   `yaml:"cluster"`
+	Log       LogConfig       `yaml:"log"`
+	Baseline  string          `yaml:"baseline"`
+	CVE       CVEConfig       `yaml:"cve"`
+	Proxy     ProxyConfig     `yaml:"proxy"`
+	Source    SourceConfig    `yaml:"source"`
+	DNS       DNSConfig       `yaml:"dns"`
+	Alerts    AlertsConfig    `yaml:"alerts"`
+}
+
+// ScanConfig drives the built-in scan loop
+type ScanConfig struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	Ports []int  `yaml:"ports"`
+	// Hosts and HostsFile name targets to scan instead of Start-End
+	Hosts      []string      `yaml:"hosts"`
+	HostsFile  string        `yaml:"hosts_file"`
+	Timeout    time.Duration `yaml:"timeout"`
+	Concurrent int           `yaml:"concurrent"`
+	Chunk      int           `yaml:"chunk"`
+	Parallel   bool          `yaml:"parallel"`
+	Schedule   string        `yaml:"schedule"`
+	Rate       int           `yaml:"rate"`
+	// BannerTimeout is how long to wait for a banner on open ports; 0 skips banners
+	BannerTimeout time.Duration `yaml:"banner_timeout"`
+	Order         TargetOrder   `yaml:"order"`
+	DryRun        bool          `yaml:"-"`
+}
+
+// CVEConfig selects the offline vulnerability feed and the alert threshold
+type CVEConfig struct {
+	Feed          string `yaml:"feed"`
+	AlertSeverity string `yaml:"alert_severity"`
+}
+
+type JobsConfig struct {
+	Workers int `yaml:"workers"`
+	Queue   int `yaml:"queue"`
+	// Keep is how many finished jobs are kept with their results
+	Keep        int  `yaml:"keep"`
+	ServiceOnly bool `yaml:"service_only"`
+}
+
+type NotifyConfig struct {
+	Brevo     BrevoConfig `yaml:"brevo"`
+	Sender    Contact     `yaml:"sender"`
+	Recipient Contact     `yaml:"recipient"`
+	// Recipients, CC and BCC also receive every alert no route claims
+	Recipients []Contact       `yaml:"recipients"`
+	CC         []Contact       `yaml:"cc"`
+	BCC        []Contact       `yaml:"bcc"`
+	Teams      map[string]Team `yaml:"teams"`
+	Routes     []AlertRoute    `yaml:"routes"`
+	// Templates is a directory of message templates overriding the defaults
+	Templates string      `yaml:"templates"`
+	Queue     QueueConfig `yaml:"queue"`
+}
+
+type BrevoConfig struct {
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key"`
+}
+
+type Contact struct {
+	Name  string `yaml:"name" json:"name,omitempty"`
+	Email string `yaml:"email" json:"email"`
+}
+
+type OutputConfig struct {
+	ResultsFile string `yaml:"results_file"`
+	// ReportDir receives an HTML report of each finished run; empty disables
+	ReportDir string `yaml:"report_dir"`
+	// ReportKeep is how many reports ReportDir keeps, deleting the oldest
+	// first. 0 keeps them all.
+	ReportKeep int `yaml:"report_keep"`
+	// ReportURL is the externally reachable address of the HTTP server, used
+	// to link reports from the summary email
+	ReportURL string `yaml:"report_url"`
+	// ExportFile receives every result as a JSON line as it is produced
+	ExportFile string `yaml:"export_file"`
+	// MaxClosed bounds the closed and filtered results of a scan kept in
+	// memory; later ones are only counted. 0 keeps them all.
+	MaxClosed int `yaml:"max_closed"`
+	// DiscardClosed drops closed and filtered results once counted: they are
+	// neither kept nor exported
+	DiscardClosed bool `yaml:"discard_closed"`
+}
+
+type ServerConfig struct {
+	Port          string     `yaml:"port"`
+	TLSCert       string     `yaml:"tls_cert"`
+	TLSKey        string     `yaml:"tls_key"`
+	TLSSelfSigned bool       `yaml:"tls_self_signed"`
+	Auth          AuthConfig `yaml:"auth"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Scan: ScanConfig{
+			Start:      "192.168.1.1",
+			End:        "192.168.1.10",
+			Ports:      []int{25},
+			Timeout:    2 * time.Second,
+			Concurrent: 1000,
+			Chunk:      1000000,
+		},
+		Jobs: JobsConfig{Workers: 1, Queue: 100, Keep: defaultJobsKept},
+		Notify: NotifyConfig{
+			Sender:    Contact{Name: "Port Scanner Bot"},
+			Recipient: Contact{Name: "Admin"},
+			Queue: QueueConfig{
+				File:        defaultQueueFile,
+				DeadLetter:  defaultDeadLetterFile,
+				MaxAttempts: defaultMaxAttempts,
+				Backoff:     defaultRetryBackoff,
+				MaxBackoff:  defaultMaxRetryBackoff,
+			},
+		},
+		Server:  ServerConfig{Port: "10000"},
+		Cluster: ClusterConfig{LeaseTimeout: defaultLeaseTimeout},
+		Log:     LogConfig{Level: "info", Format: LogText},
+		DNS:     DNSConfig{Timeout: defaultDNSTimeout, CacheTTL: defaultDNSCacheTTL, Reverse: true},
+		Alerts:  AlertsConfig{File: defaultAlertsFile, Dedup: true},
+		Output:  OutputConfig{ReportKeep: defaultReportKeep, MaxClosed: defaultMaxClosed},
+	}
+}
+
+// loadConfigFile decodes path over cfg, rejecting unknown keys
+func loadConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides cfg with the environment variables the scanner has
+// always read from .env
+func applyEnv(cfg *Config) {
+	set := func(dst *string, key string) {
+		if v := os.Getenv(key); v != "" {
+			*dst = v
+		}
+	}
+	set(&cfg.Server.Port, "PORT")
+	set(&cfg.Notify.Brevo.URL, "BREVO_URL")
+	set(&cfg.Notify.Brevo.APIKey, "BREVO_APIKEY")
+	set(&cfg.Notify.Sender.Email, "SENDER_EMAIL")
+	set(&cfg.Notify.Recipient.Email, "TO_EMAIL")
+	set(&cfg.Server.Auth.Token, "API_TOKEN")
+	set(&cfg.Server.Auth.Username, "API_USER")
+	set(&cfg.Server.Auth.Password, "API_PASSWORD")
+	set(&cfg.Log.Level, "LOG_LEVEL")
+	set(&cfg.Log.Format, "LOG_FORMAT")
+}
+
+// portsValue adapts a port list to flag.Value
+type portsValue struct {
+	ports *[]int
+}
+
+func (p portsValue) String() string {
+	if p.ports == nil {
+		return ""
+	}
+	return joinPorts(*p.ports)
+}
+
+// Set parses s, rejecting entries that are not ports rather than dropping
+// them like parsePorts
+func (p portsValue) Set(s string) error {
+	var ports []int
+	for _, part := range strings.Split(s, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %q, want 1-65535", part)
+		}
+		ports = append(ports, port)
+	}
+	*p.ports = ports
+	return nil
+}
+
+func joinPorts(ports []int) string {
+	parts := make([]string, len(ports))
+	for i, p := range ports {
+		parts[i] = strconv.Itoa(p)
+	}
+	return strings.Join(parts, ",")
+}
+
+// listValue adapts a comma-separated list to flag.Value
+type listValue struct {
+	list *[]string
+}
+
+func (l listValue) String() string {
+	if l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l listValue) Set(s string) error {
+	*l.list = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l.list = append(*l.list, item)
+		}
+	}
+	return nil
+}
+
+// bindFlags registers the command-line flags on fs, writing into cfg. The
+// current values of cfg become the flag defaults.
+func bindFlags(fs *flag.FlagSet, cfg *Config, configPath *string) {
+	fs.StringVar(configPath, "config", *configPath, "YAML configuration file (default: $CONFIG_FILE)")
+	fs.StringVar(&cfg.Scan.Start, "start", cfg.Scan.Start, "Starting IP address")
+	fs.StringVar(&cfg.Scan.End, "end", cfg.Scan.End, "Ending IP address")
+	fs.Var(portsValue{&cfg.Scan.Ports}, "ports", "Comma-separated list of ports")
+	fs.Var(listValue{&cfg.Scan.Hosts}, "hosts", "Comma-separated host names or addresses to scan instead of -start to -end")
+	fs.StringVar(&cfg.Scan.HostsFile, "hosts-file", cfg.Scan.HostsFile, "File listing host names or addresses to scan, one per line")
+	fs.StringVar(&cfg.DNS.Server, "dns-server", cfg.DNS.Server, "DNS server (host:port) for host targets and reverse lookups (default: system resolver)")
+	fs.BoolVar(&cfg.DNS.Reverse, "reverse-dns", cfg.DNS.Reverse, "Look up the PTR name of hosts with open ports")
+	fs.DurationVar(&cfg.Scan.Timeout, "timeout", cfg.Scan.Timeout, "Connection timeout")
+	fs.IntVar(&cfg.Scan.Concurrent, "concurrent", cfg.Scan.Concurrent, "Maximum concurrent scans per chunk")
+	fs.IntVar(&cfg.Scan.Chunk, "chunk", cfg.Scan.Chunk, "Number of IPs per chunk")
+	fs.BoolVar(&cfg.Scan.Parallel, "parallel", cfg.Scan.Parallel, "Run chunks in parallel (experimental)")
+	fs.StringVar(&cfg.Scan.Schedule, "schedule", cfg.Scan.Schedule, "Cron expression or interval for the scan loop (default: rescan continuously)")
+	fs.IntVar(&cfg.Scan.Rate, "rate", cfg.Scan.Rate, "Maximum probes per second across all scans (0: unlimited)")
+	fs.BoolVar(&cfg.Scan.Order.Random, "randomize", cfg.Scan.Order.Random, "Probe the IP x port space in a pseudo-random order")
+	fs.Int64Var(&cfg.Scan.Order.Seed, "seed", cfg.Scan.Order.Seed, "Seed for -randomize; the same seed repeats the same order (default: random)")
+	fs.DurationVar(&cfg.Scan.BannerTimeout, "banner-timeout", cfg.Scan.BannerTimeout, "How long to wait for a service banner on open ports (0: don't read banners)")
+	fs.StringVar(&cfg.CVE.Feed, "cve-feed", cfg.CVE.Feed, "Offline vulnerability feed (JSON) to match fingerprinted services against")
+	fs.StringVar(&cfg.CVE.AlertSeverity, "cve-alert", cfg.CVE.AlertSeverity, "Alert on services with CVEs of at least this severity: low, medium, high or critical")
+	fs.BoolVar(&cfg.Scan.DryRun, "dry-run", cfg.Scan.DryRun, "Print the probe count, estimates and chunk plan without scanning")
+	fs.IntVar(&cfg.Jobs.Workers, "jobs", cfg.Jobs.Workers, "Maximum number of API scan jobs run concurrently")
+	fs.IntVar(&cfg.Jobs.Queue, "job-queue", cfg.Jobs.Queue, "Maximum number of API scan jobs waiting to run")
+	fs.IntVar(&cfg.Jobs.Keep, "jobs-keep", cfg.Jobs.Keep, "Finished API scan jobs kept with their results; older ones are forgotten")
+	fs.BoolVar(&cfg.Jobs.ServiceOnly, "service", cfg.Jobs.ServiceOnly, "Only run scan jobs submitted through the API")
+	fs.StringVar(&cfg.Server.TLSCert, "tls-cert", cfg.Server.TLSCert, "TLS certificate file for the HTTP server")
+	fs.StringVar(&cfg.Server.TLSKey, "tls-key", cfg.Server.TLSKey, "TLS private key file for the HTTP server")
+	fs.BoolVar(&cfg.Server.TLSSelfSigned, "tls-self-signed", cfg.Server.TLSSelfSigned, "Serve HTTPS with an auto-generated self-signed certificate")
+	fs.StringVar(&cfg.Scope.File, "scope", cfg.Scope.File, "File listing the CIDRs that may be scanned (required)")
+	fs.StringVar(&cfg.Scope.ExcludeFile, "exclude-file", cfg.Scope.ExcludeFile, "File listing CIDRs and host:port pairs that must not be scanned")
+	fs.BoolVar(&cfg.Scope.AllowReserved, "allow-reserved", cfg.Scope.AllowReserved, "Allow scanning the built-in deny list of bogon, multicast and broadcast space")
+	fs.BoolVar(&cfg.Scope.Override, "override-scope", cfg.Scope.Override, "Scan targets outside the allowed scope (exclusions still apply)")
+	fs.StringVar(&cfg.Cluster.Mode, "mode", cfg.Cluster.Mode, "Run as a \"coordinator\" handing scan chunks to workers, or as a \"worker\" (default: scan locally)")
+	fs.StringVar(&cfg.Cluster.CoordinatorURL, "coordinator", cfg.Cluster.CoordinatorURL, "Coordinator base URL for -mode=worker")
+	fs.StringVar(&cfg.Cluster.WorkerID, "worker-id", cfg.Cluster.WorkerID, "Worker name reported to the coordinator (default: host-pid)")
+	fs.DurationVar(&cfg.Cluster.LeaseTimeout, "lease-timeout", cfg.Cluster.LeaseTimeout, "How long a worker may go silent before its chunk is reassigned")
+	fs.Var(listValue{&cfg.Source.IPs}, "source-ip", "Comma-separated local addresses to probe from, used round-robin")
+	fs.StringVar(&cfg.Source.Interface, "interface", cfg.Source.Interface, "Network interface to probe through (SO_BINDTODEVICE, Linux only, needs CAP_NET_RAW)")
+	fs.StringVar(&cfg.Source.Ports, "source-ports", cfg.Source.Ports, "Local port range to probe from, e.g. 40000-40999")
+	fs.Var(listValue{&cfg.Proxy.Chain}, "proxy", "Comma-separated chain of socks5:// and http:// proxies to probe through, first hop first")
+	fs.StringVar(&cfg.Baseline, "baseline", cfg.Baseline, "Baseline policy file of allowed and required ports; replaces open port alerts with violation alerts")
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "Minimum log level: debug, info, warn or error")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "Log format: text or json")
+	fs.StringVar(&cfg.Notify.Templates, "templates", cfg.Notify.Templates, "Directory of notification templates overriding the built-in ones")
+	fs.StringVar(&cfg.Notify.Queue.File, "notify-queue", cfg.Notify.Queue.File, "File keeping undelivered notifications across restarts")
+	fs.StringVar(&cfg.Alerts.File, "alerts-file", cfg.Alerts.File, "File of alert suppressions and maintenance windows")
+	fs.StringVar(&cfg.Notify.Queue.DeadLetter, "dead-letter", cfg.Notify.Queue.DeadLetter, "File collecting notifications that could not be delivered")
+	fs.StringVar(&cfg.Output.ResultsFile, "results-file", cfg.Output.ResultsFile, "Append open ports to this file")
+	fs.StringVar(&cfg.Output.ReportDir, "report-dir", cfg.Output.ReportDir, "Directory of HTML run reports; empty disables them")
+	fs.IntVar(&cfg.Output.ReportKeep, "report-keep", cfg.Output.ReportKeep, "Reports kept in the report directory, deleting the oldest; 0 keeps all")
+	fs.StringVar(&cfg.Output.ReportURL, "report-url", cfg.Output.ReportURL, "External URL of the HTTP server, for linking reports from emails")
+	fs.StringVar(&cfg.Output.ExportFile, "export-file", cfg.Output.ExportFile, "Stream every result to this file as JSON lines")
+	fs.IntVar(&cfg.Output.MaxClosed, "max-closed", cfg.Output.MaxClosed, "Closed and filtered results of a scan kept in memory; 0 keeps all")
+	fs.BoolVar(&cfg.Output.DiscardClosed, "discard-closed", cfg.Output.DiscardClosed, "Only count closed and filtered results; neither keep nor export them")
+}
+
+// loadConfig builds the configuration from defaults, the config file, the
+// environment and args, in increasing order of precedence
+func loadConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	// A first pass over the flags only finds the config file
+	configPath := os.Getenv("CONFIG_FILE")
+	probe := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	probe.SetOutput(io.Discard)
+	scratch := defaultConfig()
+	bindFlags(probe, &scratch, &configPath)
+	_ = probe.Parse(args)
+
+	cfg := defaultConfig()
+	if configPath != "" {
+		if err := loadConfigFile(configPath, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	applyEnv(&cfg)
+
+	bindFlags(fs, &cfg, &configPath)
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every problem with the configuration at once
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if net.ParseIP(c.Scan.Start).To4() == nil {
+		fail("scan.start", "invalid IPv4 address %q", c.Scan.Start)
+	}
+	if net.ParseIP(c.Scan.End).To4() == nil {
+		fail("scan.end", "invalid IPv4 address %q", c.Scan.End)
+	} else if net.ParseIP(c.Scan.Start).To4() != nil && ipToUint32(c.Scan.Start) > ipToUint32(c.Scan.End) {
+		fail("scan.end", "%s is before start address %s", c.Scan.End, c.Scan.Start)
+	}
+	if len(c.Scan.Ports) == 0 {
+		fail("scan.ports", "at least one port is required")
+	}
+	for _, p := range c.Scan.Ports {
+		if p <= 0 || p > 65535 {
+			fail("scan.ports", "port %d is out of range 1-65535", p)
+		}
+	}
+	if c.Scan.Timeout <= 0 {
+		fail("scan.timeout", "must be positive, got %s", c.Scan.Timeout)
+	}
+	if c.Scan.Concurrent <= 0 {
+		fail("scan.concurrent", "must be positive, got %d", c.Scan.Concurrent)
+	}
+	if c.Scan.Chunk <= 0 {
+		fail("scan.chunk", "must be positive, got %d", c.Scan.Chunk)
+	}
+	if c.Scan.Rate < 0 || c.Scan.Rate > maxRate {
+		fail("scan.rate", "must be between 0 and %d, got %d", maxRate, c.Scan.Rate)
+	}
+	if c.Scan.Schedule != "" {
+		if _, err := parseSchedule(c.Scan.Schedule); err != nil {
+			fail("scan.schedule", "%v", err)
+		}
+	}
+
+	if c.Jobs.Workers <= 0 {
+		fail("jobs.workers", "must be positive, got %d", c.Jobs.Workers)
+	}
+	if c.Jobs.Queue <= 0 {
+		fail("jobs.queue", "must be positive, got %d", c.Jobs.Queue)
+	}
+	if c.Jobs.Keep <= 0 {
+		fail("jobs.keep", "must be positive, got %d", c.Jobs.Keep)
+	}
+
+	names := make(map[string]bool)
+	for i, s := range c.Schedules {
+		field := fmt.Sprintf("schedules[%d]", i)
+		if s.Name == "" {
+			fail(field+".name", "is required")
+		} else if names[s.Name] {
+			fail(field+".name", "duplicate schedule %q", s.Name)
+		}
+		names[s.Name] = true
+		if _, err := parseSchedule(s.Schedule); err != nil {
+			fail(field+".schedule", "%v", err)
+		}
+		// The scope is checked once loaded
+		job := s.Job
+		if err := job.validateFields(); err != nil {
+			fail(field+".job", "%v", err)
+		}
+	}
+
+	if c.Notify.Brevo.URL != "" || c.Notify.Brevo.APIKey != "" {
+		if u, err := url.Parse(c.Notify.Brevo.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("notify.brevo.url", "invalid URL %q", c.Notify.Brevo.URL)
+		}
+		if c.Notify.Brevo.APIKey == "" {
+			fail("notify.brevo.api_key", "is required when notify.brevo.url is set")
+		}
+		if _, err := mail.ParseAddress(c.Notify.Sender.Email); err != nil {
+			fail("notify.sender.email", "invalid address %q", c.Notify.Sender.Email)
+		}
+		// The single recipient is optional when notify.recipients lists some
+		if c.Notify.Recipient.Email == "" && len(c.Notify.Recipients) == 0 {
+			fail("notify.recipient.email", "is required when notify.recipients is empty")
+		} else if c.Notify.Recipient.Email != "" {
+			if _, err := mail.ParseAddress(c.Notify.Recipient.Email); err != nil {
+				fail("notify.recipient.email", "invalid address %q", c.Notify.Recipient.Email)
+			}
+		}
+	}
+	if err := checkContacts(c.Notify.Recipients); err != nil {
+		fail("notify.recipients", "%v", err)
+	}
+	if err := checkContacts(c.Notify.CC, c.Notify.BCC); err != nil {
+		fail("notify.cc", "%v", err)
+	}
+	if _, err := newAlertRouter(c.Notify); err != nil {
+		fail("notify.routes", "%v", err)
+	}
+
+	if c.Notify.Templates != "" {
+		if info, err := os.Stat(c.Notify.Templates); err != nil || !info.IsDir() {
+			fail("notify.templates", "%q is not a directory", c.Notify.Templates)
+		} else if _, err := loadTemplates(c.Notify.Templates); err != nil {
+			fail("notify.templates", "%v", err)
+		}
+	}
+
+	if c.Notify.Queue.MaxAttempts <= 0 {
+		fail("notify.queue.max_attempts", "must be positive, got %d", c.Notify.Queue.MaxAttempts)
+	}
+	if c.Notify.Queue.Backoff <= 0 {
+		fail("notify.queue.backoff", "must be positive, got %s", c.Notify.Queue.Backoff)
+	}
+	if c.Notify.Queue.MaxBackoff < c.Notify.Queue.Backoff {
+		fail("notify.queue.max_backoff", "%s is shorter than backoff %s", c.Notify.Queue.MaxBackoff, c.Notify.Queue.Backoff)
+	}
+
+	if c.Alerts.Renotify < 0 {
+		fail("alerts.renotify", "must not be negative, got %s", c.Alerts.Renotify)
+	}
+	if _, err := loadAlertRules(c.Alerts); err != nil {
+		fail("alerts.file", "%v", err)
+	}
+
+	if c.Output.MaxClosed < 0 {
+		fail("output.max_closed", "must not be negative, got %d", c.Output.MaxClosed)
+	}
+	if c.Output.ReportKeep < 0 {
+		fail("output.report_keep", "must not be negative, got %d", c.Output.ReportKeep)
+	}
+	if c.Output.ReportURL != "" {
+		if u, err := url.Parse(c.Output.ReportURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("output.report_url", "must be an http(s) URL, got %q", c.Output.ReportURL)
+		}
+	}
+
+	if p, err := strconv.Atoi(c.Server.Port); err != nil || p <= 0 || p > 65535 {
+		fail("server.port", "invalid port %q", c.Server.Port)
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		fail("server.tls_cert", "tls_cert and tls_key must be set together")
+	}
+	if c.Server.TLSCert != "" && c.Server.TLSSelfSigned {
+		fail("server.tls_self_signed", "cannot be combined with tls_cert")
+	}
+	if c.Server.Auth.Username != "" && c.Server.Auth.Password == "" {
+		fail("server.auth.password", "is required when server.auth.username is set")
+	}
+
+	switch c.Cluster.Mode {
+	case "", ModeCoordinator:
+	case ModeWorker:
+		if u, err := url.Parse(c.Cluster.CoordinatorURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("cluster.coordinator_url", "invalid URL %q", c.Cluster.CoordinatorURL)
+		}
+	default:
+		fail("cluster.mode", "must be %q or %q, got %q", ModeCoordinator, ModeWorker, c.Cluster.Mode)
+	}
+	if c.Cluster.LeaseTimeout <= 0 {
+		fail("cluster.lease_timeout", "must be positive, got %s", c.Cluster.LeaseTimeout)
+	}
+
+	if c.Scan.BannerTimeout < 0 {
+		fail("scan.banner_timeout", "must not be negative, got %s", c.Scan.BannerTimeout)
+	}
+	if c.CVE.Feed != "" {
+		if _, err := loadVulnDB(c.CVE.Feed); err != nil {
+			fail("cve.feed", "%v", err)
+		}
+		if c.Scan.BannerTimeout == 0 {
+			fail("cve.feed", "needs scan.banner_timeout to fingerprint services")
+		}
+	}
+	if c.CVE.AlertSeverity != "" {
+		if _, err := parseSeverity(c.CVE.AlertSeverity); err != nil {
+			fail("cve.alert_severity", "%v", err)
+		} else if c.CVE.Feed == "" {
+			fail("cve.alert_severity", "needs cve.feed")
+		}
+	}
+	if len(c.Scan.Hosts) > 0 || c.Scan.HostsFile != "" {
+		hosts, err := loadHostTargets(c.Scan.Hosts, c.Scan.HostsFile)
+		if err != nil {
+			fail("scan.hosts", "%v", err)
+		} else if len(hosts) == 0 {
+			fail("scan.hosts", "no hosts given")
+		}
+		if c.Cluster.Mode == ModeCoordinator {
+			fail("scan.hosts", "host targets can't be distributed to workers")
+		}
+	}
+	if c.DNS.Server != "" {
+		if _, _, err := net.SplitHostPort(c.DNS.Server); err != nil {
+			fail("dns.server", "want host:port, got %q", c.DNS.Server)
+		}
+	}
+	if c.DNS.Timeout <= 0 {
+		fail("dns.timeout", "must be positive, got %s", c.DNS.Timeout)
+	}
+	if c.DNS.CacheTTL < 0 {
+		fail("dns.cache_ttl", "must not be negative, got %s", c.DNS.CacheTTL)
+	}
+	if d, err := newSourceDialer(c.Source); err != nil {
+		fail("source", "%v", err)
+	} else if d != nil && d.portLo > 0 && d.portHi-d.portLo+1 < c.Scan.Concurrent {
+		fail("source.ports", "range of %d ports is smaller than scan.concurrent (%d)", d.portHi-d.portLo+1, c.Scan.Concurrent)
+	}
+	if _, err := newProxyRouter(c.Proxy); err != nil {
+		fail("proxy", "%v", err)
+	}
+	if c.Baseline != "" {
+		if _, err := loadBaseline(c.Baseline); err != nil {
+			fail("baseline", "%v", err)
+		}
+	}
+	if _, err := newLogHandler(c.Log, io.Discard); err != nil {
+		fail("log", "%v", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// NotificationsEnabled reports whether a notifier is configured
+func (c Config) NotificationsEnabled() bool {
+	return c.Notify.Brevo.URL != ""
+}
+
+// recipients returns the single recipient, if set, then the listed ones
+func (c Config) recipients() []Contact {
+	if c.Notify.Recipient.Email == "" {
+		return append([]Contact(nil), c.Notify.Recipients...)
+	}
+	return append([]Contact{c.Notify.Recipient}, c.Notify.Recipients...)
+}
+
+// apply installs the notification settings into the package globals
+func (c Config) apply() {
+	brevo = Brevo{URL: c.Notify.Brevo.URL, APIKEY: c.Notify.Brevo.APIKey}
+	email = Email{
+		SenderName:  c.Notify.Sender.Name,
+		SenderEmail: c.Notify.Sender.Email,
+		To:          c.recipients(),
+		CC:          c.Notify.CC,
+		BCC:         c.Notify.BCC,
+		Subject:     "Port Scan Results",
+		Msg:         "",
+	}
+	outputs = c.Output
+	templates = defaultTemplates
+	if c.Notify.Templates != "" {
+		if t, err := loadTemplates(c.Notify.Templates); err == nil {
+			templates = t
+		}
+	}
+	alertRoutes, _ = newAlertRouter(c.Notify)
+	targetOrder = c.Scan.Order
+	bannerTimeout = c.Scan.BannerTimeout
+	proxies, _ = newProxyRouter(c.Proxy)
+	resolver = newDNSCache(newResolver(c.DNS.Server), c.DNS.CacheTTL, c.DNS.Timeout)
+	reverseDNS = c.DNS.Reverse
+	cveAlertScore = 0
+	if c.CVE.AlertSeverity != "" {
+		cveAlertScore, _ = parseSeverity(c.CVE.AlertSeverity)
+	}
+}
+
+// runConfigCommand implements the "config" subcommand
+func runConfigCommand(args []string, out io.Writer) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(out, "usage: port-scanner config validate [-config file] [flags]")
+		return 2
+	}
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	cfg, err := loadConfig(fs, args[1:])
+	if err != nil {
+		fmt.Fprintf(out, "Invalid configuration: %v\n", err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(out, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+	s, err := loadScope(cfg.Scope)
+	if err == nil && len(cfg.Scan.Hosts) == 0 && cfg.Scan.HostsFile == "" {
+		err = s.CheckRange(ipToUint32(cfg.Scan.Start), ipToUint32(cfg.Scan.End))
+	}
+	// Validate has checked the schedules' other fields
+	for i := 0; err == nil && i < len(cfg.Schedules); i++ {
+		job := cfg.Schedules[i].Job
+		if job.validateFields() == nil {
+			if err = job.checkScope(s); err != nil {
+				err = fmt.Errorf("schedule %s: %w", cfg.Schedules[i].Name, err)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(out, "Invalid scope: %v\n", err)
+		return 1
+	}
+	if !cfg.NotificationsEnabled() {
+		fmt.Fprintln(out, "Warning: no notifier configured, emails will not be sent")
+	}
+	fmt.Fprintln(out, "Configuration OK")
+	return 0
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+// TestLoadConfigPrecedence tests defaults < file < environment < flags
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+scan:
+  start: 10.0.0.1
+  end: 10.0.0.20
+  ports: [22, 443]
+  timeout: 500ms
+server:
+  port: "9000"
+notify:
+  brevo:
+    url: https://file.example.com
+    api_key: filekey
+`)
+	t.Setenv("PORT", "9100")
+	t.Setenv("BREVO_URL", "")
+	t.Setenv("BREVO_APIKEY", "envkey")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := loadConfig(fs, []string{"-config", path, "-end=10.0.0.5", "-ports=80"})
+	if err != nil {
+		t.Fatalf("loadConfig() failed: %v", err)
+	}
+	if cfg.Scan.Start != "10.0.0.1" || cfg.Scan.Timeout != 500*time.Millisecond {
+		t.Errorf("file values not applied: %+v", cfg.Scan)
+	}
+	if cfg.Scan.End != "10.0.0.5" || len(cfg.Scan.Ports) != 1 || cfg.Scan.Ports[0] != 80 {
+		t.Errorf("flags did not override file: %+v", cfg.Scan)
+	}
+	if cfg.Server.Port != "9100" || cfg.Notify.Brevo.APIKey != "envkey" || cfg.Notify.Brevo.URL != "https://file.example.com" {
+		t.Errorf("environment did not override file: %+v %+v", cfg.Server, cfg.Notify.Brevo)
+	}
+	if cfg.Scan.Concurrent != 1000 || cfg.Notify.Sender.Name != "Port Scanner Bot" || cfg.Notify.Recipient.Name != "Admin" {
+		t.Errorf("defaults not applied: %+v %+v", cfg.Scan, cfg.Notify)
+	}
+}
+
+// TestPortsFlag tests that -ports rejects entries instead of dropping them
+func TestPortsFlag(t *testing.T) {
+	for _, arg := range []string{"22,99999", "abc", "22,,80", ""} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		if _, err := loadConfig(fs, []string{"-ports=" + arg}); err == nil {
+			t.Errorf("loadConfig() accepted -ports=%s", arg)
+		}
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := loadConfig(fs, []string{"-ports=22, 443"})
+	if err != nil || len(cfg.Scan.Ports) != 2 || cfg.Scan.Ports[1] != 443 {
+		t.Errorf("loadConfig(-ports=22, 443) = %v, %v", cfg.Scan.Ports, err)
+	}
+}
+
+// TestConfigRejectsUnknownKeys tests strict decoding
+func TestConfigRejectsUnknownKeys(t *testing.T) {
+	path := writeConfig(t, "scan:\n  strat: 10.0.0.1\n")
+	cfg := defaultConfig()
+	if err := loadConfigFile(path, &cfg); err == nil || !strings.Contains(err.Error(), "strat") {
+		t.Errorf("loadConfigFile() = %v, want unknown field error", err)
+	}
+}
+
+// TestConfigValidate tests that every problem is reported with its field
+func TestConfigValidate(t *testing.T) {
+	if err := defaultConfig().Validate(); err != nil {
+		t.Fatalf("default config invalid: %v", err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Scan.Start = "10.0.0.300"
+	cfg.Scan.Ports = []int{0}
+	cfg.Scan.Schedule = "bogus"
+	cfg.Jobs.Workers = 0
+	cfg.Schedules = []ScheduledScan{
+		{Name: "a", Schedule: "@hourly", Job: JobSpec{Start: "10.0.0.1", End: "10.0.0.2", Ports: []int{22}}},
+		{Name: "a", Schedule: "@hourly", Job: JobSpec{Start: "10.0.0.1", End: "10.0.0.2"}},
+	}
+	cfg.Notify.Brevo.URL = "not a url"
+	cfg.Server.Port = "http"
+	cfg.Server.TLSCert = "cert.pem"
+	cfg.Server.Auth.Username = "admin"
+	cfg.CVE = CVEConfig{Feed: "cve.example.json", AlertSeverity: "severe"}
+	cfg.Source = SourceConfig{IPs: []string{"10.0.0.300"}}
+	cfg.Scan.Rate = maxRate + 1
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() accepted invalid config")
+	}
+	for _, field := range []string{
+		"scan.start", "scan.ports", "scan.schedule", "scan.rate", "jobs.workers", "schedules[1].name", "schedules[1].job",
+		"notify.brevo.url", "notify.brevo.api_key", "notify.sender.email", "server.port", "server.tls_cert", "server.auth.password",
+		"cve.feed", "cve.alert_severity", "source",
+	} {
+		if !strings.Contains(err.Error(), field+":") {
+			t.Errorf("Validate() did not report %s:\n%v", field, err)
+		}
+	}
+}
+
+// TestConfigRecipients tests that either recipient field is enough on its own
+func TestConfigRecipients(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Notify.Brevo = BrevoConfig{URL: "https://api.example.com/v3/smtp/email", APIKey: "key"}
+	cfg.Notify.Sender.Email = "scanner@example.com"
+	cfg.Notify.Recipient.Email = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "notify.recipient.email:") {
+		t.Errorf("Validate() without recipients = %v", err)
+	}
+
+	cfg.Notify.Recipients = []Contact{{Name: "NOC", Email: "noc@example.com"}}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() with only notify.recipients = %v", err)
+	}
+	if to := cfg.recipients(); len(to) != 1 || to[0].Email != "noc@example.com" {
+		t.Errorf("recipients() = %+v", to)
+	}
+
+	cfg.Notify.Recipient.Email = "admin@example.com"
+	if to := cfg.recipients(); len(to) != 2 || to[0].Email != "admin@example.com" {
+		t.Errorf("recipients() = %+v", to)
+	}
+}
+
+// TestConfigValidateCommand tests the config validate subcommand
+func TestConfigValidateCommand(t *testing.T) {
+	for _, key := range []string{"PORT", "BREVO_URL", "BREVO_APIKEY", "SENDER_EMAIL", "TO_EMAIL"} {
+		t.Setenv(key, "")
+	}
+
+	var out bytes.Buffer
+	good := writeConfig(t, "scan:\n  ports: [22]\nscope:\n  override: true\n")
+	if code := runConfigCommand([]string{"validate", "-config", good}, &out); code != 0 {
+		t.Errorf("validate good config exited %d: %s", code, out.String())
+	}
+
+	out.Reset()
+	bad := writeConfig(t, "scan:\n  timeout: -1s\n")
+	if code := runConfigCommand([]string{"validate", "-config", bad}, &out); code != 1 || !strings.Contains(out.String(), "scan.timeout") {
+		t.Errorf("validate bad config exited %d: %s", code, out.String())
+	}
+
+	// Schedules are checked against the configured scope
+	scopeFile := filepath.Join(t.TempDir(), "scope.txt")
+	if err := os.WriteFile(scopeFile, []byte("10.0.0.0/24\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	scheduled := func(start string) string {
+		return writeConfig(t, "scan:\n  start: 10.0.0.1\n  end: 10.0.0.1\n  ports: [22]\nscope:\n  file: "+scopeFile+
+			"\nschedules:\n  - name: nightly\n    schedule: \"@daily\"\n    job: {start: "+start+", end: "+start+", ports: [22]}\n")
+	}
+	out.Reset()
+	if code := runConfigCommand([]string{"validate", "-config", scheduled("10.0.0.5")}, &out); code != 0 {
+		t.Errorf("validate schedule in scope exited %d: %s", code, out.String())
+	}
+	out.Reset()
+	if code := runConfigCommand([]string{"validate", "-config", scheduled("10.9.0.5")}, &out); code != 1 || !strings.Contains(out.String(), "schedule nightly") {
+		t.Errorf("validate schedule out of scope exited %d: %s", code, out.String())
+	}
+
+	out.Reset()
+	if code := runConfigCommand(nil, &out); code != 2 {
+		t.Errorf("config without subcommand exited %d", code)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Severity ratings, from CVSS v3 base scores
+const (
+	SeverityNone     = "none"
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// severityScores holds the lowest score of each rating
+var severityScores = map[string]float64{
+	SeverityLow:      0.1,
+	SeverityMedium:   4.0,
+	SeverityHigh:     7.0,
+	SeverityCritical: 9.0,
+}
+
+// severity rates a CVSS score
+func severity(score float64) string {
+	switch {
+	case score >= severityScores[SeverityCritical]:
+		return SeverityCritical
+	case score >= severityScores[SeverityHigh]:
+		return SeverityHigh
+	case score >= severityScores[SeverityMedium]:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	}
+	return SeverityNone
+}
+
+// parseSeverity returns the lowest score rated s
+func parseSeverity(s string) (float64, error) {
+	score, ok := severityScores[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown severity %q, want low, medium, high or critical", s)
+	}
+	return score, nil
+}
+
+// CPEMatch selects affected versions of a product. The version in CPE is
+// either exact or "*", in which case the optional bounds apply.
+type CPEMatch struct {
+	CPE                   string `json:"cpe"`
+	VersionStartIncluding string `json:"version_start_including,omitempty"`
+	VersionStartExcluding string `json:"version_start_excluding,omitempty"`
+	VersionEndIncluding   string `json:"version_end_including,omitempty"`
+	VersionEndExcluding   string `json:"version_end_excluding,omitempty"`
+}
+
+// Vulnerability is one entry of the offline feed
+type Vulnerability struct {
+	ID      string     `json:"id"`
+	CVSS    float64    `json:"cvss"`
+	Summary string     `json:"summary,omitempty"`
+	Matches []CPEMatch `json:"matches"`
+}
+
+// VulnDB matches product versions against an offline vulnerability feed
+type VulnDB struct {
+	byProduct map[string][]vulnEntry
+	count     int
+}
+
+type vulnEntry struct {
+	vuln    *Vulnerability
+	version string
+	match   CPEMatch
+}
+
+// vulnDB is the loaded feed, or nil when none is configured
+var vulnDB *VulnDB
+
+// cveAlertScore is the lowest CVSS score that raises an alert; 0 disables
+// alerts
+var cveAlertScore float64
+
+// loadVulnDB reads a feed of the form {"vulnerabilities": [...]}
+func loadVulnDB(path string) (*VulnDB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CVE feed: %w", err)
+	}
+	var feed struct {
+		Vulnerabilities []Vulnerability `json:"vulnerabilities"`
+	}
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, fmt.Errorf("parsing CVE feed %s: %w", path, err)
+	}
+	db, err := newVulnDB(feed.Vulnerabilities)
+	if err != nil {
+		return nil, fmt.Errorf("CVE feed %s: %w", path, err)
+	}
+	return db, nil
+}
+
+func newVulnDB(vulns []Vulnerability) (*VulnDB, error) {
+	db := &VulnDB{byProduct: make(map[string][]vulnEntry), count: len(vulns)}
+	var errs []error
+	for i := range vulns {
+		v := &vulns[i]
+		if v.ID == "" {
+			errs = append(errs, fmt.Errorf("entry %d: missing id", i))
+		}
+		if v.CVSS < 0 || v.CVSS > 10 {
+			errs = append(errs, fmt.Errorf("%s: cvss %.1f is out of range 0-10", v.ID, v.CVSS))
+		}
+		for _, m := range v.Matches {
+			vendor, product, version, ok := parseCPE(m.CPE)
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: invalid CPE %q", v.ID, m.CPE))
+				continue
+			}
+			key := vendor + ":" + product
+			db.byProduct[key] = append(db.byProduct[key], vulnEntry{vuln: v, version: version, match: m})
+		}
+	}
+	return db, errors.Join(errs...)
+}
+
+// Len returns the number of vulnerabilities in the feed
+func (db *VulnDB) Len() int {
+	return db.count
+}
+
+// parseCPE splits a CPE 2.3 name into vendor, product and version
+func parseCPE(cpe string) (vendor, product, version string, ok bool) {
+	parts := strings.Split(cpe, ":")
+	if len(parts) < 6 || parts[0] != "cpe" || parts[1] != "2.3" || parts[3] == "" || parts[4] == "" {
+		return "", "", "", false
+	}
+	return strings.ToLower(parts[3]), strings.ToLower(parts[4]), parts[5], true
+}
+
+// Match returns the vulnerabilities affecting cpe, highest score first
+func (db *VulnDB) Match(cpe string) []*Vulnerability {
+	vendor, product, version, ok := parseCPE(cpe)
+	if !ok {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var found []*Vulnerability
+	for _, e := range db.byProduct[vendor+":"+product] {
+		if seen[e.vuln.ID] || !e.affects(version) {
+			continue
+		}
+		seen[e.vuln.ID] = true
+		found = append(found, e.vuln)
+	}
+	sort.SliceStable(found, func(a, b int) bool { return found[a].CVSS > found[b].CVSS })
+	return found
+}
+
+func (e vulnEntry) affects(version string) bool {
+	if e.version != "*" && e.version != "-" && e.version != "" {
+		return compareVersions(version, e.version) == 0
+	}
+	m := e.match
+	if m.VersionStartIncluding != "" && compareVersions(version, m.VersionStartIncluding) < 0 {
+		return false
+	}
+	if m.VersionStartExcluding != "" && compareVersions(version, m.VersionStartExcluding) <= 0 {
+		return false
+	}
+	if m.VersionEndIncluding != "" && compareVersions(version, m.VersionEndIncluding) > 0 {
+		return false
+	}
+	if m.VersionEndExcluding != "" && compareVersions(version, m.VersionEndExcluding) >= 0 {
+		return false
+	}
+	return true
+}
+
+// compareVersions orders versions such as "2.4.49" and "8.9p1". Numeric
+// parts compare as numbers, others as strings, and a version that extends
+// another sorts after it.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				return compareInts(na, nb)
+			}
+		case pa[i] != pb[i]:
+			return strings.Compare(pa[i], pb[i])
+		}
+	}
+	return compareInts(len(pa), len(pb))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// versionParts splits at punctuation and between digits and letters
+func versionParts(v string) []string {
+	var parts []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			parts = append(parts, string(cur))
+			cur = cur[:0]
+		}
+	}
+	for _, r := range strings.ToLower(v) {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+			continue
+		case len(cur) > 0 && unicode.IsDigit(r) != unicode.IsDigit(cur[len(cur)-1]):
+			flush()
+		}
+		cur = append(cur, r)
+	}
+	flush()
+	return parts
+}
+
+// tagVulnerabilities fingerprints the result's banner and, with a feed
+// loaded, records the candidate CVEs and the highest CVSS score
+func tagVulnerabilities(r *ScanResult) {
+	r.CPE = fingerprint(r.Banner)
+	if r.CPE == "" || vulnDB == nil {
+		return
+	}
+	for _, v := range vulnDB.Match(r.CPE) {
+		r.CVEs = append(r.CVEs, v.ID)
+		r.CVSS = max(r.CVSS, v.CVSS)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"port-scanner/simnet"
+)
+
+// TestCompareVersions tests ordering of dotted and suffixed versions
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2.4.49", "2.4.49", 0},
+		{"2.4.9", "2.4.49", -1},
+		{"2.4", "2.4.0", -1},
+		{"9.8p1", "9.8", 1},
+		{"8.5p1", "8.9p1", -1},
+		{"9.10", "9.9p1", 1},
+		{"1.3.5b", "1.3.5a", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// TestFingerprint tests mapping banners to CPE names
+func TestFingerprint(t *testing.T) {
+	tests := map[string]string{
+		"SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6":                 "cpe:2.3:a:openbsd:openssh:8.9p1",
+		"220 (vsFTPd 2.3.4)":                                      "cpe:2.3:a:vsftpd_project:vsftpd:2.3.4",
+		"HTTP/1.1 200 OK | Server: Apache/2.4.49 (Unix)":          "cpe:2.3:a:apache:http_server:2.4.49",
+		"HTTP/1.1 404 Not Found | server: nginx/1.18.0 | Date: x": "cpe:2.3:a:f5:nginx:1.18.0",
+		"220 mail.example.com ESMTP Postfix":                      "",
+		"":                                                        "",
+	}
+	for banner, want := range tests {
+		if got := fingerprint(banner); got != want {
+			t.Errorf("fingerprint(%q) = %q, want %q", banner, got, want)
+		}
+	}
+	if got := cleanBanner("220 hi\r\n\x00\x1bxyz\r\n" + strings.Repeat("a", 400)); len(got) != maxBannerLength || !strings.HasPrefix(got, "220 hi | xyz | aaa") {
+		t.Errorf("cleanBanner() = %q", got)
+	}
+	// A multi-byte character straddling the limit is dropped, not split
+	if got := cleanBanner("a" + strings.Repeat("é", 200)); !utf8.ValidString(got) || len(got) != maxBannerLength-1 {
+		t.Errorf("cleanBanner() split a character: %q", got)
+	}
+}
+
+// TestVulnDBMatch tests exact and ranged matches against the example feed
+func TestVulnDBMatch(t *testing.T) {
+	db, err := loadVulnDB("cve.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(cpe string) string {
+		var s []string
+		for _, v := range db.Match(cpe) {
+			s = append(s, v.ID)
+		}
+		return strings.Join(s, ",")
+	}
+	tests := map[string]string{
+		"cpe:2.3:a:apache:http_server:2.4.49": "CVE-2021-42013,CVE-2021-41773",
+		"cpe:2.3:a:apache:http_server:2.4.50": "CVE-2021-42013",
+		"cpe:2.3:a:apache:http_server:2.4.51": "",
+		"cpe:2.3:a:openbsd:openssh:8.9p1":     "CVE-2024-6387,CVE-2023-48795",
+		"cpe:2.3:a:openbsd:openssh:9.6":       "CVE-2024-6387",
+		"cpe:2.3:a:openbsd:openssh:9.8p1":     "",
+		"cpe:2.3:a:openbsd:openssh:4.3":       "CVE-2024-6387,CVE-2023-48795",
+		"cpe:2.3:a:f5:nginx:1.18.0":           "",
+		"not a cpe":                           "",
+	}
+	for cpe, want := range tests {
+		if got := ids(cpe); got != want {
+			t.Errorf("Match(%q) = %s, want %s", cpe, got, want)
+		}
+	}
+
+	for _, bad := range []string{
+		`{"vulnerabilities": [{"cvss": 5, "matches": []}]}`,
+		`{"vulnerabilities": [{"id": "CVE-1", "cvss": 11}]}`,
+		`{"vulnerabilities": [{"id": "CVE-1", "cvss": 5, "matches": [{"cpe": "apache:http_server"}]}]}`,
+		`[`,
+	} {
+		if _, err := loadVulnDB(writeConfig(t, bad)); err == nil {
+			t.Errorf("loadVulnDB() accepted %s", bad)
+		}
+	}
+}
+
+// TestScanTagsVulnerabilities scans simulated services and checks their
+// banners, CPEs and CVEs, the severity filter and the alert
+func TestScanTagsVulnerabilities(t *testing.T) {
+	httpd := func(c net.Conn) {
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		if strings.HasPrefix(line, "HEAD ") {
+			c.Write([]byte("HTTP/1.0 200 OK\r\nServer: Apache/2.4.50 (Unix)\r\n\r\n"))
+		}
+	}
+	useSimnet(t, simnet.Topology{Hosts: []simnet.Host{
+		{Addr: "10.40.0.1", Ports: []simnet.Port{
+			{Port: 21, Banner: "220 (vsFTPd 2.3.4)\r\n"},
+			{Port: 22, Banner: "SSH-2.0-OpenSSH_9.8p1\r\n"},
+			{Port: 80, Handler: httpd},
+		}},
+	}})
+	originalDB, originalTimeout, originalScore, originalEvents := vulnDB, bannerTimeout, cveAlertScore, events
+	defer func() {
+		vulnDB, bannerTimeout, cveAlertScore, events = originalDB, originalTimeout, originalScore, originalEvents
+	}()
+	var err error
+	if vulnDB, err = loadVulnDB("cve.example.json"); err != nil {
+		t.Fatal(err)
+	}
+	bannerTimeout = 50 * time.Millisecond
+	cveAlertScore, _ = parseSeverity("critical")
+	events = NewEventBus(16)
+	var sent []Email
+	sendFunc = func(e Email) { sent = append(sent, e) }
+
+	results.Reset()
+	if err := scanRange("10.40.0.1", "10.40.0.1", []int{21, 22, 80}, 100*time.Millisecond, 3, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int]ScanResult)
+	for _, r := range results.Open() {
+		got[r.Port] = r
+	}
+	if r := got[21]; r.CPE != "cpe:2.3:a:vsftpd_project:vsftpd:2.3.4" || r.CVSS != 9.8 || len(r.CVEs) != 1 {
+		t.Errorf("ftp result = %+v", r)
+	}
+	if r := got[22]; r.Banner != "SSH-2.0-OpenSSH_9.8p1" || len(r.CVEs) != 0 || r.CVSS != 0 {
+		t.Errorf("ssh result = %+v", r)
+	}
+	if r := got[80]; r.CPE != "cpe:2.3:a:apache:http_server:2.4.50" || r.CVSS != 9.8 || r.CVEs[0] != "CVE-2021-42013" {
+		t.Errorf("http result = %+v", r)
+	}
+
+	var alerts int
+	for _, e := range sent {
+		if strings.HasPrefix(e.Subject, "Vulnerable service found: critical") {
+			alerts++
+		}
+	}
+	if alerts != 2 {
+		t.Errorf("sent %d vulnerability alerts, want 2: %+v", alerts, sent)
+	}
+	replay, _, cancel := events.Subscribe(0)
+	cancel()
+	var vulnerable int
+	for _, e := range replay {
+		if e.Type == EventVulnerable {
+			vulnerable++
+		}
+	}
+	if vulnerable != 2 {
+		t.Errorf("published %d vulnerable_service events, want 2", vulnerable)
+	}
+
+	rec := httptest.NewRecorder()
+	handleResults(rec, httptest.NewRequest(http.MethodGet, "/results?severity=critical", nil))
+	var page struct {
+		Total   int              `json:"total"`
+		Results []map[string]any `json:"results"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil || page.Total != 2 || page.Results[0]["severity"] != SeverityCritical {
+		t.Errorf("GET /results?severity=critical = %d %+v", rec.Code, page)
+	}
+	for _, q := range []string{"severity=extreme", "min_cvss=11"} {
+		rec := httptest.NewRecorder()
+		handleResults(rec, httptest.NewRequest(http.MethodGet, "/results?"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET /results?%s = %d, want 400", q, rec.Code)
+		}
+	}
+}
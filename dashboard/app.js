@@ -0,0 +1,374 @@
+// Dashboard for the port-scanner HTTP API. Views are picked by the URL
+// fragment: #/, #/jobs, #/jobs/<id>, #/history and #/hosts/<ip>.
+(function () {
+  "use strict";
+
+  var maxFindings = 200;
+  var refreshInterval = 2000;
+  var timer = null;
+
+  function $(id) { return document.getElementById(id); }
+
+  // el builds an element; children are nodes or text, never markup
+  function el(tag, attrs) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) {
+      if (k === "class") node.className = attrs[k];
+      else if (k === "onclick") node.onclick = attrs[k];
+      else node.setAttribute(k, attrs[k]);
+    });
+    for (var i = 2; i < arguments.length; i++) {
+      var c = arguments[i];
+      if (c === null || c === undefined) continue;
+      node.appendChild(typeof c === "object" ? c : document.createTextNode(String(c)));
+    }
+    return node;
+  }
+
+  function row() {
+    var tr = el("tr");
+    Array.prototype.forEach.call(arguments, function (c) {
+      tr.appendChild(c instanceof HTMLElement && c.tagName === "TD" ? c : el("td", null, c));
+    });
+    return tr;
+  }
+
+  function fill(id, rows) {
+    var body = $(id).tBodies[0];
+    body.textContent = "";
+    rows.forEach(function (r) { body.appendChild(r); });
+  }
+
+  function hostLink(ip, hostname) {
+    return el("a", { href: "#/hosts/" + encodeURIComponent(ip) }, hostname ? hostname + " (" + ip + ")" : ip);
+  }
+
+  function jobLink(id) {
+    return id ? el("a", { href: "#/jobs/" + encodeURIComponent(id) }, id) : "";
+  }
+
+  function time(t) {
+    return t ? new Date(t).toLocaleString() : "";
+  }
+
+  function duration(seconds) {
+    if (!seconds) return "";
+    var s = Math.round(seconds);
+    if (s < 60) return s + "s";
+    if (s < 3600) return Math.floor(s / 60) + "m" + (s % 60) + "s";
+    return Math.floor(s / 3600) + "h" + Math.floor((s % 3600) / 60) + "m";
+  }
+
+  function cvss(score) {
+    return score ? score.toFixed(1) : "";
+  }
+
+  function progress(p) {
+    var pct = p.total ? p.percent_done : 0;
+    return el("div", null,
+      el("div", { class: "progress" }, el("div", { style: "width: " + pct.toFixed(1) + "%" })),
+      el("span", { class: "muted" }, pct.toFixed(1) + "% of " + p.total + " probes"));
+  }
+
+  function targets(spec) {
+    return spec.hosts && spec.hosts.length ? spec.hosts.join(", ") : spec.start + " - " + spec.end;
+  }
+
+  function api(method, path, body) {
+    var opts = { method: method, headers: {} };
+    if (body !== undefined) {
+      opts.headers["Content-Type"] = "application/json";
+      opts.body = JSON.stringify(body);
+    }
+    return fetch(path, opts).then(function (res) {
+      return res.text().then(function (text) {
+        var data = text ? JSON.parse(text) : null;
+        if (!res.ok) throw new Error((data && data.error) || res.status + " " + res.statusText);
+        return data;
+      });
+    });
+  }
+
+  function showError(err) {
+    $("error").textContent = err ? String(err.message || err) : "";
+  }
+
+  // Overview
+
+  function renderStatus(s) {
+    var dl = el("dl", null,
+      el("dt", null, "Iteration"), el("dd", null, s.iteration + (s.running ? " (running)" : " (idle)")),
+      el("dt", null, "Targets"), el("dd", null, s.start_ip ? s.start_ip + " - " + s.end_ip : ""),
+      el("dt", null, "Progress"), el("dd", null, progress(s)),
+      el("dt", null, "Open ports"), el("dd", null, s.open),
+      el("dt", null, "Rate"), el("dd", null, s.rate_per_second ? s.rate_per_second.toFixed(1) + " probes/s" : ""),
+      el("dt", null, "Elapsed"), el("dd", null, duration(s.elapsed_seconds)),
+      el("dt", null, "Remaining"), el("dd", null, duration(s.eta_seconds)),
+      el("dt", null, "Next run"), el("dd", null, time(s.next_run)));
+    if (s.notifications) {
+      var n = s.notifications;
+      dl.appendChild(el("dt", null, "Notifications"));
+      dl.appendChild(el("dd", null, n.depth + " queued, " + n.delivered + " delivered, " + n.dead_lettered + " failed" +
+        (n.last_error ? " (last error: " + n.last_error + ")" : "")));
+    }
+    var card = $("status");
+    card.textContent = "";
+    card.appendChild(dl);
+  }
+
+  function renderHosts(results) {
+    var hosts = {};
+    var order = [];
+    results.forEach(function (r) {
+      var h = hosts[r.ip];
+      if (!h) {
+        h = hosts[r.ip] = { ip: r.ip, hostname: r.hostname, ports: [], cvss: 0 };
+        order.push(h);
+      }
+      h.ports.push(r.port);
+      h.cvss = Math.max(h.cvss, r.cvss || 0);
+    });
+    fill("hosts", order.map(function (h) {
+      return row(hostLink(h.ip, h.hostname), h.ports.sort(function (a, b) { return a - b; }).join(", "), cvss(h.cvss));
+    }));
+  }
+
+  function loadOverview() {
+    return Promise.all([
+      api("GET", "/status").then(renderStatus),
+      api("GET", "/results/open").then(renderHosts)
+    ]);
+  }
+
+  function addFinding(ev) {
+    var d = ev.data || {};
+    var detail = "";
+    var cls = "";
+    switch (ev.type) {
+      case "open_port":
+        detail = d.banner || d.cpe || "";
+        cls = "open";
+        break;
+      case "vulnerable_service":
+        detail = (d.cves || []).join(", ") + (d.cvss ? " (CVSS " + cvss(d.cvss) + ")" : "");
+        cls = "violation";
+        break;
+      case "state_change":
+        detail = d.from + " → " + d.to;
+        break;
+      case "policy_violation":
+        detail = d.type + (d.rule ? " (" + d.rule + ")" : "");
+        cls = "violation";
+        break;
+      default:
+        return;
+    }
+    var tr = row(time(ev.time), el("td", { class: cls }, ev.type.replace("_", " ")), hostLink(d.ip, d.hostname), d.port, detail, jobLink(ev.job_id));
+    var body = $("findings").tBodies[0];
+    body.insertBefore(tr, body.firstChild);
+    while (body.rows.length > maxFindings) body.deleteRow(body.rows.length - 1);
+    $("findings-empty").style.display = "none";
+  }
+
+  function listen() {
+    var source = new EventSource("/events?types=open_port,vulnerable_service,state_change,policy_violation,scan_complete");
+    source.onopen = function () { $("live").textContent = "live"; };
+    source.onerror = function () { $("live").textContent = "reconnecting…"; };
+    ["open_port", "vulnerable_service", "state_change", "policy_violation"].forEach(function (type) {
+      source.addEventListener(type, function (e) { addFinding(JSON.parse(e.data)); });
+    });
+    source.addEventListener("scan_complete", function () { refresh(); });
+    // Events were missed while disconnected
+    source.addEventListener("reset", function () { refresh(); });
+  }
+
+  // Jobs
+
+  function cancel(id) {
+    return function () {
+      if (!confirm("Cancel job " + id + "?")) return;
+      api("DELETE", "/scans/" + encodeURIComponent(id)).then(refresh, showError);
+    };
+  }
+
+  function loadJobs() {
+    return api("GET", "/scans").then(function (jobs) {
+      jobs.sort(function (a, b) { return new Date(b.created_at) - new Date(a.created_at); });
+      fill("jobs", jobs.filter(function (j) { return j.state === "queued" || j.state === "running"; }).map(function (j) {
+        return row(jobLink(j.id), el("td", { class: j.state }, j.state), targets(j.spec), j.spec.ports.join(", "),
+          progress(j.progress), j.open.length, time(j.created_at), el("button", { onclick: cancel(j.id) }, "Cancel"));
+      }));
+      fill("finished", jobs.filter(function (j) { return j.state !== "queued" && j.state !== "running"; }).map(function (j) {
+        return row(jobLink(j.id), el("td", { class: j.error ? "error" : "" }, j.state + (j.error ? ": " + j.error : "")),
+          targets(j.spec), j.progress.completed, j.open.length, duration(j.progress.elapsed_seconds), time(j.finished_at));
+      }));
+    });
+  }
+
+  // parsePorts reads "22,80,8000-8100"
+  function parsePorts(s) {
+    var ports = [];
+    s.split(",").forEach(function (part) {
+      part = part.trim();
+      if (!part) return;
+      var m = /^(\d+)(?:-(\d+))?$/.exec(part);
+      if (!m) throw new Error("invalid port " + JSON.stringify(part));
+      var lo = parseInt(m[1], 10), hi = m[2] ? parseInt(m[2], 10) : lo;
+      if (lo < 1 || hi > 65535 || lo > hi) throw new Error("invalid port range " + JSON.stringify(part));
+      for (var p = lo; p <= hi; p++) ports.push(p);
+    });
+    return ports;
+  }
+
+  function jobSpec(form) {
+    var spec = { ports: parsePorts(form.ports.value) };
+    var hosts = form.hosts.value.split(",").map(function (h) { return h.trim(); }).filter(Boolean);
+    if (hosts.length) spec.hosts = hosts;
+    if (form.start.value) spec.start = form.start.value.trim();
+    if (form.end.value) spec.end = form.end.value.trim();
+    if (form.timeout.value) spec.timeout = form.timeout.value.trim();
+    if (form.random.checked) spec.random = true;
+    return spec;
+  }
+
+  function submit(dryRun) {
+    var form = $("submit");
+    var out = $("submit-result");
+    out.className = "";
+    var spec;
+    try {
+      spec = jobSpec(form);
+    } catch (err) {
+      out.className = "error";
+      out.textContent = err.message;
+      return;
+    }
+    api("POST", "/scans" + (dryRun ? "?dry_run=true" : ""), spec).then(function (data) {
+      if (dryRun) {
+        out.textContent = data.addresses + " addresses, " + data.probes + " probes (" + data.excluded + " excluded), " +
+          duration(data.min_duration / 1e9) + " to " + duration(data.max_duration / 1e9);
+        return;
+      }
+      out.textContent = "";
+      out.appendChild(el("span", null, "Queued job ", jobLink(data.id)));
+      refresh();
+    }, function (err) {
+      out.className = "error";
+      out.textContent = err.message;
+    });
+  }
+
+  function loadJob(id) {
+    return api("GET", "/scans/" + encodeURIComponent(id)).then(function (j) {
+      $("job-id").textContent = j.id;
+      var card = $("job");
+      card.textContent = "";
+      card.appendChild(el("dl", null,
+        el("dt", null, "State"), el("dd", { class: j.state }, j.state + (j.error ? ": " + j.error : "")),
+        el("dt", null, "Targets"), el("dd", null, targets(j.spec)),
+        el("dt", null, "Ports"), el("dd", null, j.spec.ports.join(", ")),
+        el("dt", null, "Progress"), el("dd", null, progress(j.progress)),
+        el("dt", null, "Rate"), el("dd", null, j.progress.rate_per_second ? j.progress.rate_per_second.toFixed(1) + " probes/s" : ""),
+        el("dt", null, "Created"), el("dd", null, time(j.created_at)),
+        el("dt", null, "Started"), el("dd", null, time(j.started_at)),
+        el("dt", null, "Finished"), el("dd", null, time(j.finished_at))));
+      if (j.state === "queued" || j.state === "running") {
+        card.appendChild(el("button", { onclick: cancel(j.id) }, "Cancel"));
+      }
+      fill("job-open", j.open.map(function (r) {
+        return row(hostLink(r.ip, r.hostname), r.port, r.banner || r.cpe || "", cvss(r.cvss));
+      }));
+    });
+  }
+
+  // History
+
+  function loadHistory() {
+    return Promise.all([
+      api("GET", "/reports").then(function (reports) {
+        $("reports-empty").style.display = reports.length ? "none" : "";
+        fill("reports", reports.map(function (r) {
+          return row(el("a", { href: r.url, target: "_blank" }, time(r.finished)), r.job_id ? jobLink(r.job_id) : r.scan_id, r.target, r.open);
+        }));
+      }),
+      loadJobs()
+    ]);
+  }
+
+  // Hosts
+
+  function loadHost(ip) {
+    $("host-ip").textContent = ip;
+    return Promise.all([
+      api("GET", "/results?ip=" + encodeURIComponent(ip)).then(function (results) {
+        results = results.filter(function (r) { return r.state !== "closed"; });
+        results.sort(function (a, b) { return a.port - b.port; });
+        fill("host-results", results.map(function (r) {
+          return row(r.port, el("td", { class: r.state }, r.state), r.banner || "", r.cpe || "", cvss(r.cvss), (r.cves || []).join(", "), r.route || "",
+            r.latency_ms ? r.latency_ms.toFixed(2) + " ms" : "", time(r.started));
+        }));
+      }),
+      api("GET", "/results/hosts").then(function (hosts) {
+        var h = hosts.filter(function (h) { return h.ip === ip; })[0];
+        $("host-rtt").textContent = h ? h.open + " open, " + h.filtered + " filtered, " + h.closed + " closed" +
+          (h.errors ? ", " + h.errors + " failed in the proxy chain" : "") +
+          (h.rtt.samples ? "; connect time min " + h.rtt.min_ms + " ms, avg " + h.rtt.avg_ms + " ms, p95 " + h.rtt.p95_ms + " ms" : "") : "";
+      }),
+      api("GET", "/scans").then(function (jobs) {
+        var rows = [];
+        jobs.forEach(function (j) {
+          j.open.filter(function (r) { return r.ip === ip; }).forEach(function (r) {
+            rows.push(row(jobLink(j.id), r.port, r.banner || r.cpe || "", cvss(r.cvss)));
+          });
+        });
+        fill("host-jobs", rows);
+      })
+    ]);
+  }
+
+  // Routing
+
+  var routes = [
+    { pattern: /^#?\/?$/, view: "overview", nav: "overview", load: loadOverview },
+    { pattern: /^#\/jobs$/, view: "jobs", nav: "jobs", load: loadJobs },
+    { pattern: /^#\/jobs\/(.+)$/, view: "job", nav: "jobs", load: loadJob },
+    { pattern: /^#\/history$/, view: "history", nav: "history", load: loadHistory },
+    { pattern: /^#\/hosts\/(.+)$/, view: "host", nav: "overview", load: loadHost }
+  ];
+
+  function current() {
+    var hash = location.hash;
+    for (var i = 0; i < routes.length; i++) {
+      var m = routes[i].pattern.exec(hash);
+      if (m) return { route: routes[i], arg: m[1] && decodeURIComponent(m[1]) };
+    }
+    return { route: routes[0] };
+  }
+
+  function refresh() {
+    var c = current();
+    return c.route.load(c.arg).then(function () { showError(null); }, showError);
+  }
+
+  function navigate() {
+    var c = current();
+    Array.prototype.forEach.call(document.querySelectorAll(".view"), function (v) {
+      v.classList.toggle("active", v.id === "view-" + c.route.view);
+    });
+    Array.prototype.forEach.call(document.querySelectorAll("nav a"), function (a) {
+      a.classList.toggle("active", a.dataset.nav === c.route.nav);
+    });
+    clearInterval(timer);
+    refresh();
+    timer = setInterval(refresh, refreshInterval);
+  }
+
+  $("submit").addEventListener("submit", function (e) {
+    e.preventDefault();
+    submit(false);
+  });
+  $("dry-run").addEventListener("click", function () { submit(true); });
+  window.addEventListener("hashchange", navigate);
+  listen();
+  navigate();
+})();
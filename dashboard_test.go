@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestDashboard tests serving the web UI and that the endpoints it reads
+// exist
+func TestDashboard(t *testing.T) {
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	registerJobAPI(mux, NewJobManager(1, 1, defaultJobsKept))
+	registerReportAPI(mux)
+	registerDashboard(mux)
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	if rec := get("/"); rec.Code != http.StatusFound || rec.Header().Get("Location") != "/ui/" {
+		t.Errorf("GET / = %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := get("/nope"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /nope = %d", rec.Code)
+	}
+	rec := get("/ui/")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), `<script src="app.js">`) {
+		t.Fatalf("GET /ui/ = %d %s", rec.Code, rec.Header())
+	}
+	for path, contentType := range map[string]string{"/ui/app.js": "text/javascript", "/ui/style.css": "text/css"} {
+		if rec := get(path); rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), contentType) {
+			t.Errorf("GET %s = %d %s", path, rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+
+	js := get("/ui/app.js").Body.String()
+	paths := regexp.MustCompile(`api\("GET", "(/[a-z/]*[a-z])"`).FindAllStringSubmatch(js, -1)
+	if len(paths) == 0 {
+		t.Fatal("found no API calls in app.js")
+	}
+	for _, m := range paths {
+		if rec := get(m[1]); rec.Code != http.StatusOK {
+			t.Errorf("GET %s, used by the dashboard = %d", m[1], rec.Code)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for DNSConfig
+const (
+	defaultDNSTimeout  = 2 * time.Second
+	defaultDNSCacheTTL = 5 * time.Minute
+)
+
+// DNSConfig selects the resolver for hostname targets and reverse lookups
+type DNSConfig struct {
+	// Server is a host:port to query instead of the system resolver
+	Server   string        `yaml:"server"`
+	Timeout  time.Duration `yaml:"timeout"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// Reverse looks up the PTR name of hosts with open ports
+	Reverse bool `yaml:"reverse"`
+}
+
+// Resolver is the part of net.Resolver the scanner uses, so tests can
+// substitute their own
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// newResolver returns the system resolver, or one that sends every query to
+// server
+func newResolver(server string) Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// dnsCache caches forward and reverse lookups, failures included, for ttl
+type dnsCache struct {
+	resolver Resolver
+	ttl      time.Duration
+	timeout  time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	forward map[string]dnsEntry
+	reverse map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	values  []string
+	err     error
+	expires time.Time
+}
+
+func newDNSCache(r Resolver, ttl, timeout time.Duration) *dnsCache {
+	return &dnsCache{
+		resolver: r,
+		ttl:      ttl,
+		timeout:  timeout,
+		now:      time.Now,
+		forward:  make(map[string]dnsEntry),
+		reverse:  make(map[string]dnsEntry),
+	}
+}
+
+// resolver resolves hostname targets
+var resolver = newDNSCache(net.DefaultResolver, defaultDNSCacheTTL, defaultDNSTimeout)
+
+// reverseDNS enables PTR lookups for hosts with open ports
+var reverseDNS bool
+
+func (c *dnsCache) lookup(cache map[string]dnsEntry, key string, fn func(context.Context, string) ([]string, error)) ([]string, error) {
+	c.mu.Lock()
+	e, ok := cache[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(e.expires) {
+		return e.values, e.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	values, err := fn(ctx, key)
+	c.mu.Lock()
+	cache[key] = dnsEntry{values: values, err: err, expires: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return values, err
+}
+
+// LookupHost returns the A and AAAA addresses of host, IPv4 first
+func (c *dnsCache) LookupHost(host string) ([]string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	addrs, err := c.lookup(c.forward, host, c.resolver.LookupHost)
+	if err != nil {
+		return nil, err
+	}
+	addrs = append([]string(nil), addrs...)
+	sort.SliceStable(addrs, func(a, b int) bool {
+		return net.ParseIP(addrs[a]).To4() != nil && net.ParseIP(addrs[b]).To4() == nil
+	})
+	return addrs, nil
+}
+
+// Reverse returns the first PTR name of ip without the trailing dot, or ""
+func (c *dnsCache) Reverse(ip string) string {
+	names, err := c.lookup(c.reverse, ip, c.resolver.LookupAddr)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// validHostname reports whether s is a literal fully qualified or short
+// host name; wildcards are not allowed
+func validHostname(s string) error {
+	name := strings.TrimSuffix(s, ".")
+	if strings.Contains(name, "*") {
+		return fmt.Errorf("wildcard host name %q is not supported", s)
+	}
+	if name == "" || len(name) > 253 {
+		return fmt.Errorf("invalid host name %q", s)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid host name %q", s)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return fmt.Errorf("invalid host name %q", s)
+			}
+		}
+	}
+	return nil
+}
+
+// loadHostTargets merges hosts and the lines of file, which may also hold
+// IP addresses, dropping duplicates
+func loadHostTargets(hosts []string, file string) ([]string, error) {
+	all := append([]string(nil), hosts...)
+	if file != "" {
+		lines, err := readListFile(file)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, lines...)
+	}
+	var errs []error
+	seen := make(map[string]bool)
+	var targets []string
+	for _, h := range all {
+		h = strings.TrimSpace(h)
+		if net.ParseIP(h) == nil {
+			if err := validHostname(h); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if key := strings.ToLower(strings.TrimSuffix(h, ".")); !seen[key] {
+			seen[key] = true
+			targets = append(targets, h)
+		}
+	}
+	return targets, errors.Join(errs...)
+}
+
+// hostTarget is a host and the addresses it resolved to
+type hostTarget struct {
+	Name  string
+	Addrs []string
+}
+
+// resolveTargets resolves each host, logging and skipping those that fail.
+// IP addresses are kept as they are.
+func resolveTargets(logger *slog.Logger, hosts []string) []hostTarget {
+	var targets []hostTarget
+	for _, h := range hosts {
+		if net.ParseIP(h) != nil {
+			targets = append(targets, hostTarget{Addrs: []string{h}})
+			continue
+		}
+		addrs, err := resolver.LookupHost(h)
+		if err != nil {
+			logger.Warn("resolving target", "host", h, "error", err)
+			continue
+		}
+		targets = append(targets, hostTarget{Name: h, Addrs: addrs})
+	}
+	return targets
+}
+
+// countHostProbes returns how many probes of targets x ports the scope permits
+func countHostProbes(targets []hostTarget, ports []int) uint64 {
+	var n uint64
+	for _, t := range targets {
+		for _, addr := range t.Addrs {
+			for _, port := range ports {
+				if scope.Permits(addr, port) {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// scanHosts probes every resolved address of targets on ports, recording the
+// host name in the results
+func scanHosts(ctx context.Context, targets []hostTarget, ports []int, timeout time.Duration, maxConcurrent int, resultChan chan<- ScanResult) {
+	limiter := make(chan struct{}, maxConcurrent)
+	slots := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	logger := loggerFrom(ctx)
+	for _, t := range targets {
+		for _, addr := range t.Addrs {
+			// Names can resolve elsewhere after the targets were checked
+			skipped := 0
+			for _, port := range ports {
+				if !scope.Permits(addr, port) {
+					skipped++
+					continue
+				}
+				if probeLimiter.Wait(ctx) != nil {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case slots <- struct{}{}:
+				}
+				wg.Add(1)
+				go func(name, ip string, port int) {
+					defer func() {
+						<-slots
+						wg.Done()
+					}()
+					result := scanTarget(ip, name, port, timeout, limiter)
+					logProbe(logger, result)
+					resultChan <- result
+				}(t.Name, addr, port)
+			}
+			if skipped > 0 {
+				logger.Warn("skipped probes outside scope", "host", t.Name, "ip", addr, "probes", skipped)
+			}
+		}
+	}
+}
+
+// scanHostList is scanRange for a list of host names and addresses
+func scanHostList(hosts []string, ports []int, timeout time.Duration, maxConcurrent int) error {
+	logger := slog.With("scan_id", status.ScanID())
+	ctx := withLogger(context.Background(), logger)
+
+	targets := resolveTargets(logger, hosts)
+	if len(targets) == 0 {
+		return fmt.Errorf("none of the %d target hosts resolved", len(hosts))
+	}
+	first, last := hosts[0], hosts[len(hosts)-1]
+	status.begin(first, last, countHostProbes(targets, ports))
+	defer status.finish()
+
+	resultChan := make(chan ScanResult, maxConcurrent)
+	done := make(chan struct{})
+	go func() {
+		for result := range resultChan {
+			recordResult(result)
+		}
+		close(done)
+	}()
+	scanHosts(ctx, targets, ports, timeout, maxConcurrent, resultChan)
+	close(resultChan)
+	<-done
+
+	snap := status.Snapshot()
+	events.Publish(EventScanComplete, "", ScanComplete{Start: first, End: last, Probes: snap.Completed, Open: snap.Open, ElapsedSeconds: snap.ElapsedSeconds})
+	return nil
+}
+
+// compareIPs orders IPv4 before IPv6 addresses, each numerically
+func compareIPs(a, b string) int {
+	x, errX := netip.ParseAddr(a)
+	y, errY := netip.ParseAddr(b)
+	if errX != nil || errY != nil {
+		return strings.Compare(a, b)
+	}
+	return x.Unmap().Compare(y.Unmap())
+}
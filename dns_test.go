@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"port-scanner/simnet"
+)
+
+// stubDNS answers A, AAAA and PTR queries over UDP from records, keyed by
+// lower-case name with the trailing dot
+type stubDNS struct {
+	conn    net.PacketConn
+	records map[string][]string
+}
+
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeAAAA = 28
+)
+
+func startStubDNS(t *testing.T, records map[string][]string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s := &stubDNS{conn: conn, records: records}
+	go s.serve()
+	return conn.LocalAddr().String()
+}
+
+func (s *stubDNS) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.answer(buf[:n]); resp != nil {
+			s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *stubDNS) answer(q []byte) []byte {
+	if len(q) < 12 {
+		return nil
+	}
+	// Question name
+	var labels []string
+	off := 12
+	for off < len(q) && q[off] != 0 {
+		l := int(q[off])
+		if off+1+l > len(q) {
+			return nil
+		}
+		labels = append(labels, string(q[off+1:off+1+l]))
+		off += 1 + l
+	}
+	off++
+	if off+4 > len(q) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(q[off:])
+	question := q[12 : off+4]
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+
+	var answers [][]byte
+	found := false
+	for _, value := range s.records[name] {
+		found = true
+		var rtype uint16
+		var rdata []byte
+		ip := net.ParseIP(value)
+		switch {
+		case ip != nil && ip.To4() != nil:
+			rtype, rdata = dnsTypeA, ip.To4()
+		case ip != nil:
+			rtype, rdata = dnsTypeAAAA, ip.To16()
+		default:
+			rtype = dnsTypePTR
+			for _, l := range strings.Split(strings.TrimSuffix(value, "."), ".") {
+				rdata = append(append(rdata, byte(len(l))), l...)
+			}
+			rdata = append(rdata, 0)
+		}
+		if rtype != qtype {
+			continue
+		}
+		rr := []byte{0xc0, 12}
+		rr = binary.BigEndian.AppendUint16(rr, rtype)
+		rr = binary.BigEndian.AppendUint16(rr, 1)
+		rr = binary.BigEndian.AppendUint32(rr, 60)
+		rr = binary.BigEndian.AppendUint16(rr, uint16(len(rdata)))
+		answers = append(answers, append(rr, rdata...))
+	}
+
+	resp := append([]byte{}, q[:2]...)
+	flags := uint16(0x8180)
+	if !found {
+		flags |= 3 // NXDOMAIN
+	}
+	resp = binary.BigEndian.AppendUint16(resp, flags)
+	resp = binary.BigEndian.AppendUint16(resp, 1)
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(answers)))
+	resp = append(resp, 0, 0, 0, 0)
+	resp = append(resp, question...)
+	for _, a := range answers {
+		resp = append(resp, a...)
+	}
+	return resp
+}
+
+// fakeResolver counts lookups
+type fakeResolver struct {
+	hosts   map[string][]string
+	lookups int
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.lookups++
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.lookups++
+	if names, ok := r.hosts[addr]; ok {
+		return names, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+// TestDNSCache tests caching of answers and failures until the TTL passes
+func TestDNSCache(t *testing.T) {
+	r := &fakeResolver{hosts: map[string][]string{
+		"web.example.test": {"2001:db8::5", "10.0.0.5"},
+		"10.0.0.5":         {"web.example.test."},
+	}}
+	c := newDNSCache(r, time.Minute, time.Second)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for range 2 {
+		addrs, err := c.LookupHost("WEB.example.test.")
+		if err != nil || strings.Join(addrs, ",") != "10.0.0.5,2001:db8::5" {
+			t.Fatalf("LookupHost() = %v, %v", addrs, err)
+		}
+		if _, err := c.LookupHost("missing.example.test"); err == nil {
+			t.Error("LookupHost(missing) succeeded")
+		}
+		if name := c.Reverse("10.0.0.5"); name != "web.example.test" {
+			t.Errorf("Reverse() = %q", name)
+		}
+	}
+	if r.lookups != 3 {
+		t.Errorf("%d lookups, want 3 with the cache", r.lookups)
+	}
+	now = now.Add(2 * time.Minute)
+	c.LookupHost("web.example.test")
+	if r.lookups != 4 {
+		t.Errorf("%d lookups after expiry, want 4", r.lookups)
+	}
+}
+
+// TestLoadHostTargets tests host name validation and deduplication
+func TestLoadHostTargets(t *testing.T) {
+	file := writeConfig(t, "# assets\ndb.example.test\nWEB.example.test.\n10.0.0.9\n")
+	hosts, err := loadHostTargets([]string{"web.example.test", "mail"}, file)
+	if err != nil || strings.Join(hosts, ",") != "web.example.test,mail,db.example.test,10.0.0.9" {
+		t.Errorf("loadHostTargets() = %v, %v", hosts, err)
+	}
+	for _, bad := range []string{"*.example.test", "-bad.example.test", "a..b", "under score!", strings.Repeat("a", 64) + ".test"} {
+		if _, err := loadHostTargets([]string{bad}, ""); err == nil {
+			t.Errorf("loadHostTargets(%q) accepted", bad)
+		}
+	}
+
+	spec := JobSpec{Hosts: []string{"web.example.test", "web.example.test."}, Ports: []int{22}}
+	if err := spec.validate(); err != nil || len(spec.Hosts) != 1 {
+		t.Errorf("validate() = %v, hosts %v", err, spec.Hosts)
+	}
+	spec = JobSpec{Hosts: []string{"web.example.test"}, Start: "10.0.0.1", End: "10.0.0.2", Ports: []int{22}}
+	if err := spec.validate(); err == nil {
+		t.Error("validate() accepted both hosts and a range")
+	}
+}
+
+// TestJobHostsOutsideScope tests that a job is refused when one of its
+// hosts resolves outside the allowed scope
+func TestJobHostsOutsideScope(t *testing.T) {
+	originalResolver, originalScope := resolver, scope
+	defer func() { resolver, scope = originalResolver, originalScope }()
+	resolver = newDNSCache(&fakeResolver{hosts: map[string][]string{
+		"web.example.test": {"10.0.0.5"},
+		"db.example.test":  {"10.0.0.6", "192.168.9.6"},
+	}}, time.Minute, time.Second)
+	scope = &Scope{allowed: mergeRanges([]ipRange{{ipToUint32("10.0.0.0"), ipToUint32("10.0.0.255")}})}
+
+	m := NewJobManager(1, 10, defaultJobsKept)
+	mux := http.NewServeMux()
+	registerJobAPI(mux, m)
+	for _, tt := range []struct {
+		body string
+		code int
+	}{
+		{`{"hosts":["web.example.test"],"ports":[22]}`, http.StatusAccepted},
+		{`{"hosts":["web.example.test","db.example.test"],"ports":[22]}`, http.StatusForbidden},
+		{`{"hosts":["192.168.9.6"],"ports":[22]}`, http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", "/scans", strings.NewReader(tt.body)))
+		if rec.Code != tt.code {
+			t.Errorf("POST /scans %s returned %d, want %d: %s", tt.body, rec.Code, tt.code, rec.Body)
+		}
+	}
+
+	scope.ignoreScope = true
+	spec := JobSpec{Hosts: []string{"db.example.test"}, Ports: []int{22}}
+	if err := spec.validate(); err != nil {
+		t.Errorf("validate() with the scope overridden = %v", err)
+	}
+}
+
+// TestScanHostList scans named hosts resolved through a stub DNS server and
+// checks host names in results, reverse lookups and emails
+func TestScanHostList(t *testing.T) {
+	useSimnet(t, simnet.Topology{Hosts: []simnet.Host{
+		{Addr: "10.20.0.5", Ports: []simnet.Port{{Port: 22}}},
+		{Addr: "2001:db8::5", Ports: []simnet.Port{{Port: 22}}},
+		{Addr: "10.20.0.9", Ports: []simnet.Port{{Port: 443}}},
+	}})
+	server := startStubDNS(t, map[string][]string{
+		"web.example.test.":       {"10.20.0.5", "2001:db8::5"},
+		"9.0.20.10.in-addr.arpa.": {"api.example.test."},
+		"excluded.example.test.":  {"10.20.0.7"},
+		"5.0.20.10.in-addr.arpa.": {"ignored.example.test."},
+	})
+	originalResolver, originalReverse := resolver, reverseDNS
+	defer func() { resolver, reverseDNS = originalResolver, originalReverse }()
+	resolver = newDNSCache(newResolver(server), time.Minute, time.Second)
+	reverseDNS = true
+	var sent []Email
+	sendFunc = func(e Email) { sent = append(sent, e) }
+
+	results.Reset()
+	hosts := []string{"web.example.test", "10.20.0.9", "excluded.example.test", "missing.example.test"}
+	if err := scanHostList(hosts, []int{22, 443}, 50*time.Millisecond, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// web has two addresses, 10.20.0.9 one, and 10.20.0.7 is excluded
+	if results.Len() != 6 {
+		t.Errorf("%d results, want 6", results.Len())
+	}
+	open := make(map[string]ScanResult)
+	for _, r := range results.Open() {
+		open[r.IP] = r
+	}
+	if len(open) != 3 || open["10.20.0.5"].Hostname != "web.example.test" || open["2001:db8::5"].Hostname != "web.example.test" {
+		t.Errorf("open results = %+v", open)
+	}
+	if open["10.20.0.9"].Hostname != "api.example.test" {
+		t.Errorf("reverse lookup gave %q", open["10.20.0.9"].Hostname)
+	}
+	var found bool
+	for _, e := range sent {
+		found = found || e.Msg == "Port 22 is open on web.example.test (10.20.0.5)"
+	}
+	if !found {
+		t.Errorf("no email names the host: %+v", sent)
+	}
+
+	if err := scanHostList([]string{"missing.example.test"}, []int{22}, 50*time.Millisecond, 1); err == nil {
+		t.Error("scanHostList() with no resolvable host succeeded")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Rough on-the-wire sizes used for traffic estimates: each probe sends a SYN
+// and the ACK or RST that ends the handshake, and receives one reply.
+const (
+	probeBytesOut = 2 * 60
+	probeBytesIn  = 60
+	maxPlanChunks = 20
+)
+
+// ChunkPlan is one chunk of a planned scan
+type ChunkPlan struct {
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Addresses uint64 `json:"addresses"`
+	Probes    uint64 `json:"probes"`
+}
+
+// ScanPlan describes what a scan would do without sending any packets
+type ScanPlan struct {
+	Start       string        `json:"start"`
+	End         string        `json:"end"`
+	Ports       []int         `json:"ports"`
+	Addresses   uint64        `json:"addresses"`
+	Probes      uint64        `json:"probes"`
+	Excluded    uint64        `json:"excluded"`
+	Concurrency int           `json:"concurrency"`
+	Timeout     time.Duration `json:"timeout"`
+	Rate        int           `json:"rate"`
+	MinDuration time.Duration `json:"min_duration"`
+	MaxDuration time.Duration `json:"max_duration"`
+	ProbeRate   float64       `json:"probe_rate"`
+	BytesOut    uint64        `json:"bytes_out"`
+	BytesIn     uint64        `json:"bytes_in"`
+	BitsPerSec  float64       `json:"bits_per_second_out"`
+	Chunks      []ChunkPlan   `json:"chunks"`
+	ChunkCount  int           `json:"chunk_count"`
+}
+
+// planScan expands the range and ports against the current scope. With
+// parallelChunks every chunk runs at once, as in scanRange; otherwise chunks
+// run one after another, as in API jobs.
+func planScan(startIP, endIP string, ports []int, timeout time.Duration, maxConcurrent, chunkSize, rate int, parallelChunks bool) ScanPlan {
+	start, end := ipToUint32(startIP), ipToUint32(endIP)
+	plan := ScanPlan{
+		Start:   startIP,
+		End:     endIP,
+		Ports:   ports,
+		Timeout: timeout,
+		Rate:    max(rate, 0),
+	}
+	if start > end {
+		return plan
+	}
+	plan.Addresses = uint64(end) - uint64(start) + 1
+	plan.Probes = scope.Count(start, end, ports)
+	plan.Excluded = plan.Addresses*uint64(len(ports)) - plan.Probes
+
+	for chunkStart := uint64(start); chunkStart <= uint64(end); chunkStart += uint64(chunkSize) {
+		chunkEnd := min(chunkStart+uint64(chunkSize)-1, uint64(end))
+		plan.ChunkCount++
+		if len(plan.Chunks) < maxPlanChunks {
+			plan.Chunks = append(plan.Chunks, ChunkPlan{
+				Start:     uint32ToIP(uint32(chunkStart)),
+				End:       uint32ToIP(uint32(chunkEnd)),
+				Addresses: chunkEnd - chunkStart + 1,
+				Probes:    scope.Count(uint32(chunkStart), uint32(chunkEnd), ports),
+			})
+		}
+	}
+
+	plan.Concurrency = maxConcurrent
+	if parallelChunks {
+		plan.Concurrency *= plan.ChunkCount
+	}
+	plan.estimate(timeout, rate)
+	return plan
+}
+
+// planHosts is planScan for host targets, with one chunk per host
+func planHosts(targets []hostTarget, ports []int, timeout time.Duration, maxConcurrent, rate int) ScanPlan {
+	plan := ScanPlan{Ports: ports, Timeout: timeout, Rate: max(rate, 0), Concurrency: maxConcurrent}
+	for i, t := range targets {
+		name := t.Name
+		if name == "" {
+			name = t.Addrs[0]
+		}
+		if i == 0 {
+			plan.Start = name
+		}
+		plan.End = name
+		probes := countHostProbes(targets[i:i+1], ports)
+		plan.Addresses += uint64(len(t.Addrs))
+		plan.Probes += probes
+		plan.ChunkCount++
+		if len(plan.Chunks) < maxPlanChunks {
+			plan.Chunks = append(plan.Chunks, ChunkPlan{Start: name, End: strings.Join(t.Addrs, ","), Addresses: uint64(len(t.Addrs)), Probes: probes})
+		}
+	}
+	plan.Excluded = plan.Addresses*uint64(len(ports)) - plan.Probes
+	plan.estimate(timeout, rate)
+	return plan
+}
+
+// estimate fills in the duration and traffic estimates from the probe count
+func (plan *ScanPlan) estimate(timeout time.Duration, rate int) {
+	if plan.Probes == 0 || plan.Concurrency == 0 {
+		return
+	}
+
+	// Worst case every probe waits out the timeout
+	waves := (plan.Probes + uint64(plan.Concurrency) - 1) / uint64(plan.Concurrency)
+	plan.MaxDuration = time.Duration(waves) * timeout
+	plan.ProbeRate = float64(plan.Concurrency) / timeout.Seconds()
+	if rate > 0 {
+		rateLimited := time.Duration(float64(plan.Probes) / float64(rate) * float64(time.Second))
+		plan.MinDuration = rateLimited
+		plan.MaxDuration = max(plan.MaxDuration, rateLimited)
+		plan.ProbeRate = min(plan.ProbeRate, float64(rate))
+	}
+
+	plan.BytesOut = plan.Probes * probeBytesOut
+	plan.BytesIn = plan.Probes * probeBytesIn
+	plan.BitsPerSec = plan.ProbeRate * probeBytesOut * 8
+}
+
+// Write prints the plan in a human-readable form
+func (p ScanPlan) Write(w io.Writer) {
+	fmt.Fprintf(w, "Dry run: %s to %s on ports %s\n", p.Start, p.End, joinPorts(p.Ports))
+	fmt.Fprintf(w, "  Addresses in range:  %d\n", p.Addresses)
+	fmt.Fprintf(w, "  Probes:              %d (%d excluded by scope)\n", p.Probes, p.Excluded)
+	fmt.Fprintf(w, "  Concurrency:         %d\n", p.Concurrency)
+	fmt.Fprintf(w, "  Timeout:             %s\n", p.Timeout)
+	if p.Rate > 0 {
+		fmt.Fprintf(w, "  Rate limit:          %d probes/s\n", p.Rate)
+		fmt.Fprintf(w, "  Estimated duration:  %s to %s\n", p.MinDuration.Round(time.Second), p.MaxDuration.Round(time.Second))
+	} else {
+		fmt.Fprintf(w, "  Rate limit:          none\n")
+		fmt.Fprintf(w, "  Estimated duration:  up to %s (if every probe times out)\n", p.MaxDuration.Round(time.Second))
+	}
+	fmt.Fprintf(w, "  Estimated traffic:   %s out, %s in, ~%s outbound at %.0f probes/s\n",
+		formatBytes(p.BytesOut), formatBytes(p.BytesIn), formatBits(p.BitsPerSec), p.ProbeRate)
+	fmt.Fprintf(w, "  Chunk plan:          %d chunk(s)\n", p.ChunkCount)
+	for i, c := range p.Chunks {
+		fmt.Fprintf(w, "    %4d  %s - %s  %d addresses, %d probes\n", i+1, c.Start, c.End, c.Addresses, c.Probes)
+	}
+	if more := p.ChunkCount - len(p.Chunks); more > 0 {
+		fmt.Fprintf(w, "    ... and %d more\n", more)
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatBits(bps float64) string {
+	switch {
+	case bps >= 1e9:
+		return fmt.Sprintf("%.1f Gbit/s", bps/1e9)
+	case bps >= 1e6:
+		return fmt.Sprintf("%.1f Mbit/s", bps/1e6)
+	case bps >= 1e3:
+		return fmt.Sprintf("%.1f kbit/s", bps/1e3)
+	}
+	return fmt.Sprintf("%.0f bit/s", bps)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPlanScan tests probe counts, estimates and the chunk plan
+func TestPlanScan(t *testing.T) {
+	original := scope
+	defer func() { scope = original }()
+	scope, _ = loadScope(ScopeConfig{Override: true, Exclude: []string{"10.0.0.0/30", "10.0.0.100:22"}})
+
+	plan := planScan("10.0.0.0", "10.0.0.255", []int{22, 80}, time.Second, 100, 100, 0, true)
+	if plan.Addresses != 256 || plan.Probes != 252*2-1 || plan.Excluded != 9 {
+		t.Errorf("planScan() counts = %d addresses, %d probes, %d excluded", plan.Addresses, plan.Probes, plan.Excluded)
+	}
+	if plan.ChunkCount != 3 || len(plan.Chunks) != 3 || plan.Chunks[2].Start != "10.0.0.200" || plan.Chunks[2].Addresses != 56 {
+		t.Errorf("planScan() chunks = %+v", plan.Chunks)
+	}
+	if plan.Concurrency != 300 || plan.MaxDuration != 2*time.Second || plan.MinDuration != 0 {
+		t.Errorf("planScan() timing = %d, %s, %s", plan.Concurrency, plan.MinDuration, plan.MaxDuration)
+	}
+	if plan.BytesOut != plan.Probes*probeBytesOut {
+		t.Errorf("planScan() bytes out = %d", plan.BytesOut)
+	}
+
+	limited := planScan("10.0.0.0", "10.0.0.255", []int{22, 80}, time.Second, 100, 100, 50, false)
+	if limited.Concurrency != 100 || limited.ProbeRate != 50 || limited.MinDuration.Round(time.Second) != 10*time.Second {
+		t.Errorf("planScan() with rate limit = %+v", limited)
+	}
+
+	var out bytes.Buffer
+	plan.Write(&out)
+	for _, want := range []string{"Probes:              503 (9 excluded by scope)", "10.0.0.200 - 10.0.0.255", "3 chunk(s)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Write() output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// TestJobDryRun tests that a dry-run submission is planned but not queued
+func TestJobDryRun(t *testing.T) {
+	m := NewJobManager(1, 10, defaultJobsKept)
+	m.Start(context.Background())
+	mux := http.NewServeMux()
+	registerJobAPI(mux, m)
+
+	body := `{"start":"10.0.0.1","end":"10.0.0.10","ports":[22,80]}`
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/scans?dry_run=true", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("dry run returned %d: %s", rec.Code, rec.Body)
+	}
+	var plan ScanPlan
+	if err := json.NewDecoder(rec.Body).Decode(&plan); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if plan.Probes != 20 || len(m.List()) != 0 {
+		t.Errorf("dry run planned %d probes and queued %d jobs", plan.Probes, len(m.List()))
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"port-scanner/simnet"
+)
+
+// e2eTopology is a small network with an open, a closed and a filtered port
+// on most hosts
+var e2eTopology = simnet.Topology{
+	Seed: 1,
+	Hosts: []simnet.Host{
+		{Addr: "10.20.0.5", Latency: time.Millisecond, Ports: []simnet.Port{
+			{Port: 22, Banner: "SSH-2.0-OpenSSH_9.6\r\n"},
+			{Port: 443, State: simnet.Filtered},
+		}},
+		{Addr: "10.20.0.9", Default: simnet.Filtered, Ports: []simnet.Port{{Port: 80}}},
+		{Addr: "10.20.0.0/28", Ports: []simnet.Port{{Port: 443, State: simnet.Filtered}}},
+	},
+}
+
+func useSimnet(t *testing.T, topo simnet.Topology) *simnet.Network {
+	t.Helper()
+	sim := simnet.MustNew(topo)
+	originalDial := dialTimeout
+	originalSend := sendFunc
+	originalScope := scope
+	t.Cleanup(func() {
+		dialTimeout = originalDial
+		sendFunc = originalSend
+		scope = originalScope
+	})
+	dialTimeout = sim.DialTimeout
+	sendFunc = func(e Email) {}
+	scope, _ = loadScope(ScopeConfig{Override: true, Exclude: []string{"10.20.0.7"}})
+	return sim
+}
+
+// TestEndToEndScanRange scans the simulated network through the scan loop
+func TestEndToEndScanRange(t *testing.T) {
+	sim := useSimnet(t, e2eTopology)
+	for _, order := range []TargetOrder{{}, {Random: true, Seed: 11}} {
+		originalOrder := targetOrder
+		targetOrder = order
+		checkpoints = nil
+		results.Reset()
+		if err := scanRange("10.20.0.0", "10.20.0.15", []int{22, 80, 443}, 20*time.Millisecond, 16, 4, true); err != nil {
+			t.Fatal(err)
+		}
+		targetOrder = originalOrder
+
+		// 16 hosts minus one exclusion, 3 ports each
+		if results.Len() != 45 {
+			t.Errorf("order %+v: %d results, want 45", order, results.Len())
+		}
+		open := make(map[string]bool)
+		for _, r := range results.Open() {
+			open[fmt.Sprintf("%s:%d", r.IP, r.Port)] = true
+		}
+		if len(open) != 2 || !open["10.20.0.5:22"] || !open["10.20.0.9:80"] {
+			t.Errorf("order %+v: open = %v", order, open)
+		}
+		// Port 443 on 15 hosts, plus 22 on the host that drops by default
+		if _, filtered := results.Query(ResultFilter{State: StateFiltered}, 0, 0); filtered != 16 {
+			t.Errorf("order %+v: %d filtered, want 16", order, filtered)
+		}
+	}
+	if sim.Attempts("10.20.0.7:22") != 0 {
+		t.Error("excluded host was dialed")
+	}
+}
+
+// TestEndToEndJobAPI submits a job over HTTP and reads its results
+func TestEndToEndJobAPI(t *testing.T) {
+	useSimnet(t, e2eTopology)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs := NewJobManager(1, 10, defaultJobsKept)
+	jobs.Start(ctx)
+	mux := http.NewServeMux()
+	registerJobAPI(mux, jobs)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/scans", "application/json",
+		strings.NewReader(`{"start":"10.20.0.0","end":"10.20.0.15","ports":[22,80],"timeout":"20ms","random":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST /scans = %s", resp.Status)
+	}
+	job := jobs.List()[0]
+	waitForJobState(t, job, JobCompleted)
+	snap := job.Snapshot()
+	if len(snap.Open) != 2 || snap.Progress.Completed != 30 {
+		t.Errorf("job snapshot = %+v", snap)
+	}
+}
@@ -3,7 +3,8 @@ package main
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
+	"html/template"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -11,52 +12,65 @@ import (
 
 // sendFunc for side-effect testing
 var sendFunc func(Email) = func(e Email) {}
-var sendImpl func(Email) int = func(p Email) int {
+
+// sendImpl posts p to Brevo, returning the status and how long the
+// Retry-After header asks to wait
+var sendImpl func(Email) (int, time.Duration) = func(p Email) (int, time.Duration) {
 	jsonData, err := createPayload(p)
 	if err != nil {
-		fmt.Println("Error creating JSON payload:", err)
-		return 500
+		slog.Error("creating email payload", "error", err)
+		return 500, 0
 	}
 	client := &http.Client{}
 	req, err := http.NewRequest("POST", brevo.URL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return 500
+		slog.Error("creating email request", "error", err)
+		return 500, 0
 	}
 	req.Header.Add("accept", "application/json")
 	req.Header.Add("api-key", brevo.APIKEY)
 	req.Header.Add("content-type", "application/json")
 	res, err := client.Do(req)
 	if err != nil {
-		fmt.Println("Error sending request:", err)
-		return 500
+		slog.Error("sending email", "subject", p.Subject, "error", err)
+		return 500, 0
 	}
 	defer res.Body.Close()
-	return res.StatusCode
+	return res.StatusCode, parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
 }
 
+// send queues p in the outbox, or posts it right away without one
 func send(p Email) int {
 	sendFunc(p)
-	return sendImpl(p)
+	if outbox != nil {
+		outbox.Enqueue(p)
+		return http.StatusAccepted
+	}
+	status, _ := sendImpl(p)
+	if status < 200 || status >= 300 {
+		slog.Error("email not delivered", "subject", p.Subject, "status", status)
+	}
+	return status
 }
 
-// createPayload remains unchanged
+// createPayload builds the Brevo request, which is sent as a multipart
+// message with both bodies
 func createPayload(p Email) ([]byte, error) {
-	formattedMsg := strings.ReplaceAll(p.Msg, "\n", "<br>")
-	formattedMsg += "<br>on " + time.Now().Format("2006-01-02 15:04:05") + " in timezone " + time.Now().Location().String()
-	html := "<html><head></head><body><p>" + formattedMsg + "</p></body></html>"
+	html := p.HTML
+	if html == "" {
+		formattedMsg := strings.ReplaceAll(template.HTMLEscapeString(p.Msg), "\n", "<br>")
+		formattedMsg += "<br>on " + time.Now().Format("2006-01-02 15:04:05") + " in timezone " + time.Now().Location().String()
+		html = "<html><head></head><body><p>" + formattedMsg + "</p></body></html>"
+	}
 
 	payload := EmailPayload{
+		Sender:      Contact{Name: p.SenderName, Email: p.SenderEmail},
 		Subject:     p.Subject,
+		TextContent: p.Msg,
 		HTMLContent: html,
 		Headers:     map[string]string{"Reply-To": p.SenderEmail},
 	}
-	payload.Sender.Email = p.SenderEmail
-	payload.Sender.Name = p.SenderName
-	payload.To = []struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
-	}{{Email: p.ToEmail, Name: p.ToName}}
+	payload.To, payload.CC, payload.BCC = dedupContacts(p.To, p.CC, p.BCC)
 
 	return json.MarshalIndent(payload, "", "  ")
 }
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus
+const (
+	EventOpenPort        = "open_port"
+	EventStateChange     = "state_change"
+	EventChunkComplete   = "chunk_complete"
+	EventScanComplete    = "scan_complete"
+	EventPolicyViolation = "policy_violation"
+	EventVulnerable      = "vulnerable_service"
+	// EventReset tells a resuming client that events were missed, so it
+	// should refetch /results
+	EventReset = "reset"
+)
+
+const (
+	eventBufferSize      = 1024
+	subscriberBufferSize = 64
+	sseKeepAlive         = 15 * time.Second
+)
+
+// Event is a single finding or progress notification
+type Event struct {
+	ID    uint64    `json:"id"`
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	JobID string    `json:"job_id,omitempty"`
+	Data  any       `json:"data"`
+}
+
+type StateChange struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ChunkComplete reports a finished chunk. Randomized scans also report the
+// range of permutation positions the chunk covered.
+type ChunkComplete struct {
+	Start        string `json:"start"`
+	End          string `json:"end"`
+	FromPosition uint64 `json:"from_position,omitempty"`
+	ToPosition   uint64 `json:"to_position,omitempty"`
+}
+
+// EventGap is the data of a reset event. Since is the client's cursor and
+// Resume the ID the replay continues from.
+type EventGap struct {
+	Since  uint64 `json:"since"`
+	Resume uint64 `json:"resume"`
+}
+
+type ScanComplete struct {
+	Start          string  `json:"start"`
+	End            string  `json:"end"`
+	Probes         uint64  `json:"probes"`
+	Open           uint64  `json:"open"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// EventBus fans events out to subscribers and keeps the most recent ones so
+// reconnecting clients can replay what they missed
+type EventBus struct {
+	mu     sync.Mutex
+	nextID uint64
+	buffer []Event
+	head   int
+	subs   map[chan Event]struct{}
+}
+
+func NewEventBus(size int) *EventBus {
+	return &EventBus{
+		nextID: 1,
+		buffer: make([]Event, 0, size),
+		subs:   make(map[chan Event]struct{}),
+	}
+}
+
+func (b *EventBus) Publish(typ, jobID string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := Event{ID: b.nextID, Type: typ, Time: time.Now(), JobID: jobID, Data: data}
+	b.nextID++
+	if len(b.buffer) < cap(b.buffer) {
+		b.buffer = append(b.buffer, ev)
+	} else {
+		b.buffer[b.head] = ev
+		b.head = (b.head + 1) % len(b.buffer)
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			// Drop subscribers that fall behind; they reconnect and replay
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return ev
+}
+
+// Subscribe returns the buffered events after since and a channel of new
+// events. The channel is closed if the subscriber falls behind. If events
+// after since are no longer buffered, or since is from before a restart,
+// the replay starts with a reset event.
+func (b *EventBus) Subscribe(since uint64) ([]Event, <-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	first := b.nextID
+	if len(b.buffer) > 0 {
+		first = b.buffer[b.head].ID
+	}
+	from := since
+	if since > 0 && (since >= b.nextID || since+1 < first) {
+		// A cursor from before a restart says nothing, so replay it all
+		gap := EventGap{Since: since, Resume: first - 1}
+		replay = append(replay, Event{ID: gap.Resume, Type: EventReset, Time: time.Now(), Data: gap})
+		from = gap.Resume
+	}
+	for i := range b.buffer {
+		if ev := b.buffer[(b.head+i)%len(b.buffer)]; ev.ID > from {
+			replay = append(replay, ev)
+		}
+	}
+
+	ch := make(chan Event, subscriberBufferSize)
+	b.subs[ch] = struct{}{}
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return replay, ch, cancel
+}
+
+// StateTracker remembers the last state of every port that has been seen
+// open so transitions can be reported across iterations
+type StateTracker struct {
+	mu     sync.Mutex
+	states map[string]string
+}
+
+func NewStateTracker() *StateTracker {
+	return &StateTracker{states: make(map[string]string)}
+}
+
+// Observe records r and reports the previous state if it changed
+func (t *StateTracker) Observe(r ScanResult) (string, bool) {
+	key := fmt.Sprintf("%s:%d", r.IP, r.Port)
+	state := r.State()
+	if state == StateError {
+		// Says nothing about the port
+		return "", false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, known := t.states[key]
+	if !known {
+		if state == StateOpen {
+			t.states[key] = state
+		}
+		return "", false
+	}
+	t.states[key] = state
+	return prev, prev != state
+}
+
+// publishResult emits the open-port and state-change events for r
+func publishResult(jobID string, r ScanResult, tracker *StateTracker) {
+	if r.Open {
+		events.Publish(EventOpenPort, jobID, r)
+		if cveAlertScore > 0 && r.CVSS >= cveAlertScore {
+			events.Publish(EventVulnerable, jobID, r)
+		}
+	}
+	if tracker == nil {
+		return
+	}
+	if prev, changed := tracker.Observe(r); changed {
+		events.Publish(EventStateChange, jobID, StateChange{IP: r.IP, Port: r.Port, From: prev, To: r.State()})
+	}
+}
+
+// handleEvents streams events as Server-Sent Events. Clients resume with the
+// Last-Event-ID header or the since query parameter, and may restrict the
+// stream with a comma-separated types parameter.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	cursor := r.Header.Get("Last-Event-ID")
+	if q := r.URL.Query().Get("since"); q != "" {
+		cursor = q
+	}
+	var since uint64
+	if cursor != "" {
+		n, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid event cursor %q", cursor)
+			return
+		}
+		since = n
+	}
+	var types map[string]bool
+	if q := r.URL.Query().Get("types"); q != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(q, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+
+	replay, ch, cancel := events.Subscribe(since)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(ev Event) error {
+		if types != nil && !types[ev.Type] && ev.Type != EventReset {
+			return nil
+		}
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+		return err
+	}
+
+	for _, ev := range replay {
+		if err := write(ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := write(ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEventBusReplay tests the replay buffer and cursor
+func TestEventBusReplay(t *testing.T) {
+	bus := NewEventBus(3)
+	for i := 0; i < 5; i++ {
+		bus.Publish(EventOpenPort, "", i)
+	}
+
+	replay, _, cancel := bus.Subscribe(0)
+	cancel()
+	if len(replay) != 3 || replay[0].ID != 3 || replay[2].ID != 5 {
+		t.Errorf("Subscribe(0) replayed %+v", replay)
+	}
+
+	// Cursors older than the buffer, or from before a restart, start with a reset
+	for _, since := range []uint64{1, 9} {
+		replay, _, cancel := bus.Subscribe(since)
+		cancel()
+		gap, _ := replay[0].Data.(EventGap)
+		if len(replay) != 4 || replay[0].Type != EventReset || replay[0].ID != 2 || gap.Since != since || replay[1].ID != 3 {
+			t.Errorf("Subscribe(%d) replayed %+v", since, replay)
+		}
+	}
+	replay, _, cancel = bus.Subscribe(2)
+	cancel()
+	if len(replay) != 3 || replay[0].Type == EventReset {
+		t.Errorf("Subscribe(2) replayed %+v", replay)
+	}
+
+	replay, ch, cancel := bus.Subscribe(4)
+	defer cancel()
+	if len(replay) != 1 || replay[0].ID != 5 {
+		t.Errorf("Subscribe(4) replayed %+v", replay)
+	}
+	bus.Publish(EventScanComplete, "job", nil)
+	if ev := <-ch; ev.ID != 6 || ev.Type != EventScanComplete || ev.JobID != "job" {
+		t.Errorf("subscriber received %+v", ev)
+	}
+}
+
+// TestEventBusSlowSubscriber tests that lagging subscribers are dropped
+func TestEventBusSlowSubscriber(t *testing.T) {
+	bus := NewEventBus(eventBufferSize)
+	_, ch, cancel := bus.Subscribe(0)
+	defer cancel()
+	for i := 0; i < subscriberBufferSize+1; i++ {
+		bus.Publish(EventOpenPort, "", i)
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != subscriberBufferSize {
+		t.Errorf("slow subscriber received %d events before close, want %d", n, subscriberBufferSize)
+	}
+}
+
+// TestStateTracker tests state change detection across iterations
+func TestStateTracker(t *testing.T) {
+	tracker := NewStateTracker()
+	closed := ScanResult{IP: "10.0.0.1", Port: 22, Error: fmt.Errorf("connection refused")}
+	open := ScanResult{IP: "10.0.0.1", Port: 22, Open: true}
+
+	if _, changed := tracker.Observe(closed); changed {
+		t.Error("Observe() reported change for unseen closed port")
+	}
+	if _, changed := tracker.Observe(open); changed {
+		t.Error("Observe() reported change for first open sighting")
+	}
+	if _, changed := tracker.Observe(open); changed {
+		t.Error("Observe() reported change for unchanged port")
+	}
+	if prev, changed := tracker.Observe(closed); !changed || prev != StateOpen {
+		t.Errorf("Observe() = %s, %v; want open, true", prev, changed)
+	}
+}
+
+// TestEventsEndpoint tests the SSE stream with a replay cursor
+func TestEventsEndpoint(t *testing.T) {
+	original := events
+	defer func() { events = original }()
+	events = NewEventBus(eventBufferSize)
+	events.Publish(EventOpenPort, "", ScanResult{IP: "10.0.0.1", Port: 22, Open: true})
+	events.Publish(EventChunkComplete, "", ChunkComplete{Start: "10.0.0.0", End: "10.0.0.255"})
+
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+
+	events.Publish(EventScanComplete, "", ScanComplete{Probes: 2})
+
+	var got []Event
+	scanner := bufio.NewScanner(resp.Body)
+	for len(got) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+			t.Fatalf("bad event payload %q: %v", line, err)
+		}
+		got = append(got, ev)
+	}
+	if len(got) != 2 || got[0].Type != EventChunkComplete || got[1].Type != EventScanComplete {
+		t.Errorf("stream delivered %+v", got)
+	}
+
+	// A cursor from before a restart gets a reset whatever the types filter
+	req, _ = http.NewRequestWithContext(ctx, "GET", srv.URL+"/events?since=99&types=open_port", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	defer resp.Body.Close()
+	scanner = bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			if line != "id: 0" {
+				t.Errorf("stale cursor got %q, want a reset to id 0", line)
+			} else if scanner.Scan(); scanner.Text() != "event: reset" {
+				t.Errorf("stale cursor got %q, want a reset event", scanner.Text())
+			}
+			break
+		}
+	}
+}
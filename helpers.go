@@ -1,8 +1,7 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 
@@ -12,35 +11,40 @@ import (
 func init() {
 	err := godotenv.Load()
 	if err != nil {
-		log.Println("Error loading .env file")
+		slog.Debug("no .env file loaded", "error", err)
 	}
+}
 
-	brevoUrl := os.Getenv("BREVO_URL")
-	brevoAPIKey := os.Getenv("BREVO_APIKEY")
-	if brevoUrl == "" || brevoAPIKey == "" {
-		log.Fatal("BREVO_URL is not set")
+func recoverPanic() {
+	if r := recover(); r != nil {
+		slog.Error("recovered from panic", "panic", r)
 	}
+}
 
-	brevo = Brevo{URL: brevoUrl, APIKEY: brevoAPIKey}
-	email = Email{
-		SenderName:  "Port Scanner Bot",
-		SenderEmail: os.Getenv("SENDER_EMAIL"),
-		ToName:      "Admin",
-		ToEmail:     os.Getenv("TO_EMAIL"),
-		Subject:     "Port Scan Results",
-		Msg:         "",
+// appendLine appends line to the file at path, creating it if needed
+func appendLine(path, line string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
 }
 
-func recoverPanic() {
-	if r := recover(); r != nil {
-		fmt.Printf("Recovered from panic: %v\n", r)
+// writeFileAtomic replaces the file at path with data through a temporary
+// file, so a crash leaves either the old or the new contents
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
 	}
+	return os.Rename(tmp, path)
 }
 
 func update() {
 	time.Sleep(updateSleepDuration)
-	email.Subject = "Update"
-	email.Msg = "Updating..."
-	send(email)
+	notify(MsgUpdate, nil)
 }
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobCancelled = "cancelled"
+	JobFailed    = "failed"
+)
+
+// Defaults applied to job specs that leave timing fields empty
+const (
+	defaultJobTimeout    = 2 * time.Second
+	defaultJobConcurrent = 100
+	defaultJobChunk      = 65536
+)
+
+// defaultJobsKept is how many finished jobs are kept with their results
+const defaultJobsKept = 100
+
+var (
+	errJobNotFound    = errors.New("job not found")
+	errJobFinished    = errors.New("job already finished")
+	errJobNotFinished = errors.New("job has not finished")
+	errQueueFull      = errors.New("job queue is full")
+)
+
+// JobSpec describes a scan submitted through the API
+type JobSpec struct {
+	Start string `json:"start,omitempty" yaml:"start"`
+	End   string `json:"end,omitempty" yaml:"end"`
+	// Hosts are names or addresses to scan instead of Start-End
+	Hosts      []string `json:"hosts,omitempty" yaml:"hosts"`
+	Ports      []int    `json:"ports" yaml:"ports"`
+	Timeout    string   `json:"timeout,omitempty" yaml:"timeout"`
+	Concurrent int      `json:"concurrent,omitempty" yaml:"concurrent"`
+	Chunk      int      `json:"chunk,omitempty" yaml:"chunk"`
+	Random     bool     `json:"random,omitempty" yaml:"random"`
+	Seed       int64    `json:"seed,omitempty" yaml:"seed"`
+
+	timeout time.Duration
+}
+
+// validate checks the spec against the global scope and fills in default
+// timing values
+func (s *JobSpec) validate() error {
+	if err := s.validateFields(); err != nil {
+		return err
+	}
+	return s.checkScope(scope)
+}
+
+// validateFields checks the spec without consulting the scope, which may
+// not be loaded yet, and fills in default timing values
+func (s *JobSpec) validateFields() error {
+	if len(s.Hosts) > 0 {
+		if s.Start != "" || s.End != "" {
+			return errors.New("give either start and end or hosts, not both")
+		}
+		hosts, err := loadHostTargets(s.Hosts, "")
+		if err != nil {
+			return err
+		}
+		if s.Random {
+			return errors.New("random order is not supported for host targets")
+		}
+		s.Hosts = hosts
+	} else if err := s.validateRange(); err != nil {
+		return err
+	}
+	if len(s.Ports) == 0 {
+		return errors.New("no ports given")
+	}
+	for _, p := range s.Ports {
+		if p <= 0 || p > 65535 {
+			return fmt.Errorf("invalid port %d", p)
+		}
+	}
+
+	s.timeout = defaultJobTimeout
+	if s.Timeout != "" {
+		d, err := time.ParseDuration(s.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", s.Timeout)
+		}
+		s.timeout = d
+	}
+	if s.Concurrent < 0 || s.Chunk < 0 {
+		return errors.New("concurrent and chunk must not be negative")
+	}
+	if s.Concurrent == 0 {
+		s.Concurrent = defaultJobConcurrent
+	}
+	if s.Random && s.Seed == 0 {
+		s.Seed = newSeed()
+	}
+	if s.Chunk == 0 {
+		s.Chunk = defaultJobChunk
+	}
+	return nil
+}
+
+func (s *JobSpec) validateRange() error {
+	startIP := net.ParseIP(s.Start).To4()
+	endIP := net.ParseIP(s.End).To4()
+	if startIP == nil {
+		return fmt.Errorf("invalid start address %q", s.Start)
+	}
+	if endIP == nil {
+		return fmt.Errorf("invalid end address %q", s.End)
+	}
+	if ipToUint32(s.Start) > ipToUint32(s.End) {
+		return fmt.Errorf("start address %s is after end address %s", s.Start, s.End)
+	}
+	return nil
+}
+
+// checkScope refuses a validated spec whose range, or any address its hosts
+// resolve to, falls outside sc
+func (s *JobSpec) checkScope(sc *Scope) error {
+	if len(s.Hosts) > 0 {
+		if !sc.restricted() {
+			return nil
+		}
+		return sc.CheckHosts(resolveTargets(slog.Default(), s.Hosts))
+	}
+	return sc.CheckRange(ipToUint32(s.Start), ipToUint32(s.End))
+}
+
+// Job is a scan submitted through the API
+type Job struct {
+	ID      string
+	Spec    JobSpec
+	Results *ResultStore
+	Status  *ScanStatus
+
+	mu         sync.Mutex
+	state      string
+	err        string
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	cancel     context.CancelFunc
+}
+
+// JobSnapshot is the JSON view of a Job
+type JobSnapshot struct {
+	ID         string         `json:"id"`
+	State      string         `json:"state"`
+	Error      string         `json:"error,omitempty"`
+	Spec       JobSpec        `json:"spec"`
+	Progress   StatusSnapshot `json:"progress"`
+	Open       []ScanResult   `json:"open"`
+	CreatedAt  time.Time      `json:"created_at"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+func (j *Job) Snapshot() JobSnapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	snap := JobSnapshot{
+		ID:        j.ID,
+		State:     j.state,
+		Error:     j.err,
+		Spec:      j.Spec,
+		Progress:  j.Status.Snapshot(),
+		Open:      j.Results.Open(),
+		CreatedAt: j.createdAt,
+	}
+	if snap.Open == nil {
+		snap.Open = []ScanResult{}
+	}
+	if !j.startedAt.IsZero() {
+		t := j.startedAt
+		snap.StartedAt = &t
+	}
+	if !j.finishedAt.IsZero() {
+		t := j.finishedAt
+		snap.FinishedAt = &t
+	}
+	return snap
+}
+
+// finished returns when the job finished, or zero while it is queued or
+// running
+func (j *Job) finished() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finishedAt
+}
+
+func (j *Job) State() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// JobManager queues submitted jobs and runs a bounded number of them at once
+type JobManager struct {
+	mu      sync.RWMutex
+	jobs    map[string]*Job
+	queue   chan *Job
+	workers int
+	keep    int
+	running sync.WaitGroup
+}
+
+// NewJobManager runs up to workers jobs at once with up to queueSize
+// waiting, and forgets the oldest finished jobs beyond keep
+func NewJobManager(workers, queueSize, keep int) *JobManager {
+	return &JobManager{
+		jobs:    make(map[string]*Job),
+		queue:   make(chan *Job, queueSize),
+		workers: max(workers, 1),
+		keep:    max(keep, 1),
+	}
+}
+
+// Start launches the worker goroutines. They exit when ctx is done.
+func (m *JobManager) Start(ctx context.Context) {
+	for i := 0; i < m.workers; i++ {
+		m.running.Add(1)
+		go func() {
+			defer m.running.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-m.queue:
+					m.run(ctx, job)
+				}
+			}
+		}()
+	}
+}
+
+// Wait blocks until the workers have exited
+func (m *JobManager) Wait() {
+	m.running.Wait()
+}
+
+// Submit validates spec and queues a new job for it
+func (m *JobManager) Submit(spec JobSpec) (*Job, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	job := &Job{
+		ID:        newJobID(),
+		Spec:      spec,
+		Results:   NewResultStore(),
+		Status:    NewScanStatus(),
+		state:     JobQueued,
+		createdAt: time.Now(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case m.queue <- job:
+	default:
+		return nil, errQueueFull
+	}
+	m.jobs[job.ID] = job
+	return job, nil
+}
+
+func (m *JobManager) Get(id string) (*Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// List returns every known job, oldest first
+func (m *JobManager) List() []*Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].createdAt.Before(jobs[b].createdAt) })
+	return jobs
+}
+
+// Cancel stops a running job or drops a queued one
+func (m *JobManager) Cancel(id string) (*Job, error) {
+	job, ok := m.Get(id)
+	if !ok {
+		return nil, errJobNotFound
+	}
+
+	job.mu.Lock()
+	switch job.state {
+	case JobQueued:
+		job.state = JobCancelled
+		job.finishedAt = time.Now()
+		job.mu.Unlock()
+		m.prune()
+	case JobRunning:
+		job.cancel()
+		job.mu.Unlock()
+	default:
+		job.mu.Unlock()
+		return job, errJobFinished
+	}
+	return job, nil
+}
+
+// Remove forgets a finished job and its results
+func (m *JobManager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return errJobNotFound
+	}
+	if job.finished().IsZero() {
+		return errJobNotFinished
+	}
+	delete(m.jobs, id)
+	return nil
+}
+
+// prune forgets the oldest finished jobs beyond m.keep
+func (m *JobManager) prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	type finishedJob struct {
+		id string
+		at time.Time
+	}
+	var done []finishedJob
+	for id, job := range m.jobs {
+		if at := job.finished(); !at.IsZero() {
+			done = append(done, finishedJob{id, at})
+		}
+	}
+	if len(done) <= m.keep {
+		return
+	}
+	sort.Slice(done, func(a, b int) bool { return done[a].at.Before(done[b].at) })
+	for _, j := range done[:len(done)-m.keep] {
+		delete(m.jobs, j.id)
+	}
+}
+
+func (m *JobManager) run(ctx context.Context, job *Job) {
+	job.mu.Lock()
+	if job.state != JobQueued {
+		job.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	job.state = JobRunning
+	job.startedAt = time.Now()
+	job.cancel = cancel
+	job.mu.Unlock()
+
+	logger := slog.With("scan_id", job.ID)
+	logger.Info("starting job", "start", job.Spec.Start, "end", job.Spec.End, "hosts", len(job.Spec.Hosts))
+	runJob(withLogger(ctx, logger), job)
+
+	job.mu.Lock()
+	job.finishedAt = time.Now()
+	if ctx.Err() != nil {
+		job.state = JobCancelled
+	} else {
+		job.state = JobCompleted
+	}
+	state := job.state
+	job.mu.Unlock()
+	logger.Info("job finished", "state", state)
+	m.prune()
+
+	if state == JobCompleted {
+		sendScanSummary(job.ID, job.Spec.Ports, job.Status, job.Results)
+	}
+}
+
+// runJob scans the job's range chunk by chunk, or its hosts, until done or
+// cancelled
+func runJob(ctx context.Context, job *Job) {
+	spec := job.Spec
+	var targets []hostTarget
+	var start, end uint32
+	if len(spec.Hosts) > 0 {
+		targets = resolveTargets(loggerFrom(ctx), spec.Hosts)
+		spec.Start, spec.End = spec.Hosts[0], spec.Hosts[len(spec.Hosts)-1]
+		job.Status.begin(spec.Start, spec.End, countHostProbes(targets, spec.Ports))
+	} else {
+		start, end = ipToUint32(spec.Start), ipToUint32(spec.End)
+		job.Status.begin(spec.Start, spec.End, scope.Count(start, end, spec.Ports))
+	}
+	defer job.Status.finish()
+
+	resultChan := make(chan ScanResult, spec.Concurrent)
+	done := make(chan struct{})
+	sinks := jobSinks(job)
+	go func() {
+		for result := range resultChan {
+			sinks.Record(result)
+		}
+		close(done)
+	}()
+
+	if len(spec.Hosts) > 0 {
+		scanHosts(ctx, targets, spec.Ports, spec.timeout, spec.Concurrent, resultChan)
+	} else if spec.Random {
+		space := newTargetSpace(start, end, spec.Ports, spec.Seed)
+		step := uint64(spec.Chunk) * uint64(len(spec.Ports))
+		for pos := uint64(0); pos < space.Len() && ctx.Err() == nil; pos += step {
+			last := min(pos+step, space.Len()) - 1
+			scanPositions(ctx, space, pos, last, spec.timeout, spec.Concurrent, resultChan)
+			if ctx.Err() == nil {
+				events.Publish(EventChunkComplete, job.ID, ChunkComplete{Start: spec.Start, End: spec.End, FromPosition: pos, ToPosition: last})
+			}
+		}
+	} else {
+		for chunkStart := uint64(start); chunkStart <= uint64(end) && ctx.Err() == nil; chunkStart += uint64(spec.Chunk) {
+			chunkEnd := min(chunkStart+uint64(spec.Chunk)-1, uint64(end))
+			scanChunkContext(ctx, uint32(chunkStart), uint32(chunkEnd), spec.Ports, spec.timeout, spec.Concurrent, resultChan)
+			if ctx.Err() == nil {
+				events.Publish(EventChunkComplete, job.ID, ChunkComplete{Start: uint32ToIP(uint32(chunkStart)), End: uint32ToIP(uint32(chunkEnd))})
+			}
+		}
+	}
+
+	close(resultChan)
+	<-done
+	if err := exporter.Flush(); err != nil {
+		loggerFrom(ctx).Error("flushing export file", "error", err)
+	}
+
+	if ctx.Err() == nil {
+		snap := job.Status.Snapshot()
+		events.Publish(EventScanComplete, job.ID, ScanComplete{Start: spec.Start, End: spec.End, Probes: snap.Completed, Open: snap.Open, ElapsedSeconds: snap.ElapsedSeconds})
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// registerJobAPI adds the job-control endpoints to mux
+func registerJobAPI(mux *http.ServeMux, m *JobManager) {
+	mux.HandleFunc("POST /scans", m.handleSubmit)
+	mux.HandleFunc("GET /scans", m.handleList)
+	mux.HandleFunc("GET /scans/{id}", m.handleGet)
+	mux.HandleFunc("DELETE /scans/{id}", m.handleDelete)
+}
+
+func (m *JobManager) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var spec JobSpec
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid job: %v", err)
+		return
+	}
+	if r.URL.Query().Get("dry_run") == "true" {
+		m.handleDryRun(w, r, spec)
+		return
+	}
+	job, err := m.Submit(spec)
+	switch {
+	case errors.Is(err, errQueueFull):
+		writeError(w, http.StatusServiceUnavailable, "%v", err)
+		return
+	case errors.Is(err, errOutOfScope):
+		slog.Warn("rejected job", "remote_addr", r.RemoteAddr, "error", err)
+		writeError(w, http.StatusForbidden, "%v", err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "invalid job: %v", err)
+		return
+	}
+	w.Header().Set("Location", "/scans/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job.Snapshot())
+}
+
+// handleDryRun returns the plan for spec without queueing it
+func (m *JobManager) handleDryRun(w http.ResponseWriter, r *http.Request, spec JobSpec) {
+	err := spec.validate()
+	switch {
+	case errors.Is(err, errOutOfScope):
+		writeError(w, http.StatusForbidden, "%v", err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "invalid job: %v", err)
+		return
+	}
+	var plan ScanPlan
+	if len(spec.Hosts) > 0 {
+		plan = planHosts(resolveTargets(slog.Default(), spec.Hosts), spec.Ports, spec.timeout, spec.Concurrent, probeLimiter.Rate())
+	} else {
+		plan = planScan(spec.Start, spec.End, spec.Ports, spec.timeout, spec.Concurrent, spec.Chunk, probeLimiter.Rate(), false)
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+func (m *JobManager) handleList(w http.ResponseWriter, r *http.Request) {
+	snaps := []JobSnapshot{}
+	for _, job := range m.List() {
+		snaps = append(snaps, job.Snapshot())
+	}
+	writeJSON(w, http.StatusOK, snaps)
+}
+
+func (m *JobManager) handleGet(w http.ResponseWriter, r *http.Request) {
+	job, ok := m.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "%v", errJobNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job.Snapshot())
+}
+
+// handleDelete cancels a queued or running job, or forgets a finished one
+func (m *JobManager) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, err := m.Cancel(id)
+	if errors.Is(err, errJobFinished) {
+		err = m.Remove(id)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	switch {
+	case errors.Is(err, errJobNotFound):
+		writeError(w, http.StatusNotFound, "%v", err)
+	case err != nil:
+		writeError(w, http.StatusConflict, "%v", err)
+	default:
+		writeJSON(w, http.StatusAccepted, job.Snapshot())
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitForJobState(t *testing.T, job *Job, state string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for job.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s stuck in %s, want %s", job.ID, job.State(), state)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestJobSpecValidate tests job validation and defaults
+func TestJobSpecValidate(t *testing.T) {
+	spec := JobSpec{Start: "10.0.0.1", End: "10.0.0.2", Ports: []int{80}}
+	if err := spec.validate(); err != nil {
+		t.Fatalf("validate() failed: %v", err)
+	}
+	if spec.timeout != defaultJobTimeout || spec.Concurrent != defaultJobConcurrent || spec.Chunk != defaultJobChunk {
+		t.Errorf("validate() did not apply defaults: %+v", spec)
+	}
+
+	random := JobSpec{Start: "10.0.0.1", End: "10.0.0.2", Ports: []int{80}, Random: true}
+	if err := random.validate(); err != nil || random.Seed == 0 {
+		t.Errorf("validate() did not pick a seed for a random job: %+v, %v", random, err)
+	}
+
+	bad := []JobSpec{
+		{Start: "bogus", End: "10.0.0.2", Ports: []int{80}},
+		{Start: "10.0.0.3", End: "10.0.0.2", Ports: []int{80}},
+		{Start: "10.0.0.1", End: "10.0.0.2"},
+		{Start: "10.0.0.1", End: "10.0.0.2", Ports: []int{70000}},
+		{Start: "10.0.0.1", End: "10.0.0.2", Ports: []int{80}, Timeout: "soon"},
+	}
+	for _, b := range bad {
+		if err := b.validate(); err == nil {
+			t.Errorf("validate() accepted %+v", b)
+		}
+	}
+}
+
+// TestJobLifecycle tests submitting, running and cancelling jobs over HTTP
+func TestJobLifecycle(t *testing.T) {
+	originalDial := dialTimeout
+	originalImpl := sendImpl
+	defer func() {
+		dialTimeout = originalDial
+		sendImpl = originalImpl
+	}()
+	sendImpl = func(e Email) (int, time.Duration) { return 200, 0 }
+	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		if strings.HasSuffix(address, ":80") {
+			return &net.TCPConn{}, nil
+		}
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewJobManager(1, 10, defaultJobsKept)
+	m.Start(ctx)
+	mux := http.NewServeMux()
+	registerJobAPI(mux, m)
+
+	body := `{"start":"10.0.0.1","end":"10.0.0.3","ports":[22,80],"timeout":"1ms"}`
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/scans", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /scans returned %d: %s", rec.Code, rec.Body)
+	}
+	var snap JobSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&snap); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	job, ok := m.Get(snap.ID)
+	if !ok {
+		t.Fatalf("job %s not registered", snap.ID)
+	}
+	waitForJobState(t, job, JobCompleted)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/scans/"+job.ID, nil))
+	if err := json.NewDecoder(rec.Body).Decode(&snap); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if snap.Progress.Completed != 6 || len(snap.Open) != 3 {
+		t.Errorf("GET /scans/%s returned %+v", job.ID, snap)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("DELETE", "/scans/"+job.ID, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE on finished job returned %d, want 204", rec.Code)
+	}
+	if _, ok := m.Get(job.ID); ok {
+		t.Errorf("DELETE did not remove finished job %s", job.ID)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/scans/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /scans/missing returned %d, want 404", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/scans", bytes.NewBufferString(`{"start":"x"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /scans with bad spec returned %d, want 400", rec.Code)
+	}
+}
+
+// TestJobCancel tests cancelling running and queued jobs
+func TestJobCancel(t *testing.T) {
+	originalDial := dialTimeout
+	originalImpl := sendImpl
+	defer func() {
+		dialTimeout = originalDial
+		sendImpl = originalImpl
+	}()
+	sendImpl = func(e Email) (int, time.Duration) { return 200, 0 }
+	release := make(chan struct{})
+	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		<-release
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewJobManager(1, 10, defaultJobsKept)
+	m.Start(ctx)
+
+	spec := JobSpec{Start: "10.0.0.0", End: "10.0.255.255", Ports: []int{80}, Concurrent: 2}
+	running, err := m.Submit(spec)
+	if err != nil {
+		t.Fatalf("Submit() failed: %v", err)
+	}
+	queued, err := m.Submit(spec)
+	if err != nil {
+		t.Fatalf("Submit() failed: %v", err)
+	}
+	waitForJobState(t, running, JobRunning)
+
+	if _, err := m.Cancel(queued.ID); err != nil {
+		t.Fatalf("Cancel() queued failed: %v", err)
+	}
+	if queued.State() != JobCancelled {
+		t.Errorf("queued job state = %s, want cancelled", queued.State())
+	}
+	if _, err := m.Cancel(running.ID); err != nil {
+		t.Fatalf("Cancel() running failed: %v", err)
+	}
+	close(release)
+	waitForJobState(t, running, JobCancelled)
+	if n := running.Results.Len(); n > 4 {
+		t.Errorf("cancelled job kept scanning: %d results", n)
+	}
+}
+
+// TestJobRetention tests forgetting the oldest finished jobs and removing
+// finished jobs on request
+func TestJobRetention(t *testing.T) {
+	m := NewJobManager(1, 10, 2)
+	spec := JobSpec{Start: "10.0.0.1", End: "10.0.0.1", Ports: []int{80}}
+	var jobs []*Job
+	for i := 0; i < 4; i++ {
+		job, err := m.Submit(spec)
+		if err != nil {
+			t.Fatalf("Submit() failed: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := m.Remove(jobs[0].ID); !errors.Is(err, errJobNotFinished) {
+		t.Errorf("Remove() of a queued job = %v", err)
+	}
+	// Nothing runs them, so cancelling finishes them in order
+	for _, job := range jobs[:3] {
+		if _, err := m.Cancel(job.ID); err != nil {
+			t.Fatalf("Cancel() failed: %v", err)
+		}
+	}
+	if _, ok := m.Get(jobs[0].ID); ok {
+		t.Error("oldest finished job was kept")
+	}
+	if got := len(m.List()); got != 3 {
+		t.Errorf("List() has %d jobs, want 2 finished and 1 queued", got)
+	}
+
+	mux := http.NewServeMux()
+	registerJobAPI(mux, m)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("DELETE", "/scans/"+jobs[1].ID, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE on finished job returned %d, want 204", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("DELETE", "/scans/"+jobs[1].ID, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE on removed job returned %d, want 404", rec.Code)
+	}
+	if got := len(m.List()); got != 2 {
+		t.Errorf("List() has %d jobs after DELETE, want 2", got)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// RTTStats summarizes the connect latencies of a host
+type RTTStats struct {
+	Samples int
+	Min     time.Duration
+	Avg     time.Duration
+	P95     time.Duration
+}
+
+// newRTTStats summarizes samples, which it sorts
+func newRTTStats(samples []time.Duration) RTTStats {
+	if len(samples) == 0 {
+		return RTTStats{}
+	}
+	sort.Slice(samples, func(a, b int) bool { return samples[a] < samples[b] })
+	var sum time.Duration
+	for _, d := range samples {
+		sum += d
+	}
+	// Nearest rank
+	rank := (len(samples)*95 + 99) / 100
+	return RTTStats{
+		Samples: len(samples),
+		Min:     samples[0],
+		Avg:     sum / time.Duration(len(samples)),
+		P95:     samples[rank-1],
+	}
+}
+
+// String renders s for people, e.g. "min 1.2ms, avg 1.5ms, p95 3.1ms"
+func (s RTTStats) String() string {
+	if s.Samples == 0 {
+		return ""
+	}
+	return fmt.Sprintf("min %s, avg %s, p95 %s", roundLatency(s.Min), roundLatency(s.Avg), roundLatency(s.P95))
+}
+
+// MarshalJSON renders the latencies in milliseconds
+func (s RTTStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Samples int     `json:"samples"`
+		Min     float64 `json:"min_ms"`
+		Avg     float64 `json:"avg_ms"`
+		P95     float64 `json:"p95_ms"`
+	}{s.Samples, milliseconds(s.Min), milliseconds(s.Avg), milliseconds(s.P95)})
+}
+
+// UnmarshalJSON restores stats rendered by MarshalJSON
+func (s *RTTStats) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Samples int     `json:"samples"`
+		Min     float64 `json:"min_ms"`
+		Avg     float64 `json:"avg_ms"`
+		P95     float64 `json:"p95_ms"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = RTTStats{Samples: v.Samples, Min: fromMilliseconds(v.Min), Avg: fromMilliseconds(v.Avg), P95: fromMilliseconds(v.P95)}
+	return nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func fromMilliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// roundLatency keeps three significant digits or so
+func roundLatency(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+// HostStats counts a host's port states and summarizes its latency
+type HostStats struct {
+	IP       string   `json:"ip"`
+	Hostname string   `json:"hostname,omitempty"`
+	Open     int      `json:"open"`
+	Closed   int      `json:"closed"`
+	Filtered int      `json:"filtered"`
+	Errors   int      `json:"errors,omitempty"`
+	RTT      RTTStats `json:"rtt"`
+}
+
+// maxRTTSamples bounds the latencies kept per host. Beyond it a random
+// sample is kept, so the percentile becomes an estimate.
+const maxRTTSamples = 1000
+
+// hostAggregate accumulates the stats of a host as results arrive
+type hostAggregate struct {
+	stats   HostStats
+	samples []time.Duration
+	timed   int
+}
+
+func (h *hostAggregate) add(r ScanResult, state string) {
+	if r.Hostname != "" {
+		h.stats.Hostname = r.Hostname
+	}
+	switch state {
+	case StateOpen:
+		h.stats.Open++
+	case StateFiltered:
+		h.stats.Filtered++
+	case StateError:
+		h.stats.Errors++
+	default:
+		h.stats.Closed++
+	}
+	if r.Latency <= 0 {
+		return
+	}
+	// Reservoir sampling
+	h.timed++
+	if len(h.samples) < maxRTTSamples {
+		h.samples = append(h.samples, r.Latency)
+	} else if i := rand.IntN(h.timed); i < maxRTTSamples {
+		h.samples[i] = r.Latency
+	}
+}
+
+func (h *hostAggregate) summary() HostStats {
+	stats := h.stats
+	stats.RTT = newRTTStats(append([]time.Duration(nil), h.samples...))
+	stats.RTT.Samples = h.timed
+	return stats
+}
+
+// handleHostResults serves the per-host summary of the scan loop's results,
+// optionally only hosts with open ports
+func handleHostResults(w http.ResponseWriter, r *http.Request) {
+	hosts := results.Hosts()
+	if r.URL.Query().Get("open") == "true" {
+		var open []HostStats
+		for _, h := range hosts {
+			if h.Open > 0 {
+				open = append(open, h)
+			}
+		}
+		hosts = open
+	}
+	if hosts == nil {
+		hosts = []HostStats{}
+	}
+	if results.HostsTruncated() {
+		w.Header().Set("X-Hosts-Truncated", "true")
+	}
+	writeJSON(w, http.StatusOK, hosts)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRTTStats tests the latency summary
+func TestRTTStats(t *testing.T) {
+	var samples []time.Duration
+	for i := 20; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	s := newRTTStats(samples)
+	if s.Samples != 20 || s.Min != time.Millisecond || s.Avg != 10500*time.Microsecond || s.P95 != 19*time.Millisecond {
+		t.Errorf("newRTTStats() = %+v", s)
+	}
+	if got := s.String(); got != "min 1ms, avg 10.5ms, p95 19ms" {
+		t.Errorf("String() = %q", got)
+	}
+	if one := newRTTStats([]time.Duration{3 * time.Millisecond}); one.P95 != 3*time.Millisecond {
+		t.Errorf("newRTTStats() of one sample = %+v", one)
+	}
+	if none := newRTTStats(nil); none != (RTTStats{}) || none.String() != "" {
+		t.Errorf("newRTTStats(nil) = %+v", none)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil || string(data) != `{"samples":20,"min_ms":1,"avg_ms":10.5,"p95_ms":19}` {
+		t.Errorf("json.Marshal() = %s, %v", data, err)
+	}
+	var back RTTStats
+	if err := json.Unmarshal(data, &back); err != nil || back != s {
+		t.Errorf("json.Unmarshal() = %+v, %v", back, err)
+	}
+}
+
+// TestHostStats tests counting states and timing by host
+func TestHostStats(t *testing.T) {
+	rs := NewResultStore()
+	for _, r := range []ScanResult{
+		{IP: "10.0.0.10", Port: 22, Open: true, Latency: 2 * time.Millisecond},
+		// Probes that fail before a host first answers are still counted
+		{IP: "10.0.0.2", Port: 21, Error: timeoutError{}},
+		{IP: "10.0.0.2", Port: 22, Open: true, Latency: time.Millisecond, Hostname: "db.example.test"},
+		{IP: "10.0.0.2", Port: 23, Error: syscall.ECONNREFUSED, Latency: 3 * time.Millisecond},
+		{IP: "10.0.0.2", Port: 24, Error: timeoutError{}},
+		// Hosts that never answer are not listed
+		{IP: "10.0.0.3", Port: 22, Error: timeoutError{}},
+	} {
+		rs.Add(r)
+	}
+	hosts := rs.Hosts()
+	if len(hosts) != 2 || hosts[0].IP != "10.0.0.2" || hosts[1].IP != "10.0.0.10" {
+		t.Fatalf("Hosts() = %+v", hosts)
+	}
+	h := hosts[0]
+	if h.Hostname != "db.example.test" || h.Open != 1 || h.Closed != 1 || h.Filtered != 2 {
+		t.Errorf("hosts[0] = %+v", h)
+	}
+	if h.RTT.Samples != 2 || h.RTT.Min != time.Millisecond || h.RTT.Avg != 2*time.Millisecond || h.RTT.P95 != 3*time.Millisecond {
+		t.Errorf("hosts[0].RTT = %+v", h.RTT)
+	}
+}
+
+// TestHostStatsLimit tests that the hosts tracked are bounded, except those
+// with open ports
+func TestHostStatsLimit(t *testing.T) {
+	originalOutputs := outputs
+	defer func() { outputs = originalOutputs }()
+	outputs = OutputConfig{DiscardClosed: true}
+	rs := NewResultStore()
+	for i := range maxTrackedHosts {
+		ip := uint32ToIP(ipToUint32("10.0.0.0") + uint32(i))
+		rs.Add(ScanResult{IP: ip, Port: 22, Error: timeoutError{}})
+		rs.Add(ScanResult{IP: ip, Port: 23, Error: syscall.ECONNREFUSED})
+	}
+	if rs.HostsTruncated() {
+		t.Fatal("HostsTruncated() before the limit was passed")
+	}
+	for i := range 2 * maxTrackedHosts {
+		rs.Add(ScanResult{IP: uint32ToIP(ipToUint32("10.1.0.0") + uint32(i)), Port: 22, Error: timeoutError{}})
+	}
+	rs.Add(ScanResult{IP: "10.2.0.0", Port: 22, Error: syscall.ECONNREFUSED})
+	late := "10.2.0.1"
+	rs.Add(ScanResult{IP: late, Port: 80, Open: true})
+	if !rs.HostsTruncated() {
+		t.Error("HostsTruncated() = false past the limit")
+	}
+	if n := len(rs.silent); n > maxTrackedHosts {
+		t.Errorf("tracking %d silent hosts", n)
+	}
+	hosts := rs.Hosts()
+	if len(hosts) != maxTrackedHosts+1 || hosts[len(hosts)-1].IP != late || hosts[len(hosts)-1].Open != 1 {
+		t.Errorf("Hosts() has %d hosts, want the limit plus the open host", len(hosts))
+	}
+	if states := rs.States(); states[StateFiltered] != 3*maxTrackedHosts || states[StateClosed] != maxTrackedHosts+1 {
+		t.Errorf("States() = %v", states)
+	}
+
+	rs.Reset()
+	if rs.HostsTruncated() {
+		t.Error("HostsTruncated() after Reset()")
+	}
+}
+
+// TestRTTSampling tests that per-host latencies stay bounded
+func TestRTTSampling(t *testing.T) {
+	var h hostAggregate
+	for i := 1; i <= 3*maxRTTSamples; i++ {
+		h.add(ScanResult{IP: "10.0.0.1", Port: i, Open: true, Latency: time.Duration(i) * time.Microsecond}, StateOpen)
+	}
+	s := h.summary()
+	if len(h.samples) != maxRTTSamples || s.RTT.Samples != 3*maxRTTSamples || s.Open != 3*maxRTTSamples {
+		t.Fatalf("kept %d samples, summary %+v", len(h.samples), s)
+	}
+	if s.RTT.Min < time.Microsecond || s.RTT.P95 > 3*maxRTTSamples*time.Microsecond || s.RTT.Avg < s.RTT.Min || s.RTT.Avg > s.RTT.P95 {
+		t.Errorf("RTT = %+v", s.RTT)
+	}
+}
+
+// TestScanLatency tests timing open and refused probes
+func TestScanLatency(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	limiter := make(chan struct{}, 1)
+
+	before := time.Now()
+	open := scanPort("127.0.0.1", port, time.Second, limiter)
+	if !open.Open || open.Latency <= 0 || open.Latency > time.Second || open.Started.Before(before) || open.Started.After(time.Now()) {
+		t.Errorf("open probe = %+v", open)
+	}
+
+	ln.Close()
+	closed := scanPort("127.0.0.1", port, time.Second, limiter)
+	if !errors.Is(closed.Error, syscall.ECONNREFUSED) {
+		t.Skipf("closed port not refused: %v", closed.Error)
+	}
+	if closed.Latency <= 0 || closed.Started.IsZero() {
+		t.Errorf("refused probe = %+v", closed)
+	}
+}
+
+// TestResultTimingJSON tests that timing survives the trip from a worker
+func TestResultTimingJSON(t *testing.T) {
+	started := time.Date(2026, 3, 2, 12, 0, 0, 123000000, time.UTC)
+	r := ScanResult{IP: "10.0.0.1", Port: 22, Open: true, Started: started, Latency: 1500 * time.Microsecond}
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"started":"2026-03-02T12:00:00.123Z"`) || !strings.Contains(string(data), `"latency_ms":1.5`) {
+		t.Errorf("json.Marshal() = %s", data)
+	}
+	var back ScanResult
+	if err := json.Unmarshal(data, &back); err != nil || !back.Started.Equal(started) || back.Latency != r.Latency {
+		t.Errorf("json.Unmarshal() = %+v, %v", back, err)
+	}
+
+	// Results without timing leave it out
+	data, _ = json.Marshal(ScanResult{IP: "10.0.0.1", Port: 22})
+	if strings.Contains(string(data), "started") || strings.Contains(string(data), "latency_ms") {
+		t.Errorf("json.Marshal() without timing = %s", data)
+	}
+}
+
+// TestHostResultsEndpoint tests GET /results/hosts
+func TestHostResultsEndpoint(t *testing.T) {
+	defer results.Reset()
+	results.Reset()
+	results.Add(ScanResult{IP: "10.0.0.1", Port: 22, Open: true, Latency: 2 * time.Millisecond})
+	results.Add(ScanResult{IP: "10.0.0.2", Port: 22, Error: syscall.ECONNREFUSED, Latency: time.Millisecond})
+
+	for target, want := range map[string]int{"/results/hosts": 2, "/results/hosts?open=true": 1} {
+		rec := httptest.NewRecorder()
+		handleHostResults(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var hosts []HostStats
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &hosts) != nil || len(hosts) != want {
+			t.Errorf("GET %s = %d %s", target, rec.Code, rec.Body)
+			continue
+		}
+		if hosts[0].IP != "10.0.0.1" || hosts[0].RTT.Avg != 2*time.Millisecond {
+			t.Errorf("GET %s: hosts[0] = %+v", target, hosts[0])
+		}
+	}
+}
+
+// TestSummaryLatency tests the connect times in the summary email
+func TestSummaryLatency(t *testing.T) {
+	data := summaryData{
+		Results: []ScanResult{{IP: "10.0.0.1", Port: 22, Open: true}, {IP: "10.0.0.1", Port: 80, Open: true}},
+		Hosts: map[string]HostStats{
+			"10.0.0.1": {IP: "10.0.0.1", RTT: newRTTStats([]time.Duration{time.Millisecond, 3 * time.Millisecond})},
+			"10.0.0.2": {IP: "10.0.0.2", RTT: newRTTStats([]time.Duration{time.Millisecond})},
+		},
+	}
+	if hosts := data.Latency(); len(hosts) != 1 || hosts[0].IP != "10.0.0.1" {
+		t.Fatalf("Latency() = %+v", hosts)
+	}
+	msg, err := defaultTemplates.render(MsgSummary, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg.Text, "10.0.0.1: min 1ms, avg 2ms, p95 3ms") || !strings.Contains(msg.HTML, "<td>2ms</td>") {
+		t.Errorf("summary is missing connect times:\n%s\n%s", msg.Text, msg.HTML)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log formats
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// LogConfig selects the log level and output format
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("unknown log level %q, want debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// newLogHandler builds the handler for cfg writing to w
+func newLogHandler(cfg LogConfig, w io.Writer) (slog.Handler, error) {
+	level, err := parseLogLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(cfg.Format) {
+	case "", LogText:
+		return slog.NewTextHandler(w, opts), nil
+	case LogJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, want %s or %s", cfg.Format, LogText, LogJSON)
+}
+
+// setupLogging installs the default logger for cfg
+func setupLogging(cfg LogConfig, w io.Writer) error {
+	h, err := newLogHandler(cfg, w)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs msg at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type loggerKey struct{}
+
+// withLogger returns ctx carrying logger, so code below a scan logs with the
+// scan's fields
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger carried by ctx, or the default logger
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// logProbe records the outcome of a single probe. Anything but an open port
+// is only interesting when debugging, so nothing is built for the log on
+// the hot path otherwise.
+func logProbe(logger *slog.Logger, r ScanResult) {
+	if r.Error == nil || !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	logger.Debug("probe failed", "ip", r.IP, "port", r.Port, "state", r.State(), "error", r.Error)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"port-scanner/simnet"
+)
+
+// TestNewLogHandler tests log formats and levels
+func TestNewLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := newLogHandler(LogConfig{Level: "warn", Format: "json"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(h)
+	logger.Info("hidden")
+	logger.Warn("shown", "ip", "10.0.0.1", "port", 22)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("not a single JSON entry: %q", buf.String())
+	}
+	if entry["msg"] != "shown" || entry["level"] != "WARN" || entry["ip"] != "10.0.0.1" || entry["port"] != float64(22) {
+		t.Errorf("entry = %v", entry)
+	}
+
+	buf.Reset()
+	h, _ = newLogHandler(LogConfig{Level: "DEBUG"}, &buf)
+	slog.New(h).Debug("probe failed", "state", "closed")
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), "state=closed") {
+		t.Errorf("text entry = %q", buf.String())
+	}
+
+	for _, bad := range []LogConfig{{Level: "loud"}, {Level: "info", Format: "xml"}} {
+		if _, err := newLogHandler(bad, &buf); err == nil {
+			t.Errorf("newLogHandler() accepted %+v", bad)
+		}
+	}
+}
+
+// TestProbeLogging tests that failed probes are logged at debug level with
+// the scan's fields
+func TestProbeLogging(t *testing.T) {
+	useSimnet(t, simnet.Topology{Hosts: []simnet.Host{
+		{Addr: "10.30.0.1", Ports: []simnet.Port{{Port: 22}, {Port: 443, State: simnet.Filtered}}},
+	}})
+	var buf bytes.Buffer
+	h, _ := newLogHandler(LogConfig{Level: "debug", Format: "json"}, &buf)
+	ctx := withLogger(context.Background(), slog.New(h).With("scan_id", "test-scan"))
+
+	resultChan := make(chan ScanResult, 3)
+	scanChunkContext(ctx, ipToUint32("10.30.0.1"), ipToUint32("10.30.0.1"), []int{22, 80, 443}, 10*time.Millisecond, 3, resultChan)
+
+	states := make(map[float64]string)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("bad log line %q", line)
+		}
+		if entry["scan_id"] != "test-scan" || entry["chunk"] != "10.30.0.1-10.30.0.1" {
+			t.Errorf("entry missing scan fields: %v", entry)
+		}
+		if entry["msg"] == "probe failed" {
+			if entry["level"] != "DEBUG" || entry["ip"] != "10.30.0.1" || entry["error"] == nil {
+				t.Errorf("probe entry = %v", entry)
+			}
+			states[entry["port"].(float64)] = entry["state"].(string)
+		}
+	}
+	if len(states) != 2 || states[80] != StateClosed || states[443] != StateFiltered {
+		t.Errorf("logged probe states = %v", states)
+	}
+}
+
+// TestProbeLoggingDisabled tests that failed probes cost nothing to log
+// above debug level
+func TestProbeLoggingDisabled(t *testing.T) {
+	h, _ := newLogHandler(LogConfig{Level: "info", Format: "json"}, io.Discard)
+	logger := slog.New(h)
+	r := ScanResult{IP: "10.0.0.1", Port: 80, Error: timeoutError{}}
+	if allocs := testing.AllocsPerRun(100, func() { logProbe(logger, r) }); allocs != 0 {
+		t.Errorf("logProbe() at info level made %.0f allocations", allocs)
+	}
+}
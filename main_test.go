@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -34,12 +37,11 @@ func TestMain(m *testing.M) {
 	email = Email{
 		SenderName:  "Port Scanner Bot",
 		SenderEmail: os.Getenv("SENDER_EMAIL"),
-		ToName:      "Admin",
-		ToEmail:     os.Getenv("TO_EMAIL"),
+		To:          []Contact{{Name: "Admin", Email: os.Getenv("TO_EMAIL")}},
 		Subject:     "Port Scan Results",
 		Msg:         "",
 	}
-	results = nil
+	results.Reset()
 	checkpoints = nil
 
 	os.Exit(m.Run())
@@ -74,13 +76,12 @@ func TestInit(t *testing.T) {
 	email = Email{
 		SenderName:  "Port Scanner Bot",
 		SenderEmail: os.Getenv("SENDER_EMAIL"),
-		ToName:      "Admin",
-		ToEmail:     os.Getenv("TO_EMAIL"),
+		To:          []Contact{{Name: "Admin", Email: os.Getenv("TO_EMAIL")}},
 		Subject:     "Port Scan Results",
 		Msg:         "",
 	}
 
-	if brevo.URL == "" || brevo.APIKEY == "" || email.SenderEmail == "" || email.ToEmail == "" {
+	if brevo.URL == "" || brevo.APIKEY == "" || email.SenderEmail == "" || len(email.To) == 0 || email.To[0].Email == "" {
 		t.Errorf("Initialization failed: %+v, %+v", brevo, email)
 	}
 }
@@ -119,8 +120,8 @@ func TestUpdate(t *testing.T) {
 			t.Errorf("update() sent wrong email: %+v", e)
 		}
 	}
-	sendImpl = func(e Email) int {
-		return 200
+	sendImpl = func(e Email) (int, time.Duration) {
+		return 200, 0
 	}
 
 	updateSleepDuration = 10 * time.Millisecond
@@ -173,8 +174,8 @@ func TestScanChunk(t *testing.T) {
 	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
 		return &net.TCPConn{}, nil
 	}
-	sendImpl = func(e Email) int {
-		return 200
+	sendImpl = func(e Email) (int, time.Duration) {
+		return 200, 0
 	}
 
 	resultChan := make(chan ScanResult, 10)
@@ -215,28 +216,28 @@ func TestScanRange(t *testing.T) {
 		dialTimeout = originalDial
 		sendImpl = originalImpl
 	}()
-	sendCalled := 0
+	var sendCalled atomic.Int32
 	sendFunc = func(e Email) {
-		sendCalled++
+		sendCalled.Add(1)
 	}
-	sendImpl = func(e Email) int {
-		return 200
+	sendImpl = func(e Email) (int, time.Duration) {
+		return 200, 0
 	}
 	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
 		return &net.TCPConn{}, nil
 	}
-	results = nil
+	results.Reset()
 	checkpoints = nil
 	err := scanRange("192.168.1.1", "192.168.1.2", []int{80}, 1*time.Millisecond, 2, 2, false)
 	if err != nil {
 		t.Errorf("scanRange() failed: %v", err)
 	}
 	time.Sleep(50 * time.Millisecond)
-	if sendCalled < 1 {
+	if sendCalled := sendCalled.Load(); sendCalled < 1 {
 		t.Errorf("scanRange() did not send emails, sent: %d", sendCalled)
 	}
-	if len(results) != 2 {
-		t.Errorf("scanRange() produced wrong number of results: %d", len(results))
+	if results.Len() != 2 {
+		t.Errorf("scanRange() produced wrong number of results: %d", results.Len())
 	}
 }
 
@@ -250,26 +251,26 @@ func TestScanRangeWithCheckpoint(t *testing.T) {
 		dialTimeout = originalDial
 		sendImpl = originalImpl
 	}()
-	sendCalled := 0
+	var sendCalled atomic.Int32
 	sendFunc = func(e Email) {
-		sendCalled++
+		sendCalled.Add(1)
 	}
-	sendImpl = func(e Email) int {
-		return 200
+	sendImpl = func(e Email) (int, time.Duration) {
+		return 200, 0
 	}
 	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
 		return &net.TCPConn{}, nil
 	}
 
 	checkpoints = []Checkpoint{{IP: "192.168.1.1"}}
-	results = nil
+	results.Reset()
 	err := scanRange("192.168.1.1", "192.168.1.3", []int{80}, 1*time.Millisecond, 2, 2, false)
 	if err != nil {
 		t.Errorf("scanRange() failed: %v", err)
 	}
 	time.Sleep(50 * time.Millisecond)
-	if len(results) != 2 {
-		t.Errorf("scanRange() with checkpoint produced wrong number of results: %d", len(results))
+	if results.Len() != 2 {
+		t.Errorf("scanRange() with checkpoint produced wrong number of results: %d", results.Len())
 	}
 }
 
@@ -319,38 +320,47 @@ func TestMainFunction(t *testing.T) {
 	}()
 
 	// Mock dialTimeout: succeed first iteration, fail second
-	iteration := 0
+	var iteration atomic.Int32
 	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
-		iteration++
-		if iteration <= 2 { // First iteration succeeds (2 IPs scanned)
+		n := iteration.Add(1)
+		if n <= 2 { // First iteration succeeds (2 IPs scanned)
 			return &net.TCPConn{}, nil
 		}
 		// After first iteration succeeds, set TEST_MODE to exit after second
-		if iteration == 3 {
+		if n == 3 {
 			os.Setenv("TEST_MODE", "true")
 		}
 		return nil, fmt.Errorf("mock dial error") // Second iteration fails
 	}
 
-	sendCalled := 0
+	var sendCalled atomic.Int32
 	sendFunc = func(e Email) {
-		sendCalled++
+		sendCalled.Add(1)
 		fmt.Printf("Email sent: %s\n", e.Msg) // Debug email sending
 	}
-	sendImpl = func(e Email) int {
-		return 200
+	sendImpl = func(e Email) (int, time.Duration) {
+		return 200, 0
 	}
 
 	// Reset global state
-	results = nil
+	results.Reset()
 	checkpoints = nil
 
+	scopeFile := filepath.Join(t.TempDir(), "scope.txt")
+	if err := os.WriteFile(scopeFile, []byte("192.168.1.0/24\n"), 0600); err != nil {
+		t.Fatalf("Failed to write scope file: %v", err)
+	}
+	originalScope := scope
+	defer func() { scope = originalScope }()
+	defer func() { outbox, alertRules = nil, nil }()
+	queueFile := filepath.Join(t.TempDir(), "queue.json")
+
 	done := make(chan struct{})
 	os.Setenv("TEST_MODE", "false") // Start with false to allow two iterations
 	defer os.Unsetenv("TEST_MODE")
 	go func() {
 		defer recoverPanic()
-		os.Args = []string{"port-scanner", "-start=192.168.1.1", "-end=192.168.1.2", "-ports=80", "-timeout=1ms", "-concurrent=2"}
+		os.Args = []string{"port-scanner", "-start=192.168.1.1", "-end=192.168.1.2", "-ports=80", "-timeout=1ms", "-concurrent=2", "-scope=" + scopeFile, "-notify-queue=" + queueFile, "-dead-letter=", "-alerts-file=", "-report-dir=" + t.TempDir()}
 		main()
 		close(done)
 	}()
@@ -369,7 +379,7 @@ func TestMainFunction(t *testing.T) {
 	<-serverDone // Ensure server goroutine completes
 
 	// Verify results
-	if sendCalled != 6 { // 1 start + 2 per-port + 1 summary (success), 1 start + 1 empty summary (error)
+	if sendCalled := sendCalled.Load(); sendCalled != 6 { // 1 start + 2 per-port + 1 summary (success), 1 start + 1 empty summary (error)
 		t.Errorf("main() sent %d emails, expected 6 (1 start + 2 ports + 1 summary success + 1 start + 1 error)", sendCalled)
 	}
 
@@ -380,6 +390,40 @@ func TestMainFunction(t *testing.T) {
 	}
 }
 
+// TestMainDryRun tests that a dry run leaves queued notifications alone
+func TestMainDryRun(t *testing.T) {
+	originalImpl, originalArgs, originalFlags := sendImpl, os.Args, flag.CommandLine
+	originalScope := scope
+	defer func() {
+		sendImpl, os.Args, flag.CommandLine = originalImpl, originalArgs, originalFlags
+		scope, outbox, alertRules = originalScope, nil, nil
+	}()
+	var sent atomic.Int32
+	sendImpl = func(e Email) (int, time.Duration) {
+		sent.Add(1)
+		return 200, 0
+	}
+
+	queueFile := filepath.Join(t.TempDir(), "queue.jsonl")
+	queued, err := NewOutbox(QueueConfig{File: queueFile, MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued.Enqueue(Email{Subject: "pending"})
+	before, _ := os.ReadFile(queueFile)
+
+	flag.CommandLine = flag.NewFlagSet("port-scanner", flag.ContinueOnError)
+	os.Args = []string{"port-scanner", "-start=10.0.0.1", "-end=10.0.0.2", "-ports=80", "-override-scope", "-notify-queue=" + queueFile, "-dead-letter=", "-alerts-file=", "-dry-run"}
+	main()
+
+	if n := sent.Load(); n != 0 {
+		t.Errorf("dry run sent %d queued emails", n)
+	}
+	if after, _ := os.ReadFile(queueFile); string(after) != string(before) {
+		t.Errorf("dry run changed the queue file:\n%s", after)
+	}
+}
+
 // TestEmailErrors tests error paths in email sending
 func TestEmailErrors(t *testing.T) {
 	originalSend := sendFunc
@@ -390,16 +434,16 @@ func TestEmailErrors(t *testing.T) {
 	}()
 
 	sendFunc = func(e Email) {}
-	sendImpl = func(e Email) int {
-		return 500
+	sendImpl = func(e Email) (int, time.Duration) {
+		return 500, 0
 	}
 	status := send(email)
 	if status != 500 {
 		t.Errorf("send() expected 500 on error, got %d", status)
 	}
 
-	sendImpl = func(e Email) int {
-		return 400
+	sendImpl = func(e Email) (int, time.Duration) {
+		return 400, 0
 	}
 	status = send(email)
 	if status != 400 {
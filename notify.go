@@ -0,0 +1,233 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Notification kinds, each rendered from <kind>.txt and <kind>.html
+const (
+	MsgUpdate      = "update"
+	MsgScanStarted = "scan_started"
+	MsgOpenPort    = "open_port"
+	MsgSummary     = "open_port_summary"
+	MsgVulnerable  = "vulnerable_service"
+	MsgViolations  = "policy_violations"
+	MsgCompliance  = "compliance_report"
+)
+
+var messageKinds = []string{MsgUpdate, MsgScanStarted, MsgOpenPort, MsgSummary, MsgVulnerable, MsgViolations, MsgCompliance}
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// Message is a rendered notification
+type Message struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// messageTemplates renders notifications. The text template of each kind
+// also defines its "subject"; HTML templates fill the "content" block of
+// layout.html.
+type messageTemplates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+// Template data for the kinds that don't take a ScanResult
+
+type scanStartedData struct {
+	ScanID string
+	Start  string
+	End    string
+	Hosts  []string
+	Ports  []int
+}
+
+type summaryData struct {
+	ScanID    string
+	JobID     string
+	Results   []ScanResult
+	ReportURL string
+	// Hosts holds the stats of every scanned host, by address
+	Hosts map[string]HostStats
+}
+
+// Latency returns the stats of the hosts in d.Results that were timed
+func (d summaryData) Latency() []HostStats {
+	var hosts []HostStats
+	seen := make(map[string]bool)
+	for _, r := range d.Results {
+		if h, ok := d.Hosts[r.IP]; ok && !seen[r.IP] && h.RTT.Samples > 0 {
+			seen[r.IP] = true
+			hosts = append(hosts, h)
+		}
+	}
+	sort.Slice(hosts, func(a, b int) bool { return compareIPs(hosts[a].IP, hosts[b].IP) < 0 })
+	return hosts
+}
+
+type violationsData struct {
+	ScanID     string
+	JobID      string
+	Violations []Violation
+}
+
+type complianceData struct {
+	JobID     string
+	Report    ComplianceReport
+	ReportURL string
+}
+
+var templateFuncs = map[string]any{
+	"join":     strings.Join,
+	"ports":    joinPorts,
+	"severity": severity,
+	"now":      time.Now,
+	"latency":  roundLatency,
+}
+
+// defaultTemplates are the embedded templates; templates may override them
+var defaultTemplates = mustLoadTemplates("")
+var templates = defaultTemplates
+
+func mustLoadTemplates(dir string) *messageTemplates {
+	t, err := loadTemplates(dir)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// loadTemplates parses the embedded templates, replacing each with the file
+// of the same name in dir, if any
+func loadTemplates(dir string) (*messageTemplates, error) {
+	read := func(name string) (string, error) {
+		if dir != "" {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err == nil {
+				return string(data), nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+		}
+		data, err := embeddedTemplates.ReadFile("templates/" + name)
+		return string(data), err
+	}
+
+	layout, err := read("layout.html")
+	if err != nil {
+		return nil, err
+	}
+	base, err := htmltemplate.New("layout.html").Funcs(templateFuncs).Parse(layout)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &messageTemplates{text: make(map[string]*texttemplate.Template), html: make(map[string]*htmltemplate.Template)}
+	var errs []error
+	for _, kind := range messageKinds {
+		src, err := read(kind + ".txt")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		text, err := texttemplate.New(kind + ".txt").Funcs(templateFuncs).Parse(src)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if text.Lookup("subject") == nil {
+			errs = append(errs, fmt.Errorf("%s.txt: no {{define \"subject\"}}", kind))
+			continue
+		}
+		m.text[kind] = text
+
+		if src, err = read(kind + ".html"); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		html, err := htmltemplate.Must(base.Clone()).Parse(src)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m.html[kind] = html
+	}
+	return m, errors.Join(errs...)
+}
+
+// render executes the templates of kind with data
+func (m *messageTemplates) render(kind string, data any) (Message, error) {
+	text, ok := m.text[kind]
+	if !ok {
+		return Message{}, fmt.Errorf("no template for %q", kind)
+	}
+	var subject, body, html strings.Builder
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := text.Execute(&body, data); err != nil {
+		return Message{}, err
+	}
+	if err := m.html[kind].Execute(&html, data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		// Subjects are a single line
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(body.String()),
+		HTML:    html.String(),
+	}, nil
+}
+
+// notify emails a notification unless the alert rules hold it back
+func notify(kind string, data any) {
+	if data, ok := alertRules.admit(kind, data); ok {
+		deliver(kind, data)
+	}
+}
+
+// deliver emails a notification to the teams its findings are routed to
+func deliver(kind string, data any) {
+	for _, e := range renderEmails(kind, data) {
+		send(e)
+	}
+}
+
+// renderEmails renders a notification into an email per group of teams its
+// findings are routed to. Messages that fail to render from custom
+// templates fall back to the embedded ones.
+func renderEmails(kind string, data any) []Email {
+	var emails []Email
+	for _, m := range alertRoutes.routeMessage(data) {
+		msg, err := templates.render(kind, m.data)
+		if err != nil && templates != defaultTemplates {
+			slog.Error("rendering notification, using the default template", "kind", kind, "error", err)
+			msg, err = defaultTemplates.render(kind, m.data)
+		}
+		if err != nil {
+			slog.Error("rendering notification", "kind", kind, "error", err)
+			continue
+		}
+		e := alertRoutes.addressTo(email, m.teams)
+		e.Subject = msg.Subject
+		e.Msg = msg.Text
+		e.HTML = msg.HTML
+		emails = append(emails, e)
+	}
+	return emails
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRenderDefaultTemplates renders every kind with the embedded templates
+func TestRenderDefaultTemplates(t *testing.T) {
+	result := ScanResult{IP: "10.0.0.5", Hostname: "web.example.test", Port: 80, Open: true,
+		Banner: `HTTP/1.1 200 OK | Server: <script>alert("x")</script>`, CPE: "cpe:2.3:a:apache:http_server:2.4.49",
+		CVEs: []string{"CVE-2021-42013", "CVE-2021-41773"}, CVSS: 9.8}
+	report := ComplianceReport{ScanID: "loop-3", Hosts: 2, Rules: []RuleCompliance{{Name: "web", Hosts: 2, Open: 3, Violations: 1}},
+		Violations: []Violation{{Type: ViolationUnexpectedOpen, Rule: "web", IP: "10.0.0.5", Port: 22, State: StateOpen}}}
+
+	tests := []struct {
+		kind    string
+		data    any
+		subject string
+		text    string
+	}{
+		{MsgUpdate, nil, "Update", "Updating..."},
+		{MsgScanStarted, scanStartedData{Start: "10.0.0.1", End: "10.0.0.9", Ports: []int{22, 80}}, "Scan started", "Starting scan from 10.0.0.1 to 10.0.0.9 on ports 22,80"},
+		{MsgScanStarted, scanStartedData{Hosts: []string{"a.test", "b.test"}, Ports: []int{22}}, "Scan started", "Starting scan of 2 hosts (a.test, b.test) on ports 22"},
+		{MsgOpenPort, result, "Open port found", "Port 80 is open on web.example.test (10.0.0.5)"},
+		{MsgSummary, summaryData{JobID: "j1", Results: []ScanResult{result, {IP: "10.0.0.6", Port: 22}}}, "Open port summary for job j1", "Port 80 is open on web.example.test (10.0.0.5)\n\nPort 22 is open on 10.0.0.6"},
+		{MsgVulnerable, result, "Vulnerable service found: critical severity", "CVSS 9.8: CVE-2021-42013, CVE-2021-41773"},
+		{MsgViolations, violationsData{ScanID: "loop-3", Violations: report.Violations}, "Policy violations: 1 found", "Port 22 on 10.0.0.5 is open but not allowed (web)"},
+		{MsgCompliance, complianceData{Report: report}, "Compliance report", "web: 2 hosts, 3 open ports, 1 violations"},
+	}
+	for _, tt := range tests {
+		msg, err := defaultTemplates.render(tt.kind, tt.data)
+		if err != nil {
+			t.Errorf("render(%s) failed: %v", tt.kind, err)
+			continue
+		}
+		if msg.Subject != tt.subject || !strings.Contains(msg.Text, tt.text) {
+			t.Errorf("render(%s) = %q / %q, want %q / %q", tt.kind, msg.Subject, msg.Text, tt.subject, tt.text)
+		}
+		if !strings.HasPrefix(msg.HTML, "<!DOCTYPE html>") || !strings.Contains(msg.HTML, "in timezone") {
+			t.Errorf("render(%s) HTML = %q", tt.kind, msg.HTML)
+		}
+		if strings.Contains(msg.HTML, "<script>") {
+			t.Errorf("render(%s) HTML does not escape the banner: %q", tt.kind, msg.HTML)
+		}
+	}
+
+	if msg, _ := defaultTemplates.render(MsgOpenPort, result); !strings.Contains(msg.HTML, "&lt;script&gt;") {
+		t.Errorf("open_port HTML = %q, want the escaped banner", msg.HTML)
+	}
+}
+
+// TestCustomTemplates tests overriding some templates from a directory and
+// falling back to the defaults when they fail
+func TestCustomTemplates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("open_port.txt", `{{define "subject"}}[scanner] {{.Host}}:{{.Port}} open{{end}}{{.Host}} answered on {{.Port}}{{.Missing}}`)
+	write("layout.html", `<html>{{block "content" .}}{{end}}</html>`)
+
+	custom, err := loadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := ScanResult{IP: "10.0.0.5", Port: 443, Open: true}
+	if _, err := custom.render(MsgOpenPort, result); err == nil {
+		t.Error("render() with a bad field succeeded")
+	}
+	write("open_port.txt", `{{define "subject"}}[scanner] {{.Host}}:{{.Port}} open{{end}}{{.Host}} answered on {{.Port}}`)
+	if custom, err = loadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := custom.render(MsgOpenPort, result)
+	if err != nil || msg.Subject != "[scanner] 10.0.0.5:443 open" || msg.Text != "10.0.0.5 answered on 443" || !strings.HasPrefix(msg.HTML, "<html><p>Port <b>443</b>") {
+		t.Errorf("custom render = %+v, %v", msg, err)
+	}
+
+	write("update.txt", `no subject here`)
+	if _, err := loadTemplates(dir); err == nil || !strings.Contains(err.Error(), "update.txt") {
+		t.Errorf("loadTemplates() error = %v, want the missing subject reported", err)
+	}
+	write("update.txt", `{{define "subject"}}x{{end}}{{if}}`)
+	if _, err := loadTemplates(dir); err == nil {
+		t.Error("loadTemplates() accepted a template that doesn't parse")
+	}
+
+	// Runtime failures fall back to the embedded templates
+	originalTemplates, originalSend, originalImpl := templates, sendFunc, sendImpl
+	defer func() { templates, sendFunc, sendImpl = originalTemplates, originalSend, originalImpl }()
+	write("update.txt", `{{define "subject"}}x{{end}}{{template "missing"}}`)
+	if templates, err = loadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	var sent []Email
+	sendFunc = func(e Email) { sent = append(sent, e) }
+	sendImpl = func(e Email) (int, time.Duration) { return 200, 0 }
+	notify(MsgUpdate, nil)
+	if len(sent) != 1 || sent[0].Subject != "Update" || sent[0].Msg != "Updating..." {
+		t.Errorf("sent %+v, want the default update message", sent)
+	}
+}
+
+// TestCreatePayloadMultipart tests that both bodies are sent and plain
+// messages are escaped
+func TestCreatePayloadMultipart(t *testing.T) {
+	data, err := createPayload(Email{Subject: "s", Msg: "a <b>\nline", HTML: "<p>html</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p EmailPayload
+	if err := json.Unmarshal(data, &p); err != nil || p.TextContent != "a <b>\nline" || p.HTMLContent != "<p>html</p>" {
+		t.Errorf("payload = %+v, %v", p, err)
+	}
+	data, _ = createPayload(Email{Subject: "s", Msg: "a <b>\nline"})
+	json.Unmarshal(data, &p)
+	if !strings.Contains(p.HTMLContent, "a &lt;b&gt;<br>line") {
+		t.Errorf("htmlContent = %q", p.HTMLContent)
+	}
+}
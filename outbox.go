@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for QueueConfig
+const (
+	defaultQueueFile       = "notify_queue.jsonl"
+	defaultDeadLetterFile  = "notify_dead_letter.jsonl"
+	defaultMaxAttempts     = 8
+	defaultRetryBackoff    = 30 * time.Second
+	defaultMaxRetryBackoff = time.Hour
+)
+
+// compactJournalLines is how many lines the queue file may hold beyond one
+// per pending message before it is rewritten
+const compactJournalLines = 1000
+
+// QueueConfig controls redelivery of notifications the mail API refuses
+type QueueConfig struct {
+	// File keeps undelivered messages across restarts
+	File string `yaml:"file"`
+	// DeadLetter collects messages that failed for good, one JSON per line
+	DeadLetter  string        `yaml:"dead_letter"`
+	MaxAttempts int           `yaml:"max_attempts"`
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+}
+
+// outbox delivers notifications in the background, or is nil to send them
+// inline
+var outbox *Outbox
+
+// queuedEmail is a message waiting in the outbox
+type queuedEmail struct {
+	ID          uint64    `json:"id"`
+	Email       Email     `json:"email"`
+	Queued      time.Time `json:"queued"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastStatus  int       `json:"last_status,omitempty"`
+}
+
+// journalEntry is a line of the queue file, which is appended to as the
+// queue changes. Replaying it in order rebuilds the queue.
+type journalEntry struct {
+	// Put adds a message, or replaces it after a failed attempt
+	Put *queuedEmail `json:"put,omitempty"`
+	// Done removes a delivered or dead-lettered message
+	Done uint64 `json:"done,omitempty"`
+	// Pause holds the whole queue until a Retry-After deadline
+	Pause *time.Time `json:"pause,omitempty"`
+}
+
+// deadLetter is a line of the dead-letter file
+type deadLetter struct {
+	queuedEmail
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Outbox is a persistent queue of notifications, delivered in order with
+// exponential backoff. Rate limits and server errors are retried; a
+// Retry-After pauses the whole queue until it passes. Other refusals and
+// messages out of attempts are dead-lettered.
+type Outbox struct {
+	cfg  QueueConfig
+	now  func() time.Time
+	wake chan struct{}
+
+	mu          sync.Mutex
+	pending     []*queuedEmail
+	pausedUntil time.Time
+	nextID      uint64
+	delivered   uint64
+	dead        uint64
+	lastError   string
+	journaled   int
+}
+
+// OutboxStats is the outbox as reported by the status endpoint
+type OutboxStats struct {
+	Depth        int        `json:"depth"`
+	Delivered    uint64     `json:"delivered"`
+	DeadLettered uint64     `json:"dead_lettered"`
+	NextAttempt  *time.Time `json:"next_attempt,omitempty"`
+	PausedUntil  *time.Time `json:"paused_until,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// NewOutbox returns an outbox holding the messages left in cfg.File
+func NewOutbox(cfg QueueConfig) (*Outbox, error) {
+	o := &Outbox{cfg: cfg, now: time.Now, wake: make(chan struct{}, 1)}
+	if cfg.File == "" {
+		return o, nil
+	}
+	data, err := os.ReadFile(cfg.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := o.replay(data); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", cfg.File, err)
+	}
+	o.compact()
+	return o, nil
+}
+
+// replay rebuilds the queue from the lines of the queue file
+func (o *Outbox) replay(data []byte) error {
+	byID := make(map[uint64]*queuedEmail)
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			// A crash mid-write leaves the last line short
+			if i == len(lines)-1 {
+				break
+			}
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		switch {
+		case e.Put != nil:
+			byID[e.Put.ID] = e.Put
+			o.nextID = max(o.nextID, e.Put.ID)
+		case e.Done != 0:
+			delete(byID, e.Done)
+		case e.Pause != nil:
+			o.pausedUntil = *e.Pause
+		}
+	}
+	for _, q := range byID {
+		o.pending = append(o.pending, q)
+	}
+	slices.SortFunc(o.pending, func(a, b *queuedEmail) int { return cmp.Compare(a.ID, b.ID) })
+	return nil
+}
+
+// Enqueue adds e to the outbox for immediate delivery
+func (o *Outbox) Enqueue(e Email) {
+	o.mu.Lock()
+	now := o.now()
+	o.nextID++
+	q := &queuedEmail{ID: o.nextID, Email: e, Queued: now, NextAttempt: now}
+	o.pending = append(o.pending, q)
+	o.record(journalEntry{Put: q})
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stats returns the depth and counters of the outbox
+func (o *Outbox) Stats() OutboxStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	stats := OutboxStats{Depth: len(o.pending), Delivered: o.delivered, DeadLettered: o.dead, LastError: o.lastError}
+	if q := o.earliest(); q != nil {
+		t := o.due(q)
+		stats.NextAttempt = &t
+	}
+	if o.pausedUntil.After(o.now()) {
+		t := o.pausedUntil
+		stats.PausedUntil = &t
+	}
+	return stats
+}
+
+// Run delivers messages as they fall due until ctx is done
+func (o *Outbox) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		timer.Reset(o.deliverDue())
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// deliverDue attempts every message that is due and returns how long to
+// wait for the next one
+func (o *Outbox) deliverDue() time.Duration {
+	for {
+		o.mu.Lock()
+		q := o.earliest()
+		if q == nil {
+			o.mu.Unlock()
+			return time.Hour
+		}
+		if wait := o.due(q).Sub(o.now()); wait > 0 {
+			o.mu.Unlock()
+			return wait
+		}
+		e := q.Email
+		o.mu.Unlock()
+
+		status, retryAfter := sendImpl(e)
+
+		o.mu.Lock()
+		o.settle(q, status, retryAfter)
+		o.mu.Unlock()
+	}
+}
+
+// earliest returns the message due first; callers hold mu
+func (o *Outbox) earliest() *queuedEmail {
+	var first *queuedEmail
+	for _, q := range o.pending {
+		if first == nil || q.NextAttempt.Before(first.NextAttempt) {
+			first = q
+		}
+	}
+	return first
+}
+
+// due returns when q may be attempted, after any pause; callers hold mu
+func (o *Outbox) due(q *queuedEmail) time.Time {
+	if o.pausedUntil.After(q.NextAttempt) {
+		return o.pausedUntil
+	}
+	return q.NextAttempt
+}
+
+// settle records an attempt at q; callers hold mu
+func (o *Outbox) settle(q *queuedEmail, status int, retryAfter time.Duration) {
+	q.Attempts++
+	q.LastStatus = status
+	if status >= 200 && status < 300 {
+		o.remove(q)
+		o.delivered++
+		o.record(journalEntry{Done: q.ID})
+		return
+	}
+
+	o.lastError = fmt.Sprintf("%q: status %d after %d attempts", q.Email.Subject, status, q.Attempts)
+	if !retryableStatus(status) || q.Attempts >= o.cfg.MaxAttempts {
+		o.remove(q)
+		o.dead++
+		slog.Error("notification failed, moving it to the dead-letter file", "subject", q.Email.Subject, "status", status, "attempts", q.Attempts, "path", o.cfg.DeadLetter)
+		o.deadLetter(q)
+		o.record(journalEntry{Done: q.ID})
+		return
+	}
+	if retryAfter > 0 {
+		// The limit is on the account, so no message may go before it passes
+		o.pausedUntil = o.now().Add(retryAfter)
+		o.record(journalEntry{Pause: &o.pausedUntil})
+		slog.Warn("mail API asked to retry later, pausing notifications", "until", o.pausedUntil)
+	}
+	wait := max(o.backoff(q.Attempts), retryAfter)
+	q.NextAttempt = o.now().Add(wait)
+	o.record(journalEntry{Put: q})
+	slog.Warn("notification not delivered, retrying", "subject", q.Email.Subject, "status", status, "attempts", q.Attempts, "retry_in", wait.String())
+}
+
+func (o *Outbox) remove(q *queuedEmail) {
+	for i, p := range o.pending {
+		if p == q {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// backoff doubles from cfg.Backoff after each attempt, up to cfg.MaxBackoff
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.cfg.Backoff
+	for i := 1; i < attempts && d < o.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, o.cfg.MaxBackoff)
+}
+
+// retryableStatus reports whether a send may succeed later: timeouts, rate
+// limits and server or network errors
+func retryableStatus(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// record appends e to cfg.File, or rewrites the file once most of its lines
+// are about settled messages; callers hold mu
+func (o *Outbox) record(e journalEntry) {
+	if o.cfg.File == "" {
+		return
+	}
+	if o.journaled >= len(o.pending)+compactJournalLines {
+		o.compact()
+		return
+	}
+	line, err := json.Marshal(e)
+	if err == nil {
+		err = appendLine(o.cfg.File, string(line))
+	}
+	if err != nil {
+		slog.Error("saving notification queue", "path", o.cfg.File, "error", err)
+		return
+	}
+	o.journaled++
+}
+
+// compact replaces cfg.File atomically with a line per pending message and
+// any pause still running; callers hold mu
+func (o *Outbox) compact() {
+	if o.cfg.File == "" {
+		return
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	lines := 0
+	if o.pausedUntil.After(o.now()) {
+		enc.Encode(journalEntry{Pause: &o.pausedUntil})
+		lines++
+	}
+	for _, q := range o.pending {
+		enc.Encode(journalEntry{Put: q})
+		lines++
+	}
+	if err := writeFileAtomic(o.cfg.File, buf.Bytes()); err != nil {
+		slog.Error("saving notification queue", "path", o.cfg.File, "error", err)
+		return
+	}
+	o.journaled = lines
+}
+
+func (o *Outbox) deadLetter(q *queuedEmail) {
+	if o.cfg.DeadLetter == "" {
+		return
+	}
+	line, err := json.Marshal(deadLetter{queuedEmail: *q, FailedAt: o.now()})
+	if err == nil {
+		err = appendLine(o.cfg.DeadLetter, string(line))
+	}
+	if err != nil {
+		slog.Error("writing dead-letter file", "path", o.cfg.DeadLetter, "error", err)
+	}
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(h string, now time.Time) time.Duration {
+	h = strings.TrimSpace(h)
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOutboxRetries tests backoff, the pause on Retry-After, dead-lettering
+// and reloading the queue file
+func TestOutboxRetries(t *testing.T) {
+	originalImpl := sendImpl
+	defer func() { sendImpl = originalImpl }()
+
+	dir := t.TempDir()
+	cfg := QueueConfig{
+		File:        filepath.Join(dir, "queue.jsonl"),
+		DeadLetter:  filepath.Join(dir, "dead.jsonl"),
+		MaxAttempts: 3,
+		Backoff:     30 * time.Second,
+		MaxBackoff:  time.Minute,
+	}
+	o, err := NewOutbox(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	o.now = func() time.Time { return now }
+
+	type reply struct {
+		status     int
+		retryAfter time.Duration
+	}
+	replies := map[string][]reply{
+		"retried":  {{429, 2 * time.Minute}, {503, 0}, {200, 0}},
+		"rejected": {{400, 0}},
+		"down":     {{500, 0}, {502, 0}, {504, 0}},
+	}
+	var attempts []string
+	sendImpl = func(e Email) (int, time.Duration) {
+		attempts = append(attempts, e.Subject+"@"+now.Sub(start).String())
+		r := replies[e.Subject][0]
+		replies[e.Subject] = replies[e.Subject][1:]
+		return r.status, r.retryAfter
+	}
+
+	o.Enqueue(Email{Subject: "retried"})
+	o.Enqueue(Email{Subject: "rejected"})
+	o.Enqueue(Email{Subject: "down"})
+
+	// A 429 asking for two minutes holds every message back
+	if wait := o.deliverDue(); wait != 2*time.Minute {
+		t.Errorf("deliverDue() wait = %s, want the 2m Retry-After", wait)
+	}
+	if s := o.Stats(); s.Depth != 3 || s.PausedUntil == nil || !s.PausedUntil.Equal(now.Add(2*time.Minute)) || !s.NextAttempt.Equal(*s.PausedUntil) {
+		t.Errorf("Stats() = %+v after the 429", s)
+	}
+
+	// The queue file, pause included, survives a restart
+	reloaded, err := NewOutbox(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.now = o.now
+	if s := reloaded.Stats(); s.Depth != 3 {
+		t.Errorf("reloaded depth = %d, want 3", s.Depth)
+	}
+	o = reloaded
+
+	wait := o.deliverDue()
+	for i := 0; i < 10 && o.Stats().Depth > 0; i++ {
+		now = now.Add(wait)
+		wait = o.deliverDue()
+	}
+	if wait != time.Hour {
+		t.Errorf("deliverDue() wait = %s with an empty queue", wait)
+	}
+
+	// After the pause "down" backs off 30s then a minute, and "retried" a minute
+	want := "retried@0s,rejected@2m0s,down@2m0s,retried@2m0s,down@2m30s,retried@3m0s,down@3m30s"
+	if got := strings.Join(attempts, ","); got != want {
+		t.Errorf("attempts = %s, want %s", got, want)
+	}
+	// Counters start over with the process
+	if s := o.Stats(); s.Depth != 0 || s.Delivered != 1 || s.DeadLettered != 2 {
+		t.Errorf("Stats() = %+v at the end", s)
+	}
+
+	data, err := os.ReadFile(cfg.DeadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("dead-letter file has %d lines, want 2", len(lines))
+	}
+	var dead deadLetter
+	if err := json.Unmarshal([]byte(lines[1]), &dead); err != nil || dead.Email.Subject != "down" || dead.Attempts != 3 || dead.LastStatus != 504 {
+		t.Errorf("dead letter = %+v, %v", dead, err)
+	}
+}
+
+// TestOutboxJournal tests that the queue file is appended to, compacted once
+// it is mostly settled messages, and survives a torn last line
+func TestOutboxJournal(t *testing.T) {
+	originalImpl := sendImpl
+	defer func() { sendImpl = originalImpl }()
+	sendImpl = func(e Email) (int, time.Duration) { return 200, 0 }
+
+	cfg := QueueConfig{File: filepath.Join(t.TempDir(), "queue.jsonl"), MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Second}
+	o, err := NewOutbox(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := func() int {
+		data, _ := os.ReadFile(cfg.File)
+		return strings.Count(string(data), "\n")
+	}
+	o.Enqueue(Email{Subject: "one"})
+	o.Enqueue(Email{Subject: "two"})
+	if n := lines(); n != 2 {
+		t.Errorf("queue file has %d lines after two messages, want 2", n)
+	}
+	o.deliverDue()
+	if n := lines(); n != 4 {
+		t.Errorf("queue file has %d lines after delivering both, want 4", n)
+	}
+
+	for i := 0; i < compactJournalLines; i++ {
+		o.Enqueue(Email{Subject: "bulk"})
+		o.deliverDue()
+	}
+	if n := lines(); n > compactJournalLines {
+		t.Errorf("queue file has %d lines, want it compacted", n)
+	}
+
+	o.Enqueue(Email{Subject: "kept"})
+	f, _ := os.OpenFile(cfg.File, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"put":{"id":99`)
+	f.Close()
+	reloaded, err := NewOutbox(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := reloaded.Stats(); s.Depth != 1 {
+		t.Errorf("reloaded depth = %d, want 1", s.Depth)
+	}
+	if n := lines(); n != 1 {
+		t.Errorf("queue file has %d lines after loading, want 1", n)
+	}
+	reloaded.Enqueue(Email{Subject: "next"})
+	if len(reloaded.pending) != 2 || reloaded.pending[0].Email.Subject != "kept" || reloaded.pending[1].ID <= reloaded.pending[0].ID {
+		t.Errorf("pending after reload = %+v", reloaded.pending)
+	}
+}
+
+// TestOutboxStatus tests queue depth in the status endpoint
+func TestOutboxStatus(t *testing.T) {
+	defer func() { outbox = nil }()
+	outbox, _ = NewOutbox(QueueConfig{MaxAttempts: 1, Backoff: time.Second, MaxBackoff: time.Second})
+	send(Email{Subject: "queued"})
+
+	rec := httptest.NewRecorder()
+	handleStatus(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var snap StatusSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Notifications == nil || snap.Notifications.Depth != 1 {
+		t.Errorf("status notifications = %+v, want a depth of 1", snap.Notifications)
+	}
+}
+
+// TestParseRetryAfter tests both Retry-After forms
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Thu, 01 Jan 2026 00:00:45 GMT", 45 * time.Second},
+		{"Wed, 31 Dec 2025 23:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const feistelRounds = 4
+
+// TargetOrder selects the order targets are probed in. With Random set the
+// IP x port space is visited in a pseudo-random order fixed by Seed, so a
+// scan can be repeated or resumed from a position index.
+type TargetOrder struct {
+	Random bool  `yaml:"random"`
+	Seed   int64 `yaml:"seed"`
+}
+
+// targetOrder is the order used by scanRange and API jobs
+var targetOrder TargetOrder
+
+// newSeed picks a non-zero seed for a randomized scan that did not set one
+func newSeed() int64 {
+	if seed := time.Now().UnixNano(); seed != 0 {
+		return seed
+	}
+	return 1
+}
+
+// permutation is a keyed bijection on [0, n). A balanced Feistel network
+// permutes the smallest even-bit domain covering n, and cycle walking maps
+// values that land outside [0, n) back inside it.
+type permutation struct {
+	n    uint64
+	bits uint
+	mask uint64
+	keys [feistelRounds]uint64
+}
+
+func newPermutation(n uint64, seed int64) *permutation {
+	p := &permutation{n: n, bits: 1}
+	for p.bits < 32 && uint64(1)<<(2*p.bits) < n {
+		p.bits++
+	}
+	p.mask = uint64(1)<<p.bits - 1
+	state := uint64(seed)
+	for i := range p.keys {
+		state += 0x9e3779b97f4a7c15
+		p.keys[i] = mix64(state)
+	}
+	return p
+}
+
+// mix64 is the splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func (p *permutation) encrypt(x uint64) uint64 {
+	l, r := x>>p.bits, x&p.mask
+	for _, k := range p.keys {
+		l, r = r, l^(mix64(r^k)&p.mask)
+	}
+	return l<<p.bits | r
+}
+
+func (p *permutation) decrypt(x uint64) uint64 {
+	l, r := x>>p.bits, x&p.mask
+	for i := len(p.keys) - 1; i >= 0; i-- {
+		l, r = r^(mix64(l^p.keys[i])&p.mask), l
+	}
+	return l<<p.bits | r
+}
+
+// At returns the element visited at position i, which must be below n
+func (p *permutation) At(i uint64) uint64 {
+	x := p.encrypt(i)
+	for x >= p.n {
+		x = p.encrypt(x)
+	}
+	return x
+}
+
+// Index returns the position element x is visited at, the inverse of At
+func (p *permutation) Index(x uint64) uint64 {
+	i := p.decrypt(x)
+	for i >= p.n {
+		i = p.decrypt(i)
+	}
+	return i
+}
+
+// targetSpace numbers every IP x port pair of a range so a permutation can
+// order them
+type targetSpace struct {
+	start uint32
+	ports []int
+	perm  *permutation
+	// portIndex maps a port to its offset in ports
+	portIndex map[int]uint64
+}
+
+func newTargetSpace(start, end uint32, ports []int, seed int64) *targetSpace {
+	var n uint64
+	if start <= end {
+		n = (uint64(end) - uint64(start) + 1) * uint64(len(ports))
+	}
+	portIndex := make(map[int]uint64, len(ports))
+	for i, port := range ports {
+		portIndex[port] = uint64(i)
+	}
+	return &targetSpace{start: start, ports: ports, perm: newPermutation(n, seed), portIndex: portIndex}
+}
+
+// Len returns the number of positions in the space
+func (s *targetSpace) Len() uint64 {
+	return s.perm.n
+}
+
+// Target returns the address and port probed at position pos
+func (s *targetSpace) Target(pos uint64) (string, int) {
+	idx := s.perm.At(pos)
+	n := uint64(len(s.ports))
+	return uint32ToIP(s.start + uint32(idx/n)), s.ports[idx%n]
+}
+
+// index returns the unpermuted number of ip and port, or false if the pair
+// is not in the space
+func (s *targetSpace) index(ip string, port int) (uint64, bool) {
+	addr := net.ParseIP(ip).To4()
+	off, ok := s.portIndex[port]
+	if addr == nil || !ok {
+		return 0, false
+	}
+	n := uint32(addr[0])<<24 | uint32(addr[1])<<16 | uint32(addr[2])<<8 | uint32(addr[3])
+	if n < s.start {
+		return 0, false
+	}
+	idx := uint64(n-s.start)*uint64(len(s.ports)) + off
+	return idx, idx < s.perm.n
+}
+
+// Position returns the position ip and port are probed at, the inverse of
+// Target
+func (s *targetSpace) Position(ip string, port int) (uint64, bool) {
+	idx, ok := s.index(ip, port)
+	if !ok {
+		return 0, false
+	}
+	return s.perm.Index(idx), true
+}
+
+// scanPositions probes positions [from, to] of space in permuted order. Like
+// scanChunkContext, no new probes start once ctx is done.
+func scanPositions(ctx context.Context, space *targetSpace, from, to uint64, timeout time.Duration, maxConcurrent int, resultChan chan<- ScanResult) {
+	limiter := make(chan struct{}, maxConcurrent)
+	slots := make(chan struct{}, maxConcurrent)
+	var innerWg sync.WaitGroup
+	defer innerWg.Wait()
+
+	logger := loggerFrom(ctx).With("chunk", fmt.Sprintf("positions %d-%d", from, to))
+	logger.Info("scanning chunk in random order")
+
+	for pos := from; pos <= to; pos++ {
+		ip, port := space.Target(pos)
+		if !scope.Permits(ip, port) {
+			continue
+		}
+		if probeLimiter.Wait(ctx) != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+		innerWg.Add(1)
+		go func(ip string, port int) {
+			defer func() {
+				<-slots
+				innerWg.Done()
+			}()
+			result := scanPort(ip, port, timeout, limiter)
+			logProbe(logger, result)
+			resultChan <- result
+		}(ip, port)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestPermutation tests that the permutation is a reproducible bijection
+func TestPermutation(t *testing.T) {
+	for _, n := range []uint64{1, 2, 3, 17, 256, 1000, 4097} {
+		p := newPermutation(n, 42)
+		seen := make(map[uint64]bool)
+		for i := uint64(0); i < n; i++ {
+			v := p.At(i)
+			if v >= n || seen[v] {
+				t.Fatalf("n=%d: At(%d) = %d is out of range or repeated", n, i, v)
+			}
+			seen[v] = true
+		}
+	}
+
+	a, b, c := newPermutation(1000, 7), newPermutation(1000, 7), newPermutation(1000, 8)
+	same, sequential := 0, 0
+	for i := uint64(0); i < 1000; i++ {
+		if a.At(i) != b.At(i) {
+			t.Fatalf("same seed gave different orders at position %d", i)
+		}
+		if a.At(i) == c.At(i) {
+			same++
+		}
+		if a.At(i) == i {
+			sequential++
+		}
+	}
+	if same > 50 || sequential > 50 {
+		t.Errorf("permutation is not well mixed: %d shared with another seed, %d fixed points", same, sequential)
+	}
+}
+
+// TestTargetSpace tests that every IP x port pair is visited once
+func TestTargetSpace(t *testing.T) {
+	ports := []int{22, 80, 443}
+	space := newTargetSpace(ipToUint32("10.0.0.0"), ipToUint32("10.0.0.9"), ports, 3)
+	if space.Len() != 30 {
+		t.Fatalf("Len() = %d, want 30", space.Len())
+	}
+	seen := make(map[string]bool)
+	for pos := uint64(0); pos < space.Len(); pos++ {
+		ip, port := space.Target(pos)
+		seen[fmt.Sprintf("%s:%d", ip, port)] = true
+		if got, ok := space.Position(ip, port); !ok || got != pos {
+			t.Errorf("Position(%s, %d) = %d, %v, want %d", ip, port, got, ok, pos)
+		}
+	}
+	for _, tc := range []struct {
+		ip   string
+		port int
+	}{{"10.0.0.10", 22}, {"9.255.255.255", 22}, {"10.0.0.1", 8080}, {"::1", 22}} {
+		if _, ok := space.Position(tc.ip, tc.port); ok {
+			t.Errorf("Position(%s, %d) found a target outside the space", tc.ip, tc.port)
+		}
+	}
+	if len(seen) != 30 || !seen["10.0.0.0:22"] || !seen["10.0.0.9:443"] {
+		t.Errorf("targets visited = %v", seen)
+	}
+
+	full := newTargetSpace(0, ^uint32(0), []int{80}, 1)
+	if full.Len() != 1<<32 {
+		t.Errorf("full range Len() = %d", full.Len())
+	}
+	if ip, _ := full.Target(full.Len() - 1); net.ParseIP(ip) == nil {
+		t.Errorf("full range Target() = %q", ip)
+	} else if pos, ok := full.Position(ip, 80); !ok || pos != full.Len()-1 {
+		t.Errorf("full range Position(%s) = %d, %v", ip, pos, ok)
+	}
+}
+
+// TestScaleCount tests scaling a resumed total on a space too large for the
+// product to fit in 64 bits
+func TestScaleCount(t *testing.T) {
+	space := newTargetSpace(ipToUint32("10.0.0.0"), ipToUint32("10.255.255.255"), make([]int, 1000), 1)
+	n := space.Len()
+	if got := scaleCount(n, n/4, n); got != n/4 {
+		t.Errorf("scaleCount() = %d, want %d", got, n/4)
+	}
+	if got := scaleCount(n/2, n-1, n); got != n/2-1 {
+		t.Errorf("scaleCount() = %d, want %d", got, n/2-1)
+	}
+}
+
+// TestScanRangeRandomOrder tests a randomized scan and resuming it from a
+// position checkpoint
+func TestScanRangeRandomOrder(t *testing.T) {
+	originalDial := dialTimeout
+	originalSend := sendFunc
+	originalOrder := targetOrder
+	defer func() {
+		dialTimeout = originalDial
+		sendFunc = originalSend
+		targetOrder = originalOrder
+		checkpoints = nil
+	}()
+	sendFunc = func(e Email) {}
+	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return &net.TCPConn{}, nil
+	}
+	targetOrder = TargetOrder{Random: true, Seed: 99}
+
+	checkpoints = nil
+	results.Reset()
+	if err := scanRange("192.168.1.0", "192.168.1.9", []int{80, 443}, time.Millisecond, 4, 3, false); err != nil {
+		t.Fatalf("scanRange() failed: %v", err)
+	}
+	if results.Len() != 20 {
+		t.Errorf("scanRange() in random order produced %d results, want 20", results.Len())
+	}
+	// 3 addresses x 2 ports per chunk gives positions 0-5, 6-11, 12-17, 18-19
+	if pos, ok := loadPositionCheckpoint(99); !ok || pos != 19 || len(checkpoints) != 4 {
+		t.Errorf("position checkpoints = %+v", checkpoints)
+	}
+
+	checkpoints = []Checkpoint{{Position: 11, Seed: 99, Random: true}}
+	results.Reset()
+	if err := scanRange("192.168.1.0", "192.168.1.9", []int{80, 443}, time.Millisecond, 4, 3, false); err != nil {
+		t.Fatalf("scanRange() failed: %v", err)
+	}
+	if results.Len() != 8 {
+		t.Errorf("resumed scanRange() produced %d results, want 8", results.Len())
+	}
+
+	// A checkpoint from another seed describes a different order
+	checkpoints = []Checkpoint{{Position: 11, Seed: 1, Random: true}}
+	if _, ok := loadPositionCheckpoint(99); ok {
+		t.Error("loadPositionCheckpoint() accepted a checkpoint from another seed")
+	}
+}
+
+// TestScanChunkLastAddress tests that a chunk ending at 255.255.255.255
+// terminates
+func TestScanChunkLastAddress(t *testing.T) {
+	originalDial := dialTimeout
+	originalScope := scope
+	defer func() {
+		dialTimeout = originalDial
+		scope = originalScope
+	}()
+	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return &net.TCPConn{}, nil
+	}
+	scope, _ = loadScope(ScopeConfig{Override: true, AllowReserved: true})
+
+	resultChan := make(chan ScanResult, 4)
+	done := make(chan struct{})
+	go func() {
+		scanChunkContext(context.Background(), ipToUint32("255.255.255.254"), ipToUint32("255.255.255.255"), []int{80}, time.Millisecond, 2, resultChan)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("scanChunkContext() did not stop at 255.255.255.255")
+	}
+	if len(resultChan) != 2 {
+		t.Errorf("scanChunkContext() produced %d results, want 2", len(resultChan))
+	}
+}
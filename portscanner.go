@@ -2,56 +2,107 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"math/bits"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 func scanPort(ip string, port int, timeout time.Duration, limiter chan struct{}) ScanResult {
+	return scanTarget(ip, "", port, timeout, limiter)
+}
+
+// scanTarget probes ip:port for the named host. Open ports on unnamed hosts
+// are named by reverse lookup when enabled.
+func scanTarget(ip, hostname string, port int, timeout time.Duration, limiter chan struct{}) ScanResult {
 	limiter <- struct{}{}
 	defer func() { <-limiter }()
 
-	address := fmt.Sprintf("%s:%d", ip, port)
-	conn, err := dialTimeout("tcp", address, timeout)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	dial, route := dialerFor(ip)
+	started := time.Now()
+	conn, err := dial("tcp", address, timeout)
 
 	result := ScanResult{
-		IP:    ip,
-		Port:  port,
-		Error: err,
+		IP:       ip,
+		Hostname: hostname,
+		Port:     port,
+		Error:    err,
+		Route:    route,
+		Started:  started,
+	}
+	// A refusal times the round trip too, unless a proxy refused
+	if err == nil || (route == "" && errors.Is(err, syscall.ECONNREFUSED)) {
+		result.Latency = time.Since(started)
 	}
 
 	if err == nil {
 		result.Open = true
+		if bannerTimeout > 0 {
+			result.Banner = grabBanner(conn, bannerTimeout)
+			tagVulnerabilities(&result)
+		}
 		conn.Close()
+		if hostname == "" && reverseDNS {
+			result.Hostname = resolver.Reverse(ip)
+		}
 	}
 
 	return result
 }
 
 func scanChunk(startIPNum, endIPNum uint32, ports []int, timeout time.Duration, maxConcurrent int, resultChan chan<- ScanResult) {
+	scanChunkContext(context.Background(), startIPNum, endIPNum, ports, timeout, maxConcurrent, resultChan)
+}
+
+// scanChunkContext is scanChunk with cancellation. No new probes are started
+// once ctx is done; probes already in flight run to completion.
+func scanChunkContext(ctx context.Context, startIPNum, endIPNum uint32, ports []int, timeout time.Duration, maxConcurrent int, resultChan chan<- ScanResult) {
 	limiter := make(chan struct{}, maxConcurrent)
+	slots := make(chan struct{}, maxConcurrent)
 	var innerWg sync.WaitGroup
+	defer innerWg.Wait()
 
-	fmt.Printf("Scanning chunk from %s to %s\n", uint32ToIP(startIPNum), uint32ToIP(endIPNum))
+	logger := loggerFrom(ctx).With("chunk", uint32ToIP(startIPNum)+"-"+uint32ToIP(endIPNum))
+	logger.Info("scanning chunk")
 
-	for ipNum := startIPNum; ipNum <= endIPNum; ipNum++ {
-		ip := uint32ToIP(ipNum)
+	// A uint64 counter so a chunk ending at 255.255.255.255 terminates
+	for ipNum := uint64(startIPNum); ipNum <= uint64(endIPNum); ipNum++ {
+		ip := uint32ToIP(uint32(ipNum))
 		for _, port := range ports {
+			if !scope.Permits(ip, port) {
+				continue
+			}
+			if probeLimiter.Wait(ctx) != nil {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case slots <- struct{}{}:
+			}
 			innerWg.Add(1)
 			go func(ip string, port int) {
-				defer innerWg.Done()
+				defer func() {
+					<-slots
+					innerWg.Done()
+				}()
 				result := scanPort(ip, port, timeout, limiter)
+				logProbe(logger, result)
 				resultChan <- result
 			}(ip, port)
 		}
 	}
-	innerWg.Wait()
 }
 
 func saveCheckpoint(lastIP string) {
@@ -65,19 +116,65 @@ func loadCheckpoint() string {
 	return ""
 }
 
+func savePositionCheckpoint(pos uint64, seed int64) {
+	checkpoints = append(checkpoints, Checkpoint{Position: pos, Seed: seed, Random: true})
+}
+
+// loadPositionCheckpoint returns the last position launched by a randomized
+// scan with the given seed
+func loadPositionCheckpoint(seed int64) (uint64, bool) {
+	if len(checkpoints) > 0 {
+		c := checkpoints[len(checkpoints)-1]
+		if c.Random && c.Seed == seed {
+			return c.Position, true
+		}
+	}
+	return 0, false
+}
+
+// recordResult passes a result of the scan loop, whether probed locally or
+// reported by a worker, to the loop's sinks
+func recordResult(result ScanResult) {
+	loopSinks.Record(result)
+}
+
+// scaleCount returns n * part / whole, which must not exceed n, without
+// overflowing on large target spaces
+func scaleCount(n, part, whole uint64) uint64 {
+	hi, lo := bits.Mul64(n, part)
+	q, _ := bits.Div64(hi, lo, whole)
+	return q
+}
+
 func scanRange(startIP, endIP string, ports []int, timeout time.Duration, maxConcurrent, chunkSize int, parallelChunks bool) error {
 	start := ipToUint32(startIP)
 	end := ipToUint32(endIP)
-
-	resumeIP := loadCheckpoint()
-	if resumeIP != "" {
+	logger := slog.With("scan_id", status.ScanID())
+	ctx := withLogger(context.Background(), logger)
+
+	total := scope.Count(start, end, ports)
+	var space *targetSpace
+	var from uint64
+	if targetOrder.Random {
+		space = newTargetSpace(start, end, ports, targetOrder.Seed)
+		if pos, ok := loadPositionCheckpoint(targetOrder.Seed); ok && pos < space.Len() {
+			from = pos + 1
+			// Permuted targets are spread evenly, so scale the total
+			total = scaleCount(total, space.Len()-from, space.Len())
+			logger.Info("resuming from checkpoint", "position", pos)
+		}
+	} else if resumeIP := loadCheckpoint(); resumeIP != "" {
 		resume := ipToUint32(resumeIP)
 		if resume >= start && resume <= end {
 			start = resume + 1
-			fmt.Printf("Resuming from %s\n", resumeIP)
+			total = scope.Count(start, end, ports)
+			logger.Info("resuming from checkpoint", "ip", resumeIP)
 		}
 	}
 
+	status.begin(startIP, endIP, total)
+	defer status.finish()
+
 	resultChan := make(chan ScanResult, maxConcurrent)
 	var wg sync.WaitGroup
 	done := make(chan struct{})
@@ -85,37 +182,49 @@ func scanRange(startIP, endIP string, ports []int, timeout time.Duration, maxCon
 	// Collect results in a separate goroutine
 	go func() {
 		for result := range resultChan {
-			results = append(results, result)
-			if result.Open {
-				fmt.Printf("Port %d is open on %s\n", result.Port, result.IP)
-				email.Subject = "Open port found"
-				email.Msg = fmt.Sprintf("Port %d is open on %s", result.Port, result.IP)
-				send(email)
-			}
+			recordResult(result)
 		}
 		close(done)
 	}()
 
-	// Process chunks
-	for chunkStart := start; chunkStart <= end; chunkStart += uint32(chunkSize) {
-		chunkEnd := chunkStart + uint32(chunkSize) - 1
-		if chunkEnd > end {
-			chunkEnd = end
+	// Process chunks. In random order a chunk is a run of positions covering
+	// chunkSize addresses' worth of probes.
+	if space != nil {
+		step := uint64(chunkSize) * uint64(len(ports))
+		for pos := from; pos < space.Len(); pos += step {
+			last := min(pos+step, space.Len()) - 1
+
+			wg.Add(1)
+			go func(lo, hi uint64) {
+				defer wg.Done()
+				scanPositions(ctx, space, lo, hi, timeout, maxConcurrent, resultChan)
+				events.Publish(EventChunkComplete, "", ChunkComplete{Start: startIP, End: endIP, FromPosition: lo, ToPosition: hi})
+			}(pos, last)
+
+			savePositionCheckpoint(last, targetOrder.Seed)
+		}
+	} else {
+		for chunkStart := uint64(start); chunkStart <= uint64(end); chunkStart += uint64(chunkSize) {
+			chunkEnd := min(chunkStart+uint64(chunkSize)-1, uint64(end))
+
+			wg.Add(1)
+			go func(startNum, endNum uint32) {
+				defer wg.Done()
+				scanChunkContext(ctx, startNum, endNum, ports, timeout, maxConcurrent, resultChan)
+				events.Publish(EventChunkComplete, "", ChunkComplete{Start: uint32ToIP(startNum), End: uint32ToIP(endNum)})
+			}(uint32(chunkStart), uint32(chunkEnd))
+
+			saveCheckpoint(uint32ToIP(uint32(chunkEnd)))
 		}
-
-		wg.Add(1)
-		go func(startNum, endNum uint32) {
-			defer wg.Done()
-			scanChunk(startNum, endNum, ports, timeout, maxConcurrent, resultChan)
-		}(chunkStart, chunkEnd)
-
-		saveCheckpoint(uint32ToIP(chunkEnd))
 	}
 
 	wg.Wait()
 	close(resultChan) // Safe to close after all chunks are done
 	<-done            // Wait for result collection to finish
 
+	snap := status.Snapshot()
+	events.Publish(EventScanComplete, "", ScanComplete{Start: startIP, End: endIP, Probes: snap.Completed, Open: snap.Open, ElapsedSeconds: snap.ElapsedSeconds})
+
 	return nil
 }
 
@@ -141,49 +250,218 @@ func parsePorts(portStr string) []int {
 }
 
 func main() {
-	fmt.Println("Starting port scanner")
 	defer recoverPanic()
 	go update()
 
-	// Flags
-	startIP := flag.String("start", "192.168.1.1", "Starting IP address")
-	endIP := flag.String("end", "192.168.1.10", "Ending IP address")
-	portList := flag.String("ports", "25", "Comma-separated list of ports")
-	timeout := flag.Duration("timeout", 2*time.Second, "Connection timeout")
-	maxConcurrent := flag.Int("concurrent", 1000, "Maximum concurrent scans per chunk")
-	chunkSize := flag.Int("chunk", 1000000, "Number of IPs per chunk (default: 1M)")
-	parallelChunks := flag.Bool("parallel", false, "Run chunks in parallel (experimental)")
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:], os.Stdout))
+	}
 
-	ports := parsePorts(*portList)
+	// Configuration: defaults, config file, environment, then flags
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fatal("loading configuration", "error", err)
+	}
+	if err := setupLogging(cfg.Log, os.Stdout); err != nil {
+		fatal("configuring logging", "error", err)
+	}
+	slog.Info("starting port scanner")
+	if err := cfg.Validate(); err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+	if !cfg.NotificationsEnabled() {
+		slog.Warn("no notifier configured, set BREVO_URL and BREVO_APIKEY or notify.brevo in the config file")
+	}
+	cfg.apply()
 
-	// HTTP server setup
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "10000"
+	if scope, err = loadScope(cfg.Scope); err != nil {
+		fatal("invalid scope", "error", err)
+	}
+	// Background loops are stopped and waited for when main returns
+	background, stopBackground := context.WithCancel(context.Background())
+	var loops sync.WaitGroup
+	defer func() {
+		stopBackground()
+		loops.Wait()
+	}()
+	goLoop := func(run func(context.Context)) {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			run(background)
+		}()
+	}
+	if alertRules, err = loadAlertRules(cfg.Alerts); err != nil {
+		fatal("loading alert rules", "error", err)
+	}
+	// startDelivery starts sending queued and held notifications, which a
+	// dry run must not do
+	startDelivery := func() {
+		if cfg.NotificationsEnabled() {
+			if outbox, err = NewOutbox(cfg.Notify.Queue); err != nil {
+				fatal("loading notification queue", "error", err)
+			}
+			if n := outbox.Stats().Depth; n > 0 {
+				slog.Info("resuming notification queue", "path", cfg.Notify.Queue.File, "pending", n)
+			}
+			goLoop(outbox.Run)
+		}
+		goLoop(alertRules.Run)
+	}
+	if cfg.CVE.Feed != "" {
+		if vulnDB, err = loadVulnDB(cfg.CVE.Feed); err != nil {
+			fatal("invalid CVE feed", "error", err)
+		}
+		slog.Info("loaded CVE feed", "path", cfg.CVE.Feed, "vulnerabilities", vulnDB.Len())
 	}
+
+	if source, _ := newSourceDialer(cfg.Source); source != nil {
+		dialTimeout = source.Dial
+		slog.Info("binding probes", "source_ips", cfg.Source.IPs, "interface", cfg.Source.Interface, "source_ports", cfg.Source.Ports)
+	}
+	if proxies != nil {
+		slog.Info("probing through proxies", "default_chain", len(cfg.Proxy.Chain), "routes", len(cfg.Proxy.Routes))
+	}
+
+	if cfg.Cluster.Mode == ModeWorker {
+		// Workers scan whatever the coordinator hands out, still filtered
+		// through their own scope and exclusions
+		startDelivery()
+		probeLimiter = newRateLimiter(cfg.Scan.Rate)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		worker := NewWorker(cfg.Cluster.CoordinatorURL, cfg.Cluster.WorkerID, cfg.Server.Auth)
+		if err := worker.Run(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("worker stopped", "error", err)
+		}
+		return
+	}
+	if cfg.Baseline != "" {
+		if baseline, err = loadBaseline(cfg.Baseline); err != nil {
+			fatal("invalid baseline", "error", err)
+		}
+	}
+	// Host targets replace the address range
+	hosts, _ := loadHostTargets(cfg.Scan.Hosts, cfg.Scan.HostsFile)
+	if len(hosts) > 0 && scope.restricted() {
+		if err := scope.CheckHosts(resolveTargets(slog.Default(), hosts)); err != nil {
+			fatal("refusing to scan", "error", err)
+		}
+	} else if len(hosts) == 0 {
+		if err := scope.CheckRange(ipToUint32(cfg.Scan.Start), ipToUint32(cfg.Scan.End)); err != nil {
+			fatal("refusing to scan", "error", err)
+		}
+	}
+
+	startIP, endIP, ports := cfg.Scan.Start, cfg.Scan.End, cfg.Scan.Ports
+
+	if cfg.Scan.DryRun {
+		if len(hosts) > 0 {
+			planHosts(resolveTargets(slog.Default(), hosts), ports, cfg.Scan.Timeout, cfg.Scan.Concurrent, cfg.Scan.Rate).Write(os.Stdout)
+		} else {
+			planScan(startIP, endIP, ports, cfg.Scan.Timeout, cfg.Scan.Concurrent, cfg.Scan.Chunk, cfg.Scan.Rate, true).Write(os.Stdout)
+		}
+		return
+	}
+	startDelivery()
+	probeLimiter = newRateLimiter(cfg.Scan.Rate)
+	if targetOrder.Random {
+		if targetOrder.Seed == 0 {
+			targetOrder.Seed = newSeed()
+		}
+		slog.Info("probing in random order, pass -seed to repeat it", "seed", targetOrder.Seed)
+	}
+
+	var loopSchedule Schedule
+	if cfg.Scan.Schedule != "" {
+		// Already validated
+		loopSchedule, _ = parseSchedule(cfg.Scan.Schedule)
+	}
+	if outputs.ExportFile != "" {
+		if exporter, err = NewExporter(outputs.ExportFile, outputs.DiscardClosed); err != nil {
+			fatal("opening export file", "error", err)
+		}
+		defer exporter.Close()
+	}
+
+	// HTTP server setup
+	port := cfg.Server.Port
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
-	server := &http.Server{Addr: ":" + port, Handler: mux}
+	registerAPI(mux)
+	registerAlertAPI(mux, alertRules)
+	registerReportAPI(mux)
+	registerDashboard(mux)
+
+	ctx, stop := signal.NotifyContext(background, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	jobs := NewJobManager(cfg.Jobs.Workers, cfg.Jobs.Queue, cfg.Jobs.Keep)
+	jobs.Start(ctx)
+	defer func() {
+		stop()
+		jobs.Wait()
+	}()
+	registerJobAPI(mux, jobs)
+	scheduler = NewScheduler(jobs)
+	for _, entry := range cfg.Schedules {
+		if err := scheduler.Add(entry); err != nil {
+			fatal("adding schedule", "schedule", entry.Name, "error", err)
+		}
+	}
+	goLoop(scheduler.Run)
+	registerScheduleAPI(mux, scheduler)
+
+	var coordinator *Coordinator
+	if cfg.Cluster.Mode == ModeCoordinator {
+		coordinator = NewCoordinator(cfg.Cluster.LeaseTimeout, recordResult, publishUnitDone)
+		registerClusterAPI(mux, coordinator)
+	}
+
+	auth := cfg.Server.Auth
+	if !auth.Enabled() {
+		slog.Warn("HTTP API is unauthenticated, set API_TOKEN or API_USER and API_PASSWORD")
+	}
+	tlsConfig, err := TLSOptions{CertFile: cfg.Server.TLSCert, KeyFile: cfg.Server.TLSKey, SelfSigned: cfg.Server.TLSSelfSigned}.tlsConfig()
+	if err != nil {
+		slog.Error("configuring TLS", "error", err)
+		return
+	}
+	server := &http.Server{Addr: ":" + port, Handler: requireAuth(rejectCrossOrigin(mux), auth), TLSConfig: tlsConfig}
 
 	go func() {
-		fmt.Println("Starting HTTP server on :" + port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Printf("Error starting server: %v\n", err)
+		var err error
+		if server.TLSConfig != nil {
+			slog.Info("starting HTTPS server", "addr", server.Addr)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			slog.Info("starting HTTP server", "addr", server.Addr)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("starting server", "error", err)
 		}
 	}()
 
+	if cfg.Jobs.ServiceOnly {
+		slog.Info("running as scan service, waiting for jobs")
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			slog.Error("shutting down server", "error", err)
+		}
+		return
+	}
+
 	// Infinite scan loop
+scanLoop:
 	for {
 		// Reset global state for each run
-		results = nil
+		results.Reset()
 		checkpoints = nil
+		status.nextIteration()
 
-		email.Msg = "Starting scan from " + *startIP + " to " + *endIP + " on ports " + *portList
-		email.Subject = "Scan started"
-		send(email)
+		notify(MsgScanStarted, scanStartedData{ScanID: status.ScanID(), Start: startIP, End: endIP, Hosts: hosts, Ports: ports})
 
 		startTime := time.Now()
 		done := make(chan struct{})
@@ -194,47 +472,66 @@ func main() {
 				select {
 				case <-ticker.C:
 					elapsed := time.Since(startTime)
-					fmt.Printf("Elapsed time: %.0f minutes\n", elapsed.Minutes())
+					slog.Info("scan in progress", "scan_id", status.ScanID(), "elapsed", elapsed.Round(time.Second).String())
 				case <-done:
 					return
 				}
 			}
 		}()
 
-		err := scanRange(*startIP, *endIP, ports, *timeout, *maxConcurrent, *chunkSize, *parallelChunks)
+		var err error
+		if len(hosts) > 0 {
+			err = scanHostList(hosts, ports, cfg.Scan.Timeout, cfg.Scan.Concurrent)
+		} else if coordinator != nil {
+			err = scanCluster(ctx, coordinator, startIP, endIP, ports, cfg.Scan.Timeout, cfg.Scan.Concurrent, cfg.Scan.Chunk)
+		} else {
+			err = scanRange(startIP, endIP, ports, cfg.Scan.Timeout, cfg.Scan.Concurrent, cfg.Scan.Chunk, cfg.Scan.Parallel)
+		}
+		if ctx.Err() != nil {
+			close(done)
+			break scanLoop
+		}
 		if err != nil {
-			fmt.Printf("Error during scan: %v\n", err)
+			slog.Error("scan failed", "scan_id", status.ScanID(), "error", err)
 			time.Sleep(5 * time.Second) // Brief delay before retrying on error
 			continue
 		}
 
 		close(done)
-
-		msgBuilder := strings.Builder{}
-		for _, result := range results {
-			if result.Open {
-				msgBuilder.WriteString(fmt.Sprintf("Port %d is open on %s\n\n", result.Port, result.IP))
-			}
+		if err := exporter.Flush(); err != nil {
+			slog.Error("flushing export file", "scan_id", status.ScanID(), "error", err)
 		}
 
-		email.Subject = "Open port summary"
-		email.Msg = msgBuilder.String()
-		send(email)
+		sendScanSummary("", ports, status, results)
 
 		elapsed := time.Since(startTime)
-		fmt.Printf("Scan completed in %.2f minutes. Restarting...\n", elapsed.Minutes())
-
-		// Optional delay between scans (e.g., to avoid overwhelming the network)
-		time.Sleep(1 * time.Second)
+		slog.Info("scan completed", "scan_id", status.ScanID(), "elapsed", elapsed.Round(time.Millisecond).String())
 
 		// For testing, allow exit
 		if os.Getenv("TEST_MODE") == "true" {
 			break // Exit the loop in test mode
 		}
+
+		if loopSchedule == nil {
+			// Optional delay between scans (e.g., to avoid overwhelming the network)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		next := loopSchedule.Next(time.Now())
+		status.setNextRun(next)
+		slog.Info("next scan scheduled", "at", next.Format(time.RFC3339))
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			break scanLoop
+		}
 	}
 
-	// Shutdown server (only reached in TEST_MODE)
+	// Shutdown server (reached in TEST_MODE or on a signal)
+	if coordinator != nil {
+		coordinator.Close()
+	}
 	if err := server.Shutdown(context.Background()); err != nil {
-		fmt.Printf("Error shutting down server: %v\n", err)
+		slog.Error("shutting down server", "error", err)
 	}
 }
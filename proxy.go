@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyConfig routes probes through SOCKS5 or HTTP CONNECT proxies. Chain is
+// used for every target no route covers; an empty chain dials directly.
+type ProxyConfig struct {
+	Chain  []string     `yaml:"chain"`
+	Routes []ProxyRoute `yaml:"routes"`
+}
+
+// ProxyRoute sends probes to Targets through Chain, in order. A route with
+// an empty chain dials its targets directly.
+type ProxyRoute struct {
+	Name    string   `yaml:"name"`
+	Targets []string `yaml:"targets"`
+	Chain   []string `yaml:"chain"`
+}
+
+// defaultRouteName is recorded for targets reached through ProxyConfig.Chain
+const defaultRouteName = "default"
+
+// proxies picks the route for each probe, or is nil to dial directly
+var proxies *proxyRouter
+
+type proxyRouter struct {
+	def    proxyRoute
+	routes []proxyRoute
+}
+
+type proxyRoute struct {
+	name   string
+	ranges []ipRange
+	hops   []*url.URL
+}
+
+// newProxyRouter parses cfg, returning nil when no proxy is configured
+func newProxyRouter(cfg ProxyConfig) (*proxyRouter, error) {
+	if len(cfg.Chain) == 0 && len(cfg.Routes) == 0 {
+		return nil, nil
+	}
+	var errs []error
+	p := &proxyRouter{def: proxyRoute{name: defaultRouteName}}
+	var err error
+	if p.def.hops, err = parseProxyChain(cfg.Chain); err != nil {
+		errs = append(errs, fmt.Errorf("chain: %w", err))
+	}
+	for i, r := range cfg.Routes {
+		route := proxyRoute{name: r.Name}
+		if route.name == "" {
+			route.name = fmt.Sprintf("route %d", i+1)
+		}
+		if len(r.Targets) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no targets", route.name))
+		}
+		for _, target := range r.Targets {
+			ipr, err := parseIPRange(target)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", route.name, err))
+				continue
+			}
+			route.ranges = append(route.ranges, ipr)
+		}
+		if route.hops, err = parseProxyChain(r.Chain); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", route.name, err))
+		}
+		p.routes = append(p.routes, route)
+	}
+	return p, errors.Join(errs...)
+}
+
+// parseProxyChain parses socks5://[user:pass@]host:port and
+// http://[user:pass@]host:port proxy URLs
+func parseProxyChain(chain []string) ([]*url.URL, error) {
+	var hops []*url.URL
+	for _, s := range chain {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q", s)
+		}
+		switch u.Scheme {
+		case "socks5", "socks5h", "http":
+		default:
+			return nil, fmt.Errorf("proxy %s: unsupported scheme %q, want socks5 or http", u.Redacted(), u.Scheme)
+		}
+		if u.Hostname() == "" || u.Port() == "" {
+			return nil, fmt.Errorf("proxy %s: host and port are required", u.Redacted())
+		}
+		hops = append(hops, u)
+	}
+	return hops, nil
+}
+
+// route returns the most specific route covering ip, or the default route
+func (p *proxyRouter) route(ip string) *proxyRoute {
+	if net.ParseIP(ip).To4() == nil {
+		return &p.def
+	}
+	n := ipToUint32(ip)
+	var best *proxyRoute
+	var bestSize uint64
+	for i := range p.routes {
+		for _, r := range p.routes[i].ranges {
+			if n >= r.lo && n <= r.hi && (best == nil || r.size() < bestSize) {
+				best, bestSize = &p.routes[i], r.size()
+			}
+		}
+	}
+	if best == nil {
+		return &p.def
+	}
+	return best
+}
+
+// dialerFor returns the dialer for ip and the name of its route, which is
+// empty for direct connections
+func dialerFor(ip string) (DialerFunc, string) {
+	if proxies == nil {
+		return dialTimeout, ""
+	}
+	route := proxies.route(ip)
+	if len(route.hops) == 0 {
+		return dialTimeout, ""
+	}
+	return chainDialer(route.hops), route.name
+}
+
+// chainDialer connects to the first hop with dialTimeout and asks each hop
+// to connect to the next, the last one to the target. The timeout covers
+// the whole chain.
+func chainDialer(hops []*url.URL) DialerFunc {
+	return func(network, address string, timeout time.Duration) (net.Conn, error) {
+		deadline := time.Now().Add(timeout)
+		conn, err := dialTimeout(network, hops[0].Host, timeout)
+		if err != nil {
+			return nil, hopError{hop: hops[0].Host, err: err}
+		}
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		for i, hop := range hops {
+			next := address
+			if i+1 < len(hops) {
+				next = hops[i+1].Host
+			}
+			if hop.Scheme == "http" {
+				conn, err = httpConnect(conn, hop, next)
+			} else {
+				err = socks5Connect(conn, hop, next)
+			}
+			if err != nil {
+				conn.Close()
+				// Only the last hop's refusal or timeout tells about the target
+				if i == len(hops)-1 && targetAnswer(err) {
+					return nil, fmt.Errorf("proxy %s: %w", hop.Host, err)
+				}
+				return nil, hopError{hop: hop.Host, err: err}
+			}
+		}
+		if err := conn.SetDeadline(time.Time{}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// hopError is a failure of the proxy chain rather than an answer about the
+// target: a hop that is down, or that refuses the tunnel or the request.
+// Such probes read as StateError, whatever the target's port is.
+type hopError struct {
+	hop string
+	err error
+}
+
+func (e hopError) Error() string {
+	if e.hop == "" {
+		return e.err.Error()
+	}
+	return "proxy " + e.hop + ": " + e.err.Error()
+}
+
+func (e hopError) Unwrap() error { return e.err }
+
+// targetAnswer reports whether err, returned by the last hop, is the
+// target refusing the connection or not answering
+func targetAnswer(err error) bool {
+	var pe proxyError
+	if errors.As(err, &pe) {
+		return pe.refused || pe.timeout
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// proxyError is a refusal reported by a proxy. Refused is set when the
+// target refused the connection, so the port reads as closed, and timeout
+// when the proxy could not reach the target, so it reads as filtered.
+// Otherwise the proxy itself refused the request.
+type proxyError struct {
+	msg     string
+	refused bool
+	timeout bool
+}
+
+func (e proxyError) Error() string   { return e.msg }
+func (e proxyError) Timeout() bool   { return e.timeout }
+func (e proxyError) Temporary() bool { return false }
+
+// socks5Replies are the RFC 1928 reply codes
+var socks5Replies = map[byte]proxyError{
+	1: {msg: "general SOCKS server failure"},
+	2: {msg: "connection not allowed by ruleset"},
+	3: {msg: "network unreachable", timeout: true},
+	4: {msg: "host unreachable", timeout: true},
+	5: {msg: "connection refused", refused: true},
+	6: {msg: "TTL expired", timeout: true},
+	7: {msg: "command not supported"},
+	8: {msg: "address type not supported"},
+}
+
+// socks5Connect performs the RFC 1928 CONNECT handshake on conn, with
+// RFC 1929 username/password authentication if hop has credentials
+func socks5Connect(conn net.Conn, hop *url.URL, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	methods := []byte{0x00}
+	if hop.User != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("not a SOCKS5 server")
+	}
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if hop.User == nil {
+			return fmt.Errorf("SOCKS server asked for credentials")
+		}
+		user := hop.User.Username()
+		pass, _ := hop.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return fmt.Errorf("SOCKS credentials longer than 255 bytes")
+		}
+		req := []byte{0x01, byte(len(user))}
+		req = append(req, user...)
+		req = append(req, byte(len(pass)))
+		req = append(req, pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("SOCKS authentication failed")
+		}
+	default:
+		return fmt.Errorf("no acceptable SOCKS authentication method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip.To4() != nil {
+		req = append(append(req, 0x01), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 0x04), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q too long", host)
+		}
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0x00 {
+		if e, ok := socks5Replies[head[1]]; ok {
+			return e
+		}
+		return proxyError{msg: fmt.Sprintf("SOCKS reply %d", head[1])}
+	}
+	// Skip the bound address and port
+	var skip int
+	switch head[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0]) + 2
+	default:
+		return fmt.Errorf("invalid SOCKS address type %d", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// httpConnect asks an HTTP proxy to tunnel conn to address. The returned
+// conn keeps any bytes the proxy sent after its response, such as a banner.
+func httpConnect(conn net.Conn, hop *url.URL, address string) (net.Conn, error) {
+	req := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+	if hop.User != nil {
+		pass, _ := hop.User.Password()
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(hop.User.Username()+":"+pass)) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return conn, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return &bufferedConn{Conn: conn, r: br}, nil
+	case resp.StatusCode == http.StatusGatewayTimeout:
+		return conn, proxyError{msg: "CONNECT: " + resp.Status, timeout: true}
+	case resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable:
+		// How proxies report a refused tunnel
+		return conn, proxyError{msg: "CONNECT: " + resp.Status, refused: true}
+	default:
+		return conn, proxyError{msg: "CONNECT: " + strings.TrimSpace(resp.Status)}
+	}
+}
+
+// bufferedConn reads through r, which has already consumed part of Conn
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
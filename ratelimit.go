@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces probes evenly to at most rate per second. A nil
+// rateLimiter does not limit.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// maxRate is the highest rate a rateLimiter can space probes at, one a
+// nanosecond
+const maxRate = int(time.Second)
+
+// newRateLimiter returns nil, meaning unlimited, for a non-positive rate.
+// Rates above maxRate are limited to it.
+func newRateLimiter(rate int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: max(time.Second/time.Duration(rate), 1)}
+}
+
+// Rate returns the configured probes per second, or 0 if unlimited
+func (l *rateLimiter) Rate() int {
+	if l == nil {
+		return 0
+	}
+	return int(time.Second / l.interval)
+}
+
+// Wait blocks until the next probe may start or ctx is done
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	wait := time.Until(slot)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRateLimiter tests that probes from several goroutines share the rate
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil || newRateLimiter(-1) != nil {
+		t.Error("newRateLimiter() of a non-positive rate should be unlimited")
+	}
+	var unlimited *rateLimiter
+	if unlimited.Rate() != 0 || unlimited.Wait(context.Background()) != nil {
+		t.Error("nil rateLimiter limited")
+	}
+
+	if fast := newRateLimiter(2 * maxRate); fast.Rate() != maxRate {
+		t.Errorf("Rate() above maxRate = %d", fast.Rate())
+	}
+
+	l := newRateLimiter(100)
+	if l.Rate() != 100 {
+		t.Errorf("Rate() = %d", l.Rate())
+	}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				if err := l.Wait(context.Background()); err != nil {
+					t.Errorf("Wait() failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// The first probe goes at once, the other 11 are 10ms apart
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("12 probes at 100/s took %s", elapsed)
+	}
+}
+
+// TestRateLimiterCancel tests that waiting stops with its context
+func TestRateLimiterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newRateLimiter(1).Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() with a cancelled context = %v", err)
+	}
+
+	l := newRateLimiter(1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The next slot is a second away
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() past the deadline = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Wait() returned %s after its deadline", elapsed)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"sort"
+	"strings"
+)
+
+// Team is a named group of alert recipients
+type Team struct {
+	To  []Contact `yaml:"to"`
+	CC  []Contact `yaml:"cc"`
+	BCC []Contact `yaml:"bcc"`
+}
+
+// AlertRoute sends findings matching all of its conditions to Teams
+// instead of the default recipients. Empty conditions match everything.
+type AlertRoute struct {
+	Name    string   `yaml:"name"`
+	Targets []string `yaml:"targets"`
+	Ports   []int    `yaml:"ports"`
+	// Severity is the lowest CVSS severity routed
+	Severity string   `yaml:"severity"`
+	Teams    []string `yaml:"teams"`
+}
+
+// alertRoutes picks the teams for each finding, or is nil to send every
+// alert to the default recipients
+var alertRoutes *alertRouter
+
+type alertRouter struct {
+	teams  map[string]Team
+	routes []alertRoute
+}
+
+type alertRoute struct {
+	ranges   []ipRange
+	ports    map[int]bool
+	minCVSS  float64
+	severity bool
+	teams    []string
+}
+
+// newAlertRouter checks the teams and routes of cfg, returning nil when no
+// route is configured
+func newAlertRouter(cfg NotifyConfig) (*alertRouter, error) {
+	var errs []error
+	for name, team := range cfg.Teams {
+		if len(team.To) == 0 {
+			errs = append(errs, fmt.Errorf("team %s: no to recipients", name))
+		}
+		if err := checkContacts(team.To, team.CC, team.BCC); err != nil {
+			errs = append(errs, fmt.Errorf("team %s: %w", name, err))
+		}
+	}
+	if len(cfg.Routes) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	r := &alertRouter{teams: cfg.Teams}
+	for i, route := range cfg.Routes {
+		name := route.Name
+		if name == "" {
+			name = fmt.Sprintf("route %d", i+1)
+		}
+		ar := alertRoute{teams: route.Teams}
+		for _, target := range route.Targets {
+			ipr, err := parseIPRange(target)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			ar.ranges = append(ar.ranges, ipr)
+		}
+		if len(route.Ports) > 0 {
+			ar.ports = make(map[int]bool)
+			for _, p := range route.Ports {
+				if p <= 0 || p > 65535 {
+					errs = append(errs, fmt.Errorf("%s: port %d is out of range 1-65535", name, p))
+				}
+				ar.ports[p] = true
+			}
+		}
+		if route.Severity != "" {
+			score, err := parseSeverity(route.Severity)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+			ar.minCVSS, ar.severity = score, true
+		}
+		if len(route.Teams) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no teams", name))
+		}
+		for _, team := range route.Teams {
+			if _, ok := cfg.Teams[team]; !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown team %q", name, team))
+			}
+		}
+		r.routes = append(r.routes, ar)
+	}
+	return r, errors.Join(errs...)
+}
+
+// checkContacts reports contacts without a valid address
+func checkContacts(lists ...[]Contact) error {
+	var errs []error
+	for _, list := range lists {
+		for _, c := range list {
+			if _, err := mail.ParseAddress(c.Email); err != nil {
+				errs = append(errs, fmt.Errorf("invalid address %q", c.Email))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// match returns the sorted teams of every route matching a finding on
+// ip:port with the given CVSS score
+func (r *alertRouter) match(ip string, port int, cvss float64) []string {
+	if r == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, route := range r.routes {
+		if route.matches(ip, port, cvss) {
+			for _, team := range route.teams {
+				seen[team] = true
+			}
+		}
+	}
+	teams := make([]string, 0, len(seen))
+	for team := range seen {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+	return teams
+}
+
+func (r alertRoute) matches(ip string, port int, cvss float64) bool {
+	if r.ports != nil && !r.ports[port] {
+		return false
+	}
+	if r.severity && cvss < r.minCVSS {
+		return false
+	}
+	if len(r.ranges) == 0 {
+		return true
+	}
+	if net.ParseIP(ip).To4() == nil {
+		return false
+	}
+	n := ipToUint32(ip)
+	for _, ipr := range r.ranges {
+		if n >= ipr.lo && n <= ipr.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// addressTo returns e sent to teams instead of its own recipients, or e
+// itself when there are no teams
+func (r *alertRouter) addressTo(e Email, teams []string) Email {
+	if r == nil || len(teams) == 0 {
+		return e
+	}
+	e.To, e.CC, e.BCC = nil, nil, nil
+	for _, name := range teams {
+		team := r.teams[name]
+		e.To = append(e.To, team.To...)
+		e.CC = append(e.CC, team.CC...)
+		e.BCC = append(e.BCC, team.BCC...)
+	}
+	return e
+}
+
+// routedMessage is the data of one notification and the teams it goes to
+type routedMessage struct {
+	teams []string
+	data  any
+}
+
+// routeMessage splits data by the teams its findings are routed to, so
+// each team only hears about its own. Other messages go to the default
+// recipients.
+func (r *alertRouter) routeMessage(data any) []routedMessage {
+	if r == nil {
+		return []routedMessage{{data: data}}
+	}
+	switch d := data.(type) {
+	case ScanResult:
+		return []routedMessage{{teams: r.match(d.IP, d.Port, d.CVSS), data: d}}
+	case summaryData:
+		return groupByTeams(d.Results, func(res ScanResult) []string {
+			return r.match(res.IP, res.Port, res.CVSS)
+		}, func(results []ScanResult) any {
+			d.Results = results
+			return d
+		})
+	case violationsData:
+		return groupByTeams(d.Violations, func(v Violation) []string {
+			return r.match(v.IP, v.Port, 0)
+		}, func(violations []Violation) any {
+			d.Violations = violations
+			return d
+		})
+	}
+	return []routedMessage{{data: data}}
+}
+
+// groupByTeams splits items into one message per set of teams, in order of
+// first appearance
+func groupByTeams[T any](items []T, teamsOf func(T) []string, build func([]T) any) []routedMessage {
+	var keys []string
+	teams := make(map[string][]string)
+	groups := make(map[string][]T)
+	for _, item := range items {
+		t := teamsOf(item)
+		key := strings.Join(t, ",")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			teams[key] = t
+		}
+		groups[key] = append(groups[key], item)
+	}
+	if len(keys) == 0 {
+		return []routedMessage{{data: build(items)}}
+	}
+	msgs := make([]routedMessage, 0, len(keys))
+	for _, key := range keys {
+		msgs = append(msgs, routedMessage{teams: teams[key], data: build(groups[key])})
+	}
+	return msgs
+}
+
+// dedupContacts drops repeated addresses, first within To, then from CC
+// and BCC when already listed before
+func dedupContacts(to, cc, bcc []Contact) ([]Contact, []Contact, []Contact) {
+	seen := make(map[string]bool)
+	filter := func(list []Contact) []Contact {
+		var out []Contact
+		for _, c := range list {
+			key := strings.ToLower(strings.TrimSpace(c.Email))
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, c)
+		}
+		return out
+	}
+	to = filter(to)
+	cc = filter(cc)
+	return to, cc, filter(bcc)
+}
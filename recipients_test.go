@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testNotifyConfig() NotifyConfig {
+	return NotifyConfig{
+		Recipient:  Contact{Name: "Admin", Email: "admin@example.com"},
+		Recipients: []Contact{{Email: "noc@example.com"}},
+		BCC:        []Contact{{Email: "archive@example.com"}},
+		Teams: map[string]Team{
+			"secops": {To: []Contact{{Name: "SecOps", Email: "secops@example.com"}}, CC: []Contact{{Email: "ciso@example.com"}}},
+			"lab":    {To: []Contact{{Name: "Lab Owner", Email: "lab@example.com"}}},
+			"db":     {To: []Contact{{Email: "dba@example.com"}}, CC: []Contact{{Email: "secops@example.com"}}},
+		},
+		Routes: []AlertRoute{
+			{Name: "dmz", Targets: []string{"10.1.0.0/24"}, Teams: []string{"secops"}},
+			{Name: "lab", Targets: []string{"10.9.0.0/16"}, Teams: []string{"lab"}},
+			{Name: "databases", Ports: []int{5432, 3306}, Teams: []string{"db"}},
+			{Name: "critical", Severity: "critical", Teams: []string{"secops"}},
+		},
+	}
+}
+
+// TestNewAlertRouter tests validating teams and routes
+func TestNewAlertRouter(t *testing.T) {
+	if r, err := newAlertRouter(NotifyConfig{}); r != nil || err != nil {
+		t.Errorf("newAlertRouter() without routes = %v, %v, want nil", r, err)
+	}
+	if _, err := newAlertRouter(testNotifyConfig()); err != nil {
+		t.Errorf("newAlertRouter() failed: %v", err)
+	}
+
+	cfg := NotifyConfig{
+		Teams: map[string]Team{"empty": {}, "bad": {To: []Contact{{Email: "not an address"}}}},
+		Routes: []AlertRoute{
+			{Targets: []string{"10.0.0.0/33"}, Ports: []int{0}, Severity: "urgent", Teams: []string{"missing"}},
+			{Name: "nobody"},
+		},
+	}
+	_, err := newAlertRouter(cfg)
+	if err == nil {
+		t.Fatal("newAlertRouter() accepted an invalid config")
+	}
+	for _, want := range []string{"team empty: no to recipients", `team bad: invalid address "not an address"`, `route 1: invalid IPv4 CIDR "10.0.0.0/33"`,
+		"route 1: port 0 is out of range", `route 1: unknown severity "urgent"`, `route 1: unknown team "missing"`, "nobody: no teams"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("newAlertRouter() error %q does not contain %q", err, want)
+		}
+	}
+}
+
+// TestAlertRouting tests which teams findings go to and splitting summaries
+func TestAlertRouting(t *testing.T) {
+	r, err := newAlertRouter(testNotifyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip    string
+		port  int
+		cvss  float64
+		teams string
+	}{
+		{"10.1.0.7", 22, 0, "secops"},
+		{"10.9.3.4", 80, 0, "lab"},
+		{"10.9.3.4", 5432, 0, "db,lab"},
+		{"192.168.1.5", 80, 9.8, "secops"},
+		{"192.168.1.5", 80, 7.5, ""},
+		{"2001:db8::1", 22, 0, ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(r.match(tt.ip, tt.port, tt.cvss), ","); got != tt.teams {
+			t.Errorf("match(%s, %d, %.1f) = %q, want %q", tt.ip, tt.port, tt.cvss, got, tt.teams)
+		}
+	}
+
+	summary := summaryData{ScanID: "loop-1", Results: []ScanResult{
+		{IP: "10.1.0.1", Port: 22}, {IP: "192.168.1.1", Port: 80}, {IP: "10.9.0.1", Port: 22}, {IP: "10.1.0.2", Port: 443},
+	}}
+	msgs := r.routeMessage(summary)
+	var got []string
+	for _, m := range msgs {
+		var ips []string
+		for _, res := range m.data.(summaryData).Results {
+			ips = append(ips, res.IP)
+		}
+		got = append(got, strings.Join(m.teams, ",")+"="+strings.Join(ips, " "))
+	}
+	if want := "secops=10.1.0.1 10.1.0.2|=192.168.1.1|lab=10.9.0.1"; strings.Join(got, "|") != want {
+		t.Errorf("routeMessage(summary) = %s, want %s", strings.Join(got, "|"), want)
+	}
+
+	if msgs := r.routeMessage(summaryData{}); len(msgs) != 1 || msgs[0].teams != nil {
+		t.Errorf("routeMessage(empty summary) = %+v, want one default message", msgs)
+	}
+	if msgs := r.routeMessage(nil); len(msgs) != 1 || msgs[0].teams != nil {
+		t.Errorf("routeMessage(nil) = %+v, want one default message", msgs)
+	}
+}
+
+// TestNotifyRecipients tests the recipients of routed and default alerts in
+// the Brevo payload
+func TestNotifyRecipients(t *testing.T) {
+	originalEmail, originalRoutes, originalSend, originalImpl := email, alertRoutes, sendFunc, sendImpl
+	defer func() {
+		email, alertRoutes, sendFunc, sendImpl = originalEmail, originalRoutes, originalSend, originalImpl
+	}()
+
+	cfg := testNotifyConfig()
+	alertRoutes, _ = newAlertRouter(cfg)
+	email = Email{
+		SenderEmail: "scanner@example.com",
+		To:          append([]Contact{cfg.Recipient}, cfg.Recipients...),
+		CC:          []Contact{{Email: "NOC@example.com"}, {Email: "ops@example.com"}},
+		BCC:         cfg.BCC,
+	}
+
+	var payloads []EmailPayload
+	sendFunc = func(e Email) {
+		data, err := createPayload(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p EmailPayload
+		json.Unmarshal(data, &p)
+		payloads = append(payloads, p)
+	}
+	sendImpl = func(e Email) (int, time.Duration) { return 200, 0 }
+
+	notify(MsgOpenPort, ScanResult{IP: "192.168.1.5", Port: 80, Open: true})
+	notify(MsgOpenPort, ScanResult{IP: "10.9.0.3", Port: 3306, Open: true})
+
+	addresses := func(list []Contact) string {
+		var s []string
+		for _, c := range list {
+			s = append(s, c.Email)
+		}
+		return strings.Join(s, ",")
+	}
+	want := []struct{ to, cc, bcc string }{
+		// CC drops noc@, already a To recipient
+		{"admin@example.com,noc@example.com", "ops@example.com", "archive@example.com"},
+		// db's CC of secops@ stays, lab has none
+		{"dba@example.com,lab@example.com", "secops@example.com", ""},
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("sent %d emails, want %d", len(payloads), len(want))
+	}
+	for i, w := range want {
+		p := payloads[i]
+		if addresses(p.To) != w.to || addresses(p.CC) != w.cc || addresses(p.BCC) != w.bcc {
+			t.Errorf("email %d to %q cc %q bcc %q, want %q, %q, %q", i, addresses(p.To), addresses(p.CC), addresses(p.BCC), w.to, w.cc, w.bcc)
+		}
+	}
+	if payloads[1].To[1].Name != "Lab Owner" || payloads[0].Sender.Email != "scanner@example.com" {
+		t.Errorf("payload contacts = %+v, sender %+v", payloads[1].To, payloads[1].Sender)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultReportKeep is how many reports a report directory keeps
+const defaultReportKeep = 100
+
+// reportIndexFile lists the reports of a directory, newest first, so
+// listing them reads one small file
+const reportIndexFile = "index.json"
+
+// reportsMu serializes writing reports and their index
+var reportsMu sync.Mutex
+
+// maxReportRows caps the port listing of a report; open ports come first
+const maxReportRows = 10000
+
+var reportTemplate = htmltemplate.Must(htmltemplate.New("report.html").Funcs(templateFuncs).Funcs(reportFuncs).ParseFS(embeddedTemplates, "templates/report.html"))
+
+// reportFuncs are the template functions only reports use
+var reportFuncs = map[string]any{
+	"list": func(s ...string) []string { return s },
+	"ms":   milliseconds,
+	"percent": func(n, total int) string {
+		if total == 0 {
+			return "0%"
+		}
+		return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+	},
+	// sortableIP orders addresses numerically when sorted as text
+	"sortableIP": func(s string) string {
+		if ip := net.ParseIP(s); ip != nil {
+			return hex.EncodeToString(ip.To16())
+		}
+		return s
+	},
+}
+
+// reportName matches the files served from the report directory
+var reportName = regexp.MustCompile(`^[A-Za-z0-9_.-]+\.html$`)
+
+// ScanReport is the data of a run's HTML report
+type ScanReport struct {
+	Name     string
+	ScanID   string
+	JobID    string
+	Target   string
+	Ports    []int
+	Started  time.Time
+	Finished time.Time
+	Elapsed  time.Duration
+	Rate     float64
+
+	Probes   int
+	States   map[string]int
+	Hosts    []HostReport
+	Rows     []ScanResult
+	Omitted  int
+	Diff     *ReportDiff
+	Previous string
+
+	// unknown holds the ip:port of probes that failed in a proxy chain
+	unknown map[string]bool
+}
+
+// HostReport is a host with at least one open port
+type HostReport struct {
+	HostStats
+	Ports []ScanResult
+}
+
+// ReportDiff compares a run's open ports with the previous run over the
+// same targets
+type ReportDiff struct {
+	Opened  []reportFinding
+	Closed  []reportFinding
+	Changed []reportFinding
+}
+
+// reportFinding is an open port as kept between runs
+type reportFinding struct {
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname,omitempty"`
+	Port     int    `json:"port"`
+	CPE      string `json:"cpe,omitempty"`
+	Was      string `json:"-"`
+}
+
+func (f reportFinding) Host() string {
+	return ScanResult{IP: f.IP, Hostname: f.Hostname}.Host()
+}
+
+// reportRecord is written next to each report so later runs can diff
+// against it
+type reportRecord struct {
+	Name     string          `json:"name"`
+	ScanID   string          `json:"scan_id"`
+	JobID    string          `json:"job_id,omitempty"`
+	Target   string          `json:"target"`
+	Ports    []int           `json:"ports"`
+	Finished time.Time       `json:"finished"`
+	Open     []reportFinding `json:"open"`
+	// Hosts keeps the latency of hosts with open ports
+	Hosts []HostStats `json:"hosts"`
+}
+
+// reportEntry is a report in the index of its directory
+type reportEntry struct {
+	Name     string    `json:"name"`
+	ScanID   string    `json:"scan_id"`
+	JobID    string    `json:"job_id,omitempty"`
+	Target   string    `json:"target"`
+	Ports    []int     `json:"ports"`
+	Finished time.Time `json:"finished"`
+	Open     int       `json:"open"`
+}
+
+func (rec reportRecord) entry() reportEntry {
+	return reportEntry{Name: rec.Name, ScanID: rec.ScanID, JobID: rec.JobID, Target: rec.Target, Ports: rec.Ports, Finished: rec.Finished, Open: len(rec.Open)}
+}
+
+// buildReport summarizes a finished run. Totals come from the store's
+// counts, so they include results it did not keep.
+func buildReport(scanID, jobID string, ports []int, snap StatusSnapshot, rs *ResultStore) *ScanReport {
+	finished := snap.StartedAt.Add(time.Duration(snap.ElapsedSeconds * float64(time.Second)))
+	if snap.StartedAt.IsZero() {
+		finished = time.Now()
+	}
+	r := &ScanReport{
+		ScanID:   scanID,
+		JobID:    jobID,
+		Target:   snap.StartIP,
+		Ports:    ports,
+		Started:  snap.StartedAt,
+		Finished: finished,
+		Elapsed:  time.Duration(snap.ElapsedSeconds * float64(time.Second)).Round(time.Millisecond),
+		Rate:     snap.RatePerSecond,
+		States:   rs.States(),
+	}
+	for _, n := range r.States {
+		r.Probes += n
+	}
+	if snap.EndIP != snap.StartIP {
+		r.Target += " - " + snap.EndIP
+	}
+	r.Name = fmt.Sprintf("%s-%s.html", finished.Format("20060102-150405"), scanID)
+
+	open := rs.Open()
+	byHost := make(map[string][]ScanResult)
+	for _, res := range open {
+		byHost[res.IP] = append(byHost[res.IP], res)
+	}
+	for _, h := range rs.Hosts() {
+		if h.Open > 0 {
+			p := byHost[h.IP]
+			sort.Slice(p, func(a, b int) bool { return p[a].Port < p[b].Port })
+			r.Hosts = append(r.Hosts, HostReport{HostStats: h, Ports: p})
+		}
+	}
+
+	filtered, _ := rs.Query(ResultFilter{State: StateFiltered}, 0, 0)
+	failed, _ := rs.Query(ResultFilter{State: StateError}, 0, 0)
+	r.unknown = make(map[string]bool, len(failed))
+	for _, res := range failed {
+		r.unknown[fmt.Sprintf("%s:%d", res.IP, res.Port)] = true
+	}
+	r.Omitted = r.States[StateFiltered] - len(filtered) + r.States[StateError] - len(failed)
+	r.Rows = append(append(open, filtered...), failed...)
+	if len(r.Rows) > maxReportRows {
+		r.Omitted += len(r.Rows) - maxReportRows
+		r.Rows = r.Rows[:maxReportRows]
+	}
+	return r
+}
+
+// findings returns the open ports of r, sorted
+func (r *ScanReport) findings() []reportFinding {
+	var out []reportFinding
+	for _, h := range r.Hosts {
+		for _, p := range h.Ports {
+			out = append(out, reportFinding{IP: p.IP, Hostname: p.Hostname, Port: p.Port, CPE: p.CPE})
+		}
+	}
+	return out
+}
+
+// hostStats returns the stats of the hosts of r
+func (r *ScanReport) hostStats() []HostStats {
+	hosts := []HostStats{}
+	for _, h := range r.Hosts {
+		hosts = append(hosts, h.HostStats)
+	}
+	return hosts
+}
+
+// diff compares r with the previous run
+func (r *ScanReport) diff(prev *reportRecord) {
+	r.Previous = prev.Name
+	r.Diff = &ReportDiff{}
+	key := func(f reportFinding) string { return fmt.Sprintf("%s:%d", f.IP, f.Port) }
+	before := make(map[string]reportFinding)
+	for _, f := range prev.Open {
+		before[key(f)] = f
+	}
+	for _, f := range r.findings() {
+		old, ok := before[key(f)]
+		delete(before, key(f))
+		switch {
+		case !ok:
+			r.Diff.Opened = append(r.Diff.Opened, f)
+		case old.CPE != f.CPE:
+			f.Was = old.CPE
+			r.Diff.Changed = append(r.Diff.Changed, f)
+		}
+	}
+	for _, f := range prev.Open {
+		// A failed probe does not show that the port closed
+		if _, ok := before[key(f)]; ok && !r.unknown[key(f)] {
+			r.Diff.Closed = append(r.Diff.Closed, f)
+		}
+	}
+}
+
+// writeReport renders r into dir, diffing it with the latest earlier report
+// over the same targets and ports, and returns the path of the HTML file.
+// Beyond keep reports, the oldest are deleted; 0 keeps them all.
+func writeReport(dir string, keep int, r *ScanReport) (string, error) {
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	entries, err := listReports(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if e.Target == r.Target && joinPorts(e.Ports) == joinPorts(r.Ports) && e.Finished.Before(r.Finished) {
+			if prev, err := readReportRecord(dir, e.Name); err != nil {
+				slog.Warn("reading previous report", "name", e.Name, "error", err)
+			} else {
+				r.diff(prev)
+			}
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, r); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, r.Name)
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return "", err
+	}
+	rec := reportRecord{
+		Name:     r.Name,
+		ScanID:   r.ScanID,
+		JobID:    r.JobID,
+		Target:   r.Target,
+		Ports:    r.Ports,
+		Finished: r.Finished,
+		Open:     r.findings(),
+		Hosts:    r.hostStats(),
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(recordPath(dir, r.Name), data); err != nil {
+		return "", err
+	}
+
+	entries = append(entries, rec.entry())
+	sort.SliceStable(entries, func(a, b int) bool { return entries[a].Finished.After(entries[b].Finished) })
+	if keep > 0 && len(entries) > keep {
+		for _, old := range entries[keep:] {
+			for _, p := range []string{filepath.Join(dir, old.Name), recordPath(dir, old.Name)} {
+				if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					slog.Warn("deleting old report", "path", p, "error", err)
+				}
+			}
+		}
+		entries = entries[:keep]
+	}
+	if data, err = json.MarshalIndent(entries, "", "  "); err != nil {
+		return "", err
+	}
+	return path, writeFileAtomic(filepath.Join(dir, reportIndexFile), data)
+}
+
+// recordPath is the JSON record kept next to the report called name
+func recordPath(dir, name string) string {
+	return filepath.Join(dir, strings.TrimSuffix(name, ".html")+".json")
+}
+
+func readReportRecord(dir, name string) (*reportRecord, error) {
+	data, err := os.ReadFile(recordPath(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var rec reportRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// listReports returns the reports in dir, newest first, from its index.
+// Without one it is rebuilt from the records.
+func listReports(dir string) ([]reportEntry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, reportIndexFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return indexReports(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []reportEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		slog.Warn("rebuilding unreadable report index", "dir", dir, "error", err)
+		return indexReports(dir)
+	}
+	return entries, nil
+}
+
+// indexReports reads the record of every report in dir, newest first
+func indexReports(dir string) ([]reportEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	entries := []reportEntry{}
+	for _, path := range paths {
+		if filepath.Base(path) == reportIndexFile {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var rec reportRecord
+		if err := json.Unmarshal(data, &rec); err != nil || rec.Name == "" {
+			slog.Warn("skipping unreadable report record", "path", path, "error", err)
+			continue
+		}
+		entries = append(entries, rec.entry())
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Finished.After(entries[b].Finished) })
+	return entries, nil
+}
+
+// saveRunReport writes the report of a finished run and returns where to
+// read it: under output.report_url when set, or the file path
+func saveRunReport(scanID, jobID string, ports []int, st *ScanStatus, rs *ResultStore) string {
+	if outputs.ReportDir == "" {
+		return ""
+	}
+	r := buildReport(scanID, jobID, ports, st.Snapshot(), rs)
+	path, err := writeReport(outputs.ReportDir, outputs.ReportKeep, r)
+	if err != nil {
+		slog.Error("writing scan report", "scan_id", scanID, "dir", outputs.ReportDir, "error", err)
+		return ""
+	}
+	slog.Info("scan report written", "scan_id", scanID, "path", path)
+	if outputs.ReportURL != "" {
+		return strings.TrimSuffix(outputs.ReportURL, "/") + "/reports/" + r.Name
+	}
+	return path
+}
+
+// reportListing is an entry of GET /reports
+type reportListing struct {
+	Name     string    `json:"name"`
+	ScanID   string    `json:"scan_id"`
+	JobID    string    `json:"job_id,omitempty"`
+	Target   string    `json:"target"`
+	Finished time.Time `json:"finished"`
+	Open     int       `json:"open"`
+	URL      string    `json:"url"`
+}
+
+func registerReportAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /reports", handleReports)
+	mux.HandleFunc("GET /reports/{name}", handleReport)
+}
+
+func handleReports(w http.ResponseWriter, r *http.Request) {
+	list := []reportListing{}
+	if outputs.ReportDir != "" {
+		entries, err := listReports(outputs.ReportDir)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "listing reports: %v", err)
+			return
+		}
+		for _, e := range entries {
+			list = append(list, reportListing{Name: e.Name, ScanID: e.ScanID, JobID: e.JobID, Target: e.Target,
+				Finished: e.Finished, Open: e.Open, URL: "/reports/" + e.Name})
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleReport serves a report, or the newest one as "latest"
+func handleReport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if outputs.ReportDir == "" {
+		writeError(w, http.StatusNotFound, "reports are disabled")
+		return
+	}
+	if name == "latest" {
+		entries, err := listReports(outputs.ReportDir)
+		if err != nil || len(entries) == 0 {
+			writeError(w, http.StatusNotFound, "no reports yet")
+			return
+		}
+		http.Redirect(w, r, "/reports/"+entries[0].Name, http.StatusFound)
+		return
+	}
+	if !reportName.MatchString(name) {
+		writeError(w, http.StatusNotFound, "report not found")
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(outputs.ReportDir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "report not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "reading report: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(data)
+}
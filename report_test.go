@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBuildReport tests the totals, hosts and rows of a report
+func TestBuildReport(t *testing.T) {
+	started := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	snap := StatusSnapshot{StartIP: "10.0.0.1", EndIP: "10.0.0.10", StartedAt: started, ElapsedSeconds: 2.5, RatePerSecond: 2}
+	r := buildReport("scan-3", "", []int{22, 80}, snap, storeOf(
+		ScanResult{IP: "10.0.0.10", Port: 80, Open: true},
+		ScanResult{IP: "10.0.0.2", Port: 22, Open: true, Hostname: "db.example.test", Latency: 4 * time.Millisecond},
+		ScanResult{IP: "10.0.0.2", Port: 80, Error: timeoutError{}},
+		ScanResult{IP: "10.0.0.3", Port: 22, Error: errors.New("connection refused")},
+		ScanResult{IP: "10.0.0.10", Port: 22, Open: true},
+	))
+
+	if r.Name != "20260302-120002-scan-3.html" || r.Target != "10.0.0.1 - 10.0.0.10" {
+		t.Errorf("Name, Target = %q, %q", r.Name, r.Target)
+	}
+	if r.Probes != 5 || r.States[StateOpen] != 3 || r.States[StateFiltered] != 1 || r.States[StateClosed] != 1 {
+		t.Errorf("Probes %d, States %v", r.Probes, r.States)
+	}
+	if len(r.Hosts) != 2 || r.Hosts[0].IP != "10.0.0.2" || r.Hosts[0].Hostname != "db.example.test" || r.Hosts[0].Filtered != 1 || r.Hosts[0].RTT.Avg != 4*time.Millisecond {
+		t.Fatalf("Hosts = %+v", r.Hosts)
+	}
+	if h := r.Hosts[1]; h.Open != 2 || h.Ports[0].Port != 22 || h.Ports[1].Port != 80 {
+		t.Errorf("Hosts[1] = %+v", h)
+	}
+	// Open ports first, closed ports left out
+	if len(r.Rows) != 4 || r.Rows[3].State() != StateFiltered || r.Omitted != 0 {
+		t.Errorf("Rows = %+v, Omitted %d", r.Rows, r.Omitted)
+	}
+}
+
+// storeOf returns a store holding results
+func storeOf(results ...ScanResult) *ResultStore {
+	rs := NewResultStore()
+	for _, r := range results {
+		rs.Add(r)
+	}
+	return rs
+}
+
+// TestBuildReportDiscarded tests that a report counts results the store
+// did not keep
+func TestBuildReportDiscarded(t *testing.T) {
+	original := outputs
+	defer func() { outputs = original }()
+	outputs = OutputConfig{DiscardClosed: true}
+
+	r := buildReport("scan-4", "", []int{22}, StatusSnapshot{StartIP: "10.0.0.1", EndIP: "10.0.0.3"}, storeOf(
+		ScanResult{IP: "10.0.0.1", Port: 22, Open: true},
+		ScanResult{IP: "10.0.0.2", Port: 22, Error: timeoutError{}},
+		ScanResult{IP: "10.0.0.3", Port: 22, Error: errors.New("connection refused")},
+	))
+	if r.Probes != 3 || r.States[StateFiltered] != 1 || r.States[StateClosed] != 1 {
+		t.Errorf("Probes %d, States %v", r.Probes, r.States)
+	}
+	if len(r.Rows) != 1 || r.Omitted != 1 || len(r.Hosts) != 1 {
+		t.Errorf("Rows = %+v, Omitted %d, Hosts %+v", r.Rows, r.Omitted, r.Hosts)
+	}
+}
+
+// TestWriteReport tests writing reports and diffing them with the previous
+// run over the same targets
+func TestWriteReport(t *testing.T) {
+	dir := t.TempDir()
+	started := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	write := func(id string, ports []int, results ...ScanResult) *ScanReport {
+		t.Helper()
+		r := buildReport(id, "", ports, StatusSnapshot{StartIP: "10.0.0.1", EndIP: "10.0.0.9", StartedAt: started}, storeOf(results...))
+		path, err := writeReport(dir, 0, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != filepath.Join(dir, r.Name) {
+			t.Errorf("writeReport() = %s", path)
+		}
+		started = started.Add(time.Hour)
+		return r
+	}
+
+	first := write("scan-1", []int{22, 80, 443},
+		ScanResult{IP: "10.0.0.1", Port: 22, Open: true},
+		ScanResult{IP: "10.0.0.1", Port: 80, Open: true, CPE: "cpe:2.3:a:nginx:nginx:1.24.0"})
+	if first.Diff != nil {
+		t.Errorf("first report has a diff: %+v", first.Diff)
+	}
+	// A different port list is not compared
+	write("other", []int{3389})
+
+	second := write("scan-2", []int{22, 80, 443},
+		ScanResult{IP: "10.0.0.1", Port: 80, Open: true, CPE: "cpe:2.3:a:nginx:nginx:1.25.3"},
+		ScanResult{IP: "10.0.0.5", Port: 443, Open: true, Banner: "<script>alert(1)</script>"})
+	d := second.Diff
+	if d == nil || second.Previous != first.Name {
+		t.Fatalf("second report compared with %q", second.Previous)
+	}
+	if len(d.Opened) != 1 || d.Opened[0].Port != 443 || len(d.Closed) != 1 || d.Closed[0].Port != 22 ||
+		len(d.Changed) != 1 || d.Changed[0].Was != "cpe:2.3:a:nginx:nginx:1.24.0" {
+		t.Errorf("Diff = %+v", d)
+	}
+
+	html, err := os.ReadFile(filepath.Join(dir, second.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"newly open", "no longer open", "nginx:1.24.0 &rarr; cpe:2.3:a:nginx:nginx:1.25.3", "&lt;script&gt;alert(1)&lt;/script&gt;"} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("report does not contain %q", want)
+		}
+	}
+	if strings.Contains(string(html), "<script>alert") {
+		t.Error("report does not escape banners")
+	}
+
+	entries, err := listReports(dir)
+	if err != nil || len(entries) != 3 || entries[0].Name != second.Name || entries[0].Open != 2 {
+		t.Errorf("listReports() = %+v, %v", entries, err)
+	}
+}
+
+// TestReportRetention tests deleting the oldest reports and listing them
+// from the index
+func TestReportRetention(t *testing.T) {
+	dir := t.TempDir()
+	started := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	var names []string
+	for i := 1; i <= 4; i++ {
+		r := buildReport(fmt.Sprintf("scan-%d", i), "", []int{22}, StatusSnapshot{StartIP: "10.0.0.1", EndIP: "10.0.0.9", StartedAt: started}, storeOf(
+			ScanResult{IP: "10.0.0.1", Port: 22, Open: true}))
+		if _, err := writeReport(dir, 2, r); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, r.Name)
+		started = started.Add(time.Hour)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 5 {
+		t.Errorf("report directory holds %v, want two reports, their records and the index", files)
+	}
+	for _, name := range names[:2] {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("old report %s was kept", name)
+		}
+	}
+
+	// Listing reads the index, not the records
+	os.Remove(recordPath(dir, names[3]))
+	entries, err := listReports(dir)
+	if err != nil || len(entries) != 2 || entries[0].Name != names[3] || entries[1].Name != names[2] {
+		t.Errorf("listReports() = %+v, %v", entries, err)
+	}
+	// and rebuilds a missing index from them
+	os.Remove(filepath.Join(dir, reportIndexFile))
+	if entries, err := listReports(dir); err != nil || len(entries) != 1 || entries[0].Name != names[2] || entries[0].Open != 1 {
+		t.Errorf("listReports() without an index = %+v, %v", entries, err)
+	}
+}
+
+// TestReportAPI tests listing and serving reports
+func TestReportAPI(t *testing.T) {
+	original := outputs
+	defer func() { outputs = original }()
+	outputs = OutputConfig{ReportDir: t.TempDir()}
+	mux := http.NewServeMux()
+	registerReportAPI(mux)
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	if rec := get("/reports/latest"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /reports/latest without reports = %d", rec.Code)
+	}
+	st := NewScanStatus()
+	st.begin("10.0.0.1", "10.0.0.1", 1)
+	st.finish()
+	rs := NewResultStore()
+	rs.Add(ScanResult{IP: "10.0.0.1", Port: 22, Open: true})
+	outputs.ReportURL = "https://scanner.example.test/"
+	url := saveRunReport("scan-1", "", []int{22}, st, rs)
+	if !strings.HasPrefix(url, "https://scanner.example.test/reports/") || !strings.HasSuffix(url, "-scan-1.html") {
+		t.Fatalf("saveRunReport() = %q", url)
+	}
+	name := strings.TrimPrefix(url, "https://scanner.example.test/reports/")
+
+	var list []reportListing
+	if rec := get("/reports"); rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &list) != nil || len(list) != 1 || list[0].Open != 1 {
+		t.Errorf("GET /reports = %d %s", rec.Code, rec.Body)
+	}
+	if rec := get("/reports/latest"); rec.Code != http.StatusFound || rec.Header().Get("Location") != "/reports/"+name {
+		t.Errorf("GET /reports/latest = %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	rec := get("/reports/" + name)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), "Scan report scan-1") {
+		t.Errorf("GET /reports/%s = %d %s", name, rec.Code, rec.Header())
+	}
+	for _, target := range []string{"/reports/missing.html", "/reports/" + strings.TrimSuffix(name, ".html") + ".json", "/reports/..%2Fconfig.html"} {
+		if rec := get(target); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d", target, rec.Code)
+		}
+	}
+}
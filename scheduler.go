@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errScheduleExists = errors.New("schedule already exists")
+
+// ScheduledScan submits a scan job every time its schedule fires
+type ScheduledScan struct {
+	Name     string  `json:"name" yaml:"name"`
+	Schedule string  `json:"schedule" yaml:"schedule"`
+	Job      JobSpec `json:"job" yaml:"job"`
+
+	schedule Schedule
+	next     time.Time
+	lastRun  time.Time
+	lastJob  *Job
+	skipped  int
+}
+
+// ScheduleSnapshot is the JSON view of a ScheduledScan
+type ScheduleSnapshot struct {
+	Name      string     `json:"name"`
+	Schedule  string     `json:"schedule"`
+	Job       JobSpec    `json:"job"`
+	NextRun   time.Time  `json:"next_run"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastJobID string     `json:"last_job_id,omitempty"`
+	Skipped   int        `json:"skipped"`
+}
+
+// Scheduler submits scheduled scans to a JobManager. A scan is skipped if
+// the job from its previous run is still queued or running.
+type Scheduler struct {
+	mu      sync.Mutex
+	entries map[string]*ScheduledScan
+	jobs    *JobManager
+	wake    chan struct{}
+	now     func() time.Time
+}
+
+func NewScheduler(jobs *JobManager) *Scheduler {
+	return &Scheduler{
+		entries: make(map[string]*ScheduledScan),
+		jobs:    jobs,
+		wake:    make(chan struct{}, 1),
+		now:     time.Now,
+	}
+}
+
+// Add validates and registers a scheduled scan
+func (s *Scheduler) Add(entry ScheduledScan) error {
+	if entry.Name == "" {
+		return errors.New("schedule name is required")
+	}
+	sched, err := parseSchedule(entry.Schedule)
+	if err != nil {
+		return err
+	}
+	spec := entry.Job
+	if err := spec.validate(); err != nil {
+		return fmt.Errorf("schedule %s: %w", entry.Name, err)
+	}
+	entry.schedule = sched
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[entry.Name]; ok {
+		return fmt.Errorf("%w: %s", errScheduleExists, entry.Name)
+	}
+	entry.next = sched.Next(s.now())
+	if entry.next.IsZero() {
+		return fmt.Errorf("schedule %s never fires", entry.Name)
+	}
+	s.entries[entry.Name] = &entry
+	s.signal()
+	return nil
+}
+
+func (s *Scheduler) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[name]; !ok {
+		return false
+	}
+	delete(s.entries, name)
+	s.signal()
+	return true
+}
+
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// List returns every scheduled scan ordered by next run time
+func (s *Scheduler) List() []ScheduleSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snaps := make([]ScheduleSnapshot, 0, len(s.entries))
+	for _, e := range s.entries {
+		snap := ScheduleSnapshot{Name: e.Name, Schedule: e.Schedule, Job: e.Job, NextRun: e.next, Skipped: e.skipped}
+		if !e.lastRun.IsZero() {
+			t := e.lastRun
+			snap.LastRun = &t
+		}
+		if e.lastJob != nil {
+			snap.LastJobID = e.lastJob.ID
+		}
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(a, b int) bool { return snaps[a].NextRun.Before(snaps[b].NextRun) })
+	return snaps
+}
+
+// Run fires scheduled scans until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		wait := s.tick(s.now())
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// tick submits every scan due at now and returns how long to wait until the
+// next one is due
+func (s *Scheduler) tick(now time.Time) time.Duration {
+	s.mu.Lock()
+	wait := time.Hour
+	var due []*ScheduledScan
+	for _, e := range s.entries {
+		if !e.next.After(now) {
+			if s.ready(e) {
+				due = append(due, e)
+			}
+			e.next = e.schedule.Next(now)
+		}
+		if !e.next.IsZero() {
+			wait = min(wait, e.next.Sub(now))
+		}
+	}
+	specs := make([]JobSpec, len(due))
+	for i, e := range due {
+		specs[i] = e.Job
+	}
+	s.mu.Unlock()
+
+	// Submitting can resolve host targets, so it must not hold up the API
+	for i, e := range due {
+		s.fire(e, specs[i], now)
+	}
+	return max(wait, 0)
+}
+
+// ready reports whether e may fire, skipping it while its previous job is
+// still active
+func (s *Scheduler) ready(e *ScheduledScan) bool {
+	if e.lastJob != nil {
+		if state := e.lastJob.State(); state == JobQueued || state == JobRunning {
+			e.skipped++
+			slog.Warn("skipping scheduled scan, previous job still active", "schedule", e.Name, "scan_id", e.lastJob.ID, "job_state", state)
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Scheduler) fire(e *ScheduledScan, spec JobSpec, now time.Time) {
+	job, err := s.jobs.Submit(spec)
+	if err != nil {
+		slog.Error("submitting scheduled scan", "schedule", e.Name, "error", err)
+		return
+	}
+	s.mu.Lock()
+	e.lastRun = now
+	e.lastJob = job
+	s.mu.Unlock()
+	slog.Info("scheduled scan submitted", "schedule", e.Name, "scan_id", job.ID)
+}
+
+// registerScheduleAPI adds the schedule endpoints to mux
+func registerScheduleAPI(mux *http.ServeMux, s *Scheduler) {
+	mux.HandleFunc("GET /schedules", s.handleList)
+	mux.HandleFunc("POST /schedules", s.handleAdd)
+	mux.HandleFunc("DELETE /schedules/{name}", s.handleRemove)
+}
+
+func (s *Scheduler) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.List())
+}
+
+func (s *Scheduler) handleAdd(w http.ResponseWriter, r *http.Request) {
+	var entry ScheduledScan
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&entry); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid schedule: %v", err)
+		return
+	}
+	err := s.Add(entry)
+	switch {
+	case errors.Is(err, errScheduleExists):
+		writeError(w, http.StatusConflict, "%v", err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "invalid schedule: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+func (s *Scheduler) handleRemove(w http.ResponseWriter, r *http.Request) {
+	if !s.Remove(r.PathValue("name")) {
+		writeError(w, http.StatusNotFound, "schedule not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
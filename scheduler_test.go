@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestParseSchedule tests cron expressions, descriptors and intervals
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2025, time.March, 14, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2025, time.March, 14, 10, 30, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2025, time.March, 15, 2, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, time.March, 14, 11, 0, 0, 0, time.UTC)},
+		{"@nightly", time.Date(2025, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * mon-fri", time.Date(2025, time.March, 17, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 jan,jul *", time.Date(2025, time.July, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2025, time.March, 16, 12, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2025, time.March, 21, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", base.Add(90 * time.Minute)},
+		{"6h", base.Add(6 * time.Hour)},
+	}
+	for _, tc := range tests {
+		sched, err := parseSchedule(tc.expr)
+		if err != nil {
+			t.Errorf("parseSchedule(%q) failed: %v", tc.expr, err)
+			continue
+		}
+		if got := sched.Next(base); !got.Equal(tc.want) {
+			t.Errorf("parseSchedule(%q).Next() = %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"", "* * *", "60 * * * *", "* * * * mon-sun-tue", "*/0 * * * *", "5-1 * * * *", "@every 10ms", "@sometimes"} {
+		if _, err := parseSchedule(bad); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+// TestSchedulerPreventsOverlap tests that a scan is skipped while its previous job runs
+func TestSchedulerPreventsOverlap(t *testing.T) {
+	originalDial := dialTimeout
+	originalImpl := sendImpl
+	defer func() {
+		dialTimeout = originalDial
+		sendImpl = originalImpl
+	}()
+	sendImpl = func(e Email) (int, time.Duration) { return 200, 0 }
+	release := make(chan struct{})
+	dialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		<-release
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs := NewJobManager(1, 10, defaultJobsKept)
+	jobs.Start(ctx)
+
+	now := time.Date(2025, time.March, 14, 10, 0, 0, 0, time.UTC)
+	s := NewScheduler(jobs)
+	s.now = func() time.Time { return now }
+	entry := ScheduledScan{Name: "dmz", Schedule: "@every 1h", Job: JobSpec{Start: "10.0.0.1", End: "10.0.0.1", Ports: []int{80}}}
+	if err := s.Add(entry); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	if err := s.Add(entry); err == nil {
+		t.Error("Add() accepted a duplicate name")
+	}
+
+	if wait := s.tick(now); wait != time.Hour {
+		t.Errorf("tick() before due returned wait %s", wait)
+	}
+	now = now.Add(time.Hour)
+	s.tick(now)
+	first := s.List()[0]
+	if first.LastJobID == "" || !first.NextRun.Equal(now.Add(time.Hour)) {
+		t.Fatalf("first run not recorded: %+v", first)
+	}
+	job, _ := jobs.Get(first.LastJobID)
+	waitForJobState(t, job, JobRunning)
+
+	now = now.Add(time.Hour)
+	s.tick(now)
+	second := s.List()[0]
+	if second.Skipped != 1 || second.LastJobID != first.LastJobID {
+		t.Errorf("overlapping run was not skipped: %+v", second)
+	}
+
+	close(release)
+	waitForJobState(t, job, JobCompleted)
+	now = now.Add(time.Hour)
+	s.tick(now)
+	third := s.List()[0]
+	if third.LastJobID == first.LastJobID {
+		t.Errorf("run after completion was not submitted: %+v", third)
+	} else if job, ok := jobs.Get(third.LastJobID); ok {
+		waitForJobState(t, job, JobCompleted)
+	}
+
+	if !s.Remove("dmz") || s.Remove("dmz") {
+		t.Error("Remove() did not remove exactly once")
+	}
+}
+
+// slowResolver holds lookups back until release is closed once blocking
+// is set, signalling on waiting when one is held
+type slowResolver struct {
+	blocking atomic.Bool
+	waiting  chan struct{}
+	release  chan struct{}
+}
+
+func (r *slowResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if r.blocking.Load() {
+		r.waiting <- struct{}{}
+		<-r.release
+	}
+	return []string{"10.0.0.5"}, nil
+}
+
+func (r *slowResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return nil, fmt.Errorf("no PTR for %s", addr)
+}
+
+// TestSchedulerSubmitUnlocked tests that listing schedules does not wait for
+// a due scan's targets to resolve
+func TestSchedulerSubmitUnlocked(t *testing.T) {
+	originalResolver, originalScope := resolver, scope
+	defer func() { resolver, scope = originalResolver, originalScope }()
+	slow := &slowResolver{waiting: make(chan struct{}, 1), release: make(chan struct{})}
+	resolver = newDNSCache(slow, 0, time.Minute)
+	scope = &Scope{allowed: mergeRanges([]ipRange{{ipToUint32("10.0.0.0"), ipToUint32("10.0.0.255")}})}
+
+	now := time.Date(2025, time.March, 14, 10, 0, 0, 0, time.UTC)
+	s := NewScheduler(NewJobManager(1, 10, defaultJobsKept))
+	s.now = func() time.Time { return now }
+	if err := s.Add(ScheduledScan{Name: "web", Schedule: "@every 1h", Job: JobSpec{Hosts: []string{"web.example.test"}, Ports: []int{80}}}); err != nil {
+		t.Fatal(err)
+	}
+	slow.blocking.Store(true)
+	now = now.Add(time.Hour)
+	ticked := make(chan struct{})
+	go func() {
+		s.tick(now)
+		close(ticked)
+	}()
+
+	<-slow.waiting
+	listed := make(chan []ScheduleSnapshot)
+	go func() { listed <- s.List() }()
+	select {
+	case snaps := <-listed:
+		if len(snaps) != 1 || snaps[0].LastJobID != "" {
+			t.Errorf("List() during submission = %+v", snaps)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("List() blocked while a scheduled scan was being submitted")
+	}
+	close(slow.release)
+	<-ticked
+	if snap := s.List()[0]; snap.LastJobID == "" {
+		t.Errorf("scheduled scan not submitted: %+v", snap)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errOutOfScope = errors.New("target outside allowed scope")
+
+// reservedRanges is the built-in deny list: bogon, multicast and broadcast
+// space that should never be probed. RFC 1918 private space is deliberately
+// not included since internal networks are the scanner's main target.
+var reservedRanges = []string{
+	"0.0.0.0/8",          // "this" network
+	"100.64.0.0/10",      // carrier-grade NAT
+	"127.0.0.0/8",        // loopback
+	"169.254.0.0/16",     // link-local
+	"192.0.0.0/24",       // IETF protocol assignments
+	"192.0.2.0/24",       // TEST-NET-1
+	"198.18.0.0/15",      // benchmarking
+	"198.51.100.0/24",    // TEST-NET-2
+	"203.0.113.0/24",     // TEST-NET-3
+	"224.0.0.0/4",        // multicast
+	"240.0.0.0/4",        // reserved
+	"255.255.255.255/32", // limited broadcast
+}
+
+// ipRange is an inclusive range of IPv4 addresses
+type ipRange struct {
+	lo, hi uint32
+}
+
+func (r ipRange) size() uint64 {
+	return uint64(r.hi) - uint64(r.lo) + 1
+}
+
+// portRule excludes a single port on a range of addresses
+type portRule struct {
+	ipRange
+	port int
+}
+
+// Scope decides which address and port combinations may be probed
+type Scope struct {
+	allowed     []ipRange // nil allows every address
+	denied      []ipRange
+	portRules   []portRule
+	ignoreScope bool
+}
+
+// ScopeConfig selects the allowed scope and exclusions
+type ScopeConfig struct {
+	File          string   `yaml:"file"`
+	Exclude       []string `yaml:"exclude"`
+	ExcludeFile   string   `yaml:"exclude_file"`
+	AllowReserved bool     `yaml:"allow_reserved"`
+	Override      bool     `yaml:"override"`
+}
+
+// defaultScope allows everything except the built-in deny list
+func defaultScope() *Scope {
+	s := &Scope{}
+	for _, cidr := range reservedRanges {
+		r, _ := parseIPRange(cidr)
+		s.denied = append(s.denied, r)
+	}
+	s.denied = mergeRanges(s.denied)
+	return s
+}
+
+// loadScope builds a Scope from cfg. An allowed-scope file is required
+// unless cfg.Override is set.
+func loadScope(cfg ScopeConfig) (*Scope, error) {
+	s := &Scope{ignoreScope: cfg.Override}
+	if !cfg.AllowReserved {
+		s.denied = defaultScope().denied
+	}
+
+	if cfg.File == "" && !cfg.Override {
+		return nil, errors.New("an allowed-scope file is required (set -scope or scope.file, or override the scope check)")
+	}
+	if cfg.File != "" {
+		lines, err := readListFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			r, err := parseIPRange(line)
+			if err != nil {
+				return nil, fmt.Errorf("scope file %s: %w", cfg.File, err)
+			}
+			s.allowed = append(s.allowed, r)
+		}
+		if len(s.allowed) == 0 {
+			return nil, fmt.Errorf("scope file %s lists no networks", cfg.File)
+		}
+		s.allowed = mergeRanges(s.allowed)
+	}
+
+	excludes := append([]string(nil), cfg.Exclude...)
+	if cfg.ExcludeFile != "" {
+		lines, err := readListFile(cfg.ExcludeFile)
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, lines...)
+	}
+	for _, entry := range excludes {
+		if err := s.addExclusion(entry); err != nil {
+			return nil, err
+		}
+	}
+	s.denied = mergeRanges(s.denied)
+	return s, nil
+}
+
+// addExclusion parses an IP, CIDR, or either with a ":port" suffix
+func (s *Scope) addExclusion(entry string) error {
+	target, portStr, hasPort := strings.Cut(entry, ":")
+	r, err := parseIPRange(target)
+	if err != nil {
+		return fmt.Errorf("invalid exclusion %q: %w", entry, err)
+	}
+	if !hasPort {
+		s.denied = append(s.denied, r)
+		return nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid exclusion %q: bad port", entry)
+	}
+	s.portRules = append(s.portRules, portRule{ipRange: r, port: port})
+	return nil
+}
+
+// readListFile returns the non-empty lines of path with # comments removed
+func readListFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// parseIPRange accepts a single IPv4 address or a CIDR block
+func parseIPRange(s string) (ipRange, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil || ipNet.IP.To4() == nil {
+			return ipRange{}, fmt.Errorf("invalid IPv4 CIDR %q", s)
+		}
+		lo := ipToUint32(ipNet.IP.String())
+		ones, _ := ipNet.Mask.Size()
+		hi := lo | uint32(uint64(1)<<(32-ones)-1)
+		return ipRange{lo, hi}, nil
+	}
+	if net.ParseIP(s).To4() == nil {
+		return ipRange{}, fmt.Errorf("invalid IPv4 address %q", s)
+	}
+	n := ipToUint32(s)
+	return ipRange{n, n}, nil
+}
+
+// mergeRanges sorts ranges and coalesces overlapping or adjacent ones
+func mergeRanges(ranges []ipRange) []ipRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := append([]ipRange(nil), ranges...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].lo < sorted[b].lo })
+	merged := []ipRange{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if uint64(r.lo) <= uint64(last.hi)+1 {
+			last.hi = max(last.hi, r.hi)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func rangesContain(ranges []ipRange, n uint32) bool {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].hi >= n })
+	return i < len(ranges) && ranges[i].lo <= n
+}
+
+// intersect returns the parts of r covered by ranges, which must be merged
+func intersect(ranges []ipRange, r ipRange) []ipRange {
+	var out []ipRange
+	for _, a := range ranges {
+		lo, hi := max(a.lo, r.lo), min(a.hi, r.hi)
+		if lo <= hi {
+			out = append(out, ipRange{lo, hi})
+		}
+	}
+	return out
+}
+
+// subtract returns the parts of each range in from not covered by ranges
+func subtract(from, ranges []ipRange) []ipRange {
+	var out []ipRange
+	for _, r := range from {
+		cur := uint64(r.lo)
+		for _, d := range ranges {
+			if uint64(d.hi) < cur || d.lo > r.hi {
+				continue
+			}
+			if uint64(d.lo) > cur {
+				out = append(out, ipRange{uint32(cur), d.lo - 1})
+			}
+			cur = uint64(d.hi) + 1
+		}
+		if cur <= uint64(r.hi) {
+			out = append(out, ipRange{uint32(cur), r.hi})
+		}
+	}
+	return out
+}
+
+func totalSize(ranges []ipRange) uint64 {
+	var n uint64
+	for _, r := range ranges {
+		n += r.size()
+	}
+	return n
+}
+
+// inScope reports whether n is inside the allowed scope
+func (s *Scope) inScope(n uint32) bool {
+	return s.ignoreScope || s.allowed == nil || rangesContain(s.allowed, n)
+}
+
+// Permits reports whether ip:port may be probed
+func (s *Scope) Permits(ip string, port int) bool {
+	if addr := net.ParseIP(ip); addr.To4() == nil {
+		// Scope files are IPv4 only, so IPv6 targets need the override and
+		// never reach loopback, link-local or multicast space
+		return s.ignoreScope && addr.IsGlobalUnicast()
+	}
+	n := ipToUint32(ip)
+	if !s.inScope(n) || rangesContain(s.denied, n) {
+		return false
+	}
+	for _, rule := range s.portRules {
+		if rule.port == port && n >= rule.lo && n <= rule.hi {
+			return false
+		}
+	}
+	return true
+}
+
+// restricted reports whether targets are checked against an allowed scope
+func (s *Scope) restricted() bool {
+	return !s.ignoreScope && s.allowed != nil
+}
+
+// permitted returns the parts of [start, end] that may be probed on some port
+func (s *Scope) permitted(start, end uint32) []ipRange {
+	targets := []ipRange{{start, end}}
+	if s.restricted() {
+		targets = intersect(s.allowed, targets[0])
+	}
+	return subtract(targets, s.denied)
+}
+
+// Count returns how many probes of [start, end] x ports the scope permits
+func (s *Scope) Count(start, end uint32, ports []int) uint64 {
+	if start > end {
+		return 0
+	}
+	permitted := s.permitted(start, end)
+	total := totalSize(permitted) * uint64(len(ports))
+
+	byPort := make(map[int][]ipRange)
+	for _, rule := range s.portRules {
+		byPort[rule.port] = append(byPort[rule.port], rule.ipRange)
+	}
+	for _, port := range ports {
+		for _, r := range mergeRanges(byPort[port]) {
+			total -= totalSize(intersect(permitted, r))
+		}
+	}
+	return total
+}
+
+// CheckRange returns an error wrapping errOutOfScope if any address in
+// [start, end] is outside the allowed scope
+func (s *Scope) CheckRange(start, end uint32) error {
+	if !s.restricted() {
+		return nil
+	}
+	outside := subtract([]ipRange{{start, end}}, s.allowed)
+	if len(outside) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d addresses between %s and %s are outside the allowed scope, starting at %s",
+		errOutOfScope, totalSize(outside), uint32ToIP(start), uint32ToIP(end), uint32ToIP(outside[0].lo))
+}
+
+// CheckHosts returns an error wrapping errOutOfScope if any address the
+// targets resolved to is outside the allowed scope
+func (s *Scope) CheckHosts(targets []hostTarget) error {
+	if !s.restricted() {
+		return nil
+	}
+	var outside []string
+	for _, t := range targets {
+		for _, addr := range t.Addrs {
+			if net.ParseIP(addr).To4() == nil || !rangesContain(s.allowed, ipToUint32(addr)) {
+				outside = append(outside, ScanResult{IP: addr, Hostname: t.Name}.Host())
+			}
+		}
+	}
+	if len(outside) == 0 {
+		return nil
+	}
+	listed := outside
+	if len(listed) > 5 {
+		listed = append(listed[:5:5], "...")
+	}
+	return fmt.Errorf("%w: %d resolved addresses are outside the allowed scope: %s",
+		errOutOfScope, len(outside), strings.Join(listed, ", "))
+}
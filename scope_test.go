@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadScope tests scope files, exclusions and the built-in deny list
+func TestLoadScope(t *testing.T) {
+	dir := t.TempDir()
+	scopeFile := filepath.Join(dir, "scope.txt")
+	excludeFile := filepath.Join(dir, "exclude.txt")
+	os.WriteFile(scopeFile, []byte("# lab networks\n10.0.0.0/16\n192.168.1.0/24 # office\n127.0.0.1\n"), 0600)
+	os.WriteFile(excludeFile, []byte("10.0.5.0/24\n192.168.1.1:22\n"), 0600)
+
+	s, err := loadScope(ScopeConfig{File: scopeFile, ExcludeFile: excludeFile, Exclude: []string{"10.0.0.1"}})
+	if err != nil {
+		t.Fatalf("loadScope() failed: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		port int
+		want bool
+	}{
+		{"10.0.1.1", 80, true},
+		{"10.1.0.1", 80, false},    // outside scope
+		{"10.0.5.9", 80, false},    // excluded CIDR
+		{"10.0.0.1", 80, false},    // excluded host
+		{"192.168.1.1", 22, false}, // excluded host:port
+		{"192.168.1.1", 80, true},
+		{"127.0.0.1", 80, false}, // in scope but on the deny list
+	}
+	for _, tc := range tests {
+		if got := s.Permits(tc.ip, tc.port); got != tc.want {
+			t.Errorf("Permits(%s, %d) = %v, want %v", tc.ip, tc.port, got, tc.want)
+		}
+	}
+
+	if _, err := loadScope(ScopeConfig{}); err == nil {
+		t.Error("loadScope() accepted a config without a scope file")
+	}
+	if _, err := loadScope(ScopeConfig{Override: true, Exclude: []string{"10.0.0.1:http"}}); err == nil {
+		t.Error("loadScope() accepted an invalid exclusion")
+	}
+
+	reserved, err := loadScope(ScopeConfig{Override: true, AllowReserved: true})
+	if err != nil {
+		t.Fatalf("loadScope() failed: %v", err)
+	}
+	if !reserved.Permits("127.0.0.1", 80) || !reserved.Permits("8.8.8.8", 53) {
+		t.Error("override with allow_reserved should permit any address")
+	}
+}
+
+// TestScopeCheckRange tests refusing targets outside the allowed scope
+func TestScopeCheckRange(t *testing.T) {
+	s := &Scope{allowed: mergeRanges([]ipRange{{ipToUint32("10.0.0.0"), ipToUint32("10.0.0.255")}})}
+	if err := s.CheckRange(ipToUint32("10.0.0.1"), ipToUint32("10.0.0.254")); err != nil {
+		t.Errorf("CheckRange() rejected in-scope range: %v", err)
+	}
+	err := s.CheckRange(ipToUint32("10.0.0.250"), ipToUint32("10.0.1.4"))
+	if !errors.Is(err, errOutOfScope) {
+		t.Errorf("CheckRange() = %v, want errOutOfScope", err)
+	}
+
+	s.ignoreScope = true
+	if err := s.CheckRange(0, ipToUint32("255.255.255.255")); err != nil {
+		t.Errorf("CheckRange() with override failed: %v", err)
+	}
+}
+
+// TestScopeCheckHosts tests refusing host targets that resolve outside the
+// allowed scope
+func TestScopeCheckHosts(t *testing.T) {
+	s := &Scope{allowed: mergeRanges([]ipRange{{ipToUint32("10.0.0.0"), ipToUint32("10.0.0.255")}})}
+	inside := []hostTarget{{Name: "web.example.test", Addrs: []string{"10.0.0.5"}}, {Addrs: []string{"10.0.0.9"}}}
+	if err := s.CheckHosts(inside); err != nil {
+		t.Errorf("CheckHosts() rejected in-scope hosts: %v", err)
+	}
+	outside := append(inside, hostTarget{Name: "db.example.test", Addrs: []string{"10.0.0.7", "10.9.0.7", "2001:db8::7"}})
+	err := s.CheckHosts(outside)
+	if !errors.Is(err, errOutOfScope) || !strings.Contains(err.Error(), "2 resolved addresses") || !strings.Contains(err.Error(), "db.example.test (10.9.0.7)") {
+		t.Errorf("CheckHosts() = %v, want errOutOfScope naming db.example.test", err)
+	}
+
+	s.ignoreScope = true
+	if err := s.CheckHosts(outside); err != nil {
+		t.Errorf("CheckHosts() with override failed: %v", err)
+	}
+}
+
+// TestScopeCount tests probe counting after exclusions
+func TestScopeCount(t *testing.T) {
+	s, err := loadScope(ScopeConfig{Override: true, Exclude: []string{"10.0.0.0/30", "10.0.0.10:22", "10.0.0.8/29:443"}})
+	if err != nil {
+		t.Fatalf("loadScope() failed: %v", err)
+	}
+	// 10.0.0.0-10.0.0.15 is 16 addresses; 4 excluded outright
+	got := s.Count(ipToUint32("10.0.0.0"), ipToUint32("10.0.0.15"), []int{22, 80, 443})
+	want := uint64(12*3 - 1 - 8)
+	if got != want {
+		t.Errorf("Count() = %d, want %d", got, want)
+	}
+
+	// Count must agree with probing each address
+	var probes uint64
+	for n := ipToUint32("10.0.0.0"); n <= ipToUint32("10.0.0.15"); n++ {
+		for _, port := range []int{22, 80, 443} {
+			if s.Permits(uint32ToIP(n), port) {
+				probes++
+			}
+		}
+	}
+	if probes != got {
+		t.Errorf("Count() = %d but Permits() allows %d", got, probes)
+	}
+
+	all := defaultScope().Count(0, ipToUint32("255.255.255.255"), []int{80})
+	if all == 0 || all >= 1<<32 {
+		t.Errorf("Count() over the full space = %d", all)
+	}
+}
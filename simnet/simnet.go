@@ -0,0 +1,296 @@
+// Package simnet is an in-memory network for testing the scanner without
+// real sockets. A Network is built from a declarative Topology and dials
+// with the same signature as net.DialTimeout, so it can stand in for the
+// scanner's dialer. Open ports are served over net.Pipe.
+package simnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Port states
+const (
+	Open     = "open"
+	Closed   = "closed"
+	Filtered = "filtered"
+)
+
+// Topology declares the simulated hosts. Addresses not covered by any host
+// do not answer, like a filtered port.
+type Topology struct {
+	// Seed makes packet loss reproducible
+	Seed  int64  `yaml:"seed"`
+	Hosts []Host `yaml:"hosts"`
+}
+
+// Host is one address, or every address of a CIDR block, with the same
+// behavior
+type Host struct {
+	Addr    string        `yaml:"addr"`
+	Latency time.Duration `yaml:"latency"`
+	// Loss is the chance in [0, 1] that a connection attempt goes unanswered
+	Loss float64 `yaml:"loss"`
+	// Default is the state of ports not listed: closed unless set to filtered
+	Default string `yaml:"default"`
+	Ports   []Port `yaml:"ports"`
+}
+
+// Port overrides the host's behavior for a single port
+type Port struct {
+	Port int `yaml:"port"`
+	// State defaults to open
+	State   string        `yaml:"state"`
+	Banner  string        `yaml:"banner"`
+	Latency time.Duration `yaml:"latency"`
+	// Handler, if set, serves the connection instead of the banner
+	Handler func(net.Conn) `yaml:"-"`
+}
+
+type host struct {
+	Host
+	net   *net.IPNet
+	ports map[int]Port
+}
+
+// Network is a simulated network. It is safe for concurrent use.
+type Network struct {
+	seed  int64
+	exact map[string]*host
+	cidrs []*host
+
+	mu        sync.Mutex
+	attempts  map[string]int
+	nextPort  int
+	localAddr net.IP
+}
+
+// New builds a Network from t
+func New(t Topology) (*Network, error) {
+	n := &Network{
+		seed:      t.Seed,
+		exact:     make(map[string]*host),
+		attempts:  make(map[string]int),
+		nextPort:  40000,
+		localAddr: net.IPv4(127, 0, 0, 1),
+	}
+	for _, h := range t.Hosts {
+		sh := &host{Host: h, ports: make(map[int]Port)}
+		switch h.Default {
+		case "", Closed, Filtered:
+		default:
+			return nil, fmt.Errorf("host %s: invalid default state %q", h.Addr, h.Default)
+		}
+		if h.Loss < 0 || h.Loss > 1 {
+			return nil, fmt.Errorf("host %s: loss must be between 0 and 1", h.Addr)
+		}
+		for _, p := range h.Ports {
+			if p.Port <= 0 || p.Port > 65535 {
+				return nil, fmt.Errorf("host %s: invalid port %d", h.Addr, p.Port)
+			}
+			switch p.State {
+			case "":
+				p.State = Open
+			case Open, Closed, Filtered:
+			default:
+				return nil, fmt.Errorf("host %s port %d: invalid state %q", h.Addr, p.Port, p.State)
+			}
+			sh.ports[p.Port] = p
+		}
+		if _, ipNet, err := net.ParseCIDR(h.Addr); err == nil {
+			sh.net = ipNet
+			n.cidrs = append(n.cidrs, sh)
+			continue
+		}
+		ip := net.ParseIP(h.Addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid host address %q", h.Addr)
+		}
+		n.exact[ip.String()] = sh
+	}
+	return n, nil
+}
+
+// Parse builds a Network from a YAML topology
+func Parse(data []byte) (*Network, error) {
+	var t Topology
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return New(t)
+}
+
+// MustNew is New for topologies known to be valid, such as test fixtures
+func MustNew(t Topology) *Network {
+	n, err := New(t)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// lookup returns the host for ip. Exact addresses take precedence over
+// CIDR blocks, and earlier blocks over later ones.
+func (n *Network) lookup(ip net.IP) *host {
+	if h, ok := n.exact[ip.String()]; ok {
+		return h
+	}
+	for _, h := range n.cidrs {
+		if h.net.Contains(ip) {
+			return h
+		}
+	}
+	return nil
+}
+
+// Attempts returns how many times address has been dialed
+func (n *Network) Attempts(address string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.attempts[address]
+}
+
+// TotalAttempts returns how many dials the network has seen
+func (n *Network) TotalAttempts() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	total := 0
+	for _, c := range n.attempts {
+		total += c
+	}
+	return total
+}
+
+// DialTimeout connects to address as net.DialTimeout would. A timeout of
+// zero waits forever on addresses that do not answer.
+func (n *Network) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return n.DialContext(ctx, network, address)
+}
+
+// DialContext connects to address, giving up when ctx is done
+func (n *Network) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	hostStr, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	ip := net.ParseIP(hostStr)
+	port, err := strconv.Atoi(portStr)
+	if ip == nil || err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("invalid address %q", address)}
+	}
+	remote := &net.TCPAddr{IP: ip, Port: port}
+	fail := func(err error) error {
+		return &net.OpError{Op: "dial", Net: network, Addr: remote, Err: err}
+	}
+	if network != "tcp" && network != "tcp4" {
+		return nil, fail(net.UnknownNetworkError(network))
+	}
+
+	n.mu.Lock()
+	n.attempts[address]++
+	attempt := n.attempts[address]
+	local := &net.TCPAddr{IP: n.localAddr, Port: n.nextPort}
+	n.nextPort++
+	if n.nextPort > 65535 {
+		n.nextPort = 40000
+	}
+	n.mu.Unlock()
+
+	h := n.lookup(ip)
+	state, latency := Filtered, time.Duration(0)
+	var p Port
+	if h != nil {
+		latency = h.Latency
+		state = Closed
+		if h.Default == Filtered {
+			state = Filtered
+		}
+		if listed, ok := h.ports[port]; ok {
+			p = listed
+			state = p.State
+			if p.Latency > 0 {
+				latency = p.Latency
+			}
+		}
+		if h.Loss > 0 && n.lost(address, attempt, h.Loss) {
+			state = Filtered
+		}
+	}
+
+	if state == Filtered {
+		<-ctx.Done()
+		return nil, fail(os.ErrDeadlineExceeded)
+	}
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, fail(os.ErrDeadlineExceeded)
+		case <-timer.C:
+		}
+	}
+	if state == Closed {
+		return nil, fail(&os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED})
+	}
+
+	client, server := net.Pipe()
+	go serve(&conn{Conn: server, local: remote, remote: local}, p)
+	return &conn{Conn: client, local: local, remote: remote}, nil
+}
+
+// lost decides whether an attempt is dropped. The decision depends only on
+// the seed, the address and the attempt number, so it does not change with
+// the order concurrent dials happen in.
+func (n *Network) lost(address string, attempt int, loss float64) bool {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%d", n.seed, address, attempt)
+	return float64(h.Sum64()>>11)/(1<<53) < loss
+}
+
+func serve(c net.Conn, p Port) {
+	defer c.Close()
+	if p.Handler != nil {
+		p.Handler(c)
+		return
+	}
+	if p.Banner != "" {
+		if _, err := io.WriteString(c, p.Banner); err != nil {
+			return
+		}
+	}
+	// Hold the connection open until the client closes it
+	_, _ = io.Copy(io.Discard, c)
+}
+
+// conn gives a pipe TCP addresses
+type conn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
+
+// IsTimeout reports whether err is the timeout returned for filtered ports
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
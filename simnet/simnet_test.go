@@ -0,0 +1,151 @@
+package simnet
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var testTopology = Topology{
+	Seed: 1,
+	Hosts: []Host{
+		{Addr: "10.0.0.1", Ports: []Port{
+			{Port: 22, Banner: "SSH-2.0-OpenSSH_8.9\r\n"},
+			{Port: 80},
+			{Port: 443, State: Filtered},
+		}},
+		{Addr: "10.0.0.2", Default: Filtered, Latency: 20 * time.Millisecond, Ports: []Port{{Port: 80}}},
+		{Addr: "10.0.1.0/24", Ports: []Port{{Port: 8080, Handler: func(c net.Conn) {
+			line, _ := bufio.NewReader(c).ReadString('\n')
+			io.WriteString(c, "echo "+line)
+		}}}},
+	},
+}
+
+// TestDialStates tests open, closed and filtered ports and unknown hosts
+func TestDialStates(t *testing.T) {
+	n := MustNew(testTopology)
+
+	c, err := n.DialTimeout("tcp", "10.0.0.1:22", time.Second)
+	if err != nil {
+		t.Fatalf("open port: %v", err)
+	}
+	banner, _ := bufio.NewReader(c).ReadString('\n')
+	if banner != "SSH-2.0-OpenSSH_8.9\r\n" || c.RemoteAddr().String() != "10.0.0.1:22" {
+		t.Errorf("banner = %q from %s", banner, c.RemoteAddr())
+	}
+	c.Close()
+
+	if _, err := n.DialTimeout("tcp", "10.0.0.1:25", time.Second); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("closed port: %v", err)
+	}
+	for _, addr := range []string{"10.0.0.1:443", "10.0.0.2:22", "10.9.9.9:80"} {
+		start := time.Now()
+		_, err := n.DialTimeout("tcp", addr, 30*time.Millisecond)
+		if !IsTimeout(err) || time.Since(start) < 30*time.Millisecond {
+			t.Errorf("%s: %v after %s, want timeout", addr, err, time.Since(start))
+		}
+	}
+	if n.Attempts("10.0.0.1:443") != 1 || n.TotalAttempts() != 5 {
+		t.Errorf("attempts = %d, total %d", n.Attempts("10.0.0.1:443"), n.TotalAttempts())
+	}
+}
+
+// TestDialLatency tests that latency delays connections and can exceed the
+// timeout
+func TestDialLatency(t *testing.T) {
+	n := MustNew(testTopology)
+	start := time.Now()
+	c, err := n.DialTimeout("tcp", "10.0.0.2:80", time.Second)
+	if err != nil || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("dial took %s: %v", time.Since(start), err)
+	}
+	c.Close()
+	if _, err := n.DialTimeout("tcp", "10.0.0.2:80", 5*time.Millisecond); !IsTimeout(err) {
+		t.Errorf("dial slower than timeout = %v", err)
+	}
+}
+
+// TestDialHandler tests custom handlers on CIDR hosts
+func TestDialHandler(t *testing.T) {
+	n := MustNew(testTopology)
+	c, err := n.DialTimeout("tcp", "10.0.1.77:8080", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "hello\n")
+	reply, _ := bufio.NewReader(c).ReadString('\n')
+	if reply != "echo hello\n" {
+		t.Errorf("handler replied %q", reply)
+	}
+}
+
+// TestLoss tests that loss is reproducible and roughly the configured rate
+func TestLoss(t *testing.T) {
+	topo := Topology{Seed: 7, Hosts: []Host{{Addr: "10.0.0.0/24", Loss: 0.3, Ports: []Port{{Port: 80}}}}}
+	run := func() []bool {
+		n := MustNew(topo)
+		var lost []bool
+		for i := 0; i < 256; i++ {
+			addr := net.JoinHostPort(net.IPv4(10, 0, 0, byte(i)).String(), "80")
+			c, err := n.DialTimeout("tcp", addr, time.Millisecond)
+			if err == nil {
+				c.Close()
+			}
+			lost = append(lost, err != nil)
+		}
+		return lost
+	}
+	a, b := run(), run()
+	dropped := 0
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("loss differs between runs at %d", i)
+		}
+		if a[i] {
+			dropped++
+		}
+	}
+	if dropped < 40 || dropped > 120 {
+		t.Errorf("dropped %d of 256 with 30%% loss", dropped)
+	}
+}
+
+// TestParse tests YAML topologies and validation
+func TestParse(t *testing.T) {
+	n, err := Parse([]byte(`
+seed: 3
+hosts:
+  - addr: 192.168.1.10
+    latency: 1ms
+    ports:
+      - port: 25
+        banner: "220 mail ESMTP\r\n"
+      - port: 110
+        state: closed
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := n.DialTimeout("tcp", "192.168.1.10:25", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	for _, bad := range []Topology{
+		{Hosts: []Host{{Addr: "nope"}}},
+		{Hosts: []Host{{Addr: "10.0.0.1", Default: "open"}}},
+		{Hosts: []Host{{Addr: "10.0.0.1", Ports: []Port{{Port: 70000}}}}},
+		{Hosts: []Host{{Addr: "10.0.0.1", Loss: 2}}},
+	} {
+		if _, err := New(bad); err == nil {
+			t.Errorf("New() accepted %+v", bad)
+		}
+	}
+}
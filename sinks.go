@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// defaultMaxClosed is how many closed and filtered results a scan keeps in
+// memory by default, a few megabytes
+const defaultMaxClosed = 100000
+
+// ResultSink consumes scan results as they are produced. A scan feeds its
+// sinks from a single goroutine, but sinks shared between scans must be
+// safe for concurrent use.
+type ResultSink interface {
+	Record(ScanResult)
+}
+
+// SinkFunc adapts a function to ResultSink
+type SinkFunc func(ScanResult)
+
+func (f SinkFunc) Record(r ScanResult) { f(r) }
+
+// Sinks passes each result to every sink in order
+type Sinks []ResultSink
+
+func (s Sinks) Record(r ScanResult) {
+	for _, sink := range s {
+		sink.Record(r)
+	}
+}
+
+// loopSinks receive the results of the scan loop, whether probed locally or
+// reported by a worker. The globals are read on every result, so they may
+// be replaced after startup.
+var loopSinks = Sinks{
+	SinkFunc(func(r ScanResult) { results.Add(r) }),
+	SinkFunc(func(r ScanResult) { status.record(r) }),
+	SinkFunc(func(r ScanResult) { publishResult("", r, portStates) }),
+	SinkFunc(func(r ScanResult) { alertRules.observe(r) }),
+	SinkFunc(func(r ScanResult) { exporter.Write(status.ScanID(), r) }),
+	SinkFunc(announceResult),
+}
+
+// jobSinks receive the results of a job
+func jobSinks(job *Job) Sinks {
+	return Sinks{
+		SinkFunc(job.Results.Add),
+		SinkFunc(job.Status.record),
+		SinkFunc(func(r ScanResult) { publishResult(job.ID, r, nil) }),
+		SinkFunc(func(r ScanResult) { exporter.Write(job.ID, r) }),
+	}
+}
+
+// announceResult logs open ports, appends them to the results file and
+// sends their alerts
+func announceResult(result ScanResult) {
+	if !result.Open {
+		return
+	}
+	slog.Info("open port", "scan_id", status.ScanID(), "ip", result.IP, "hostname", result.Hostname, "port", result.Port, "state", StateOpen, "route", result.Route)
+	if outputs.ResultsFile != "" {
+		line := fmt.Sprintf("Port %d is open on %s", result.Port, result.Host())
+		if result.Route != "" {
+			line += " via " + result.Route
+		}
+		if err := appendLine(outputs.ResultsFile, line); err != nil {
+			slog.Error("writing results file", "path", outputs.ResultsFile, "error", err)
+		}
+	}
+	if cveAlertScore > 0 && result.CVSS >= cveAlertScore {
+		slog.Warn("vulnerable service", "scan_id", status.ScanID(), "ip", result.IP, "port", result.Port, "cpe", result.CPE, "cvss", result.CVSS, "cves", result.CVEs)
+		notify(MsgVulnerable, result)
+	}
+	// With a baseline, only violations are alerted, after the scan
+	if baseline == nil {
+		notify(MsgOpenPort, result)
+	}
+}
+
+// exporter streams results to output.export_file, or is nil
+var exporter *Exporter
+
+// Exporter appends results to a file as JSON lines, each tagged with its
+// scan ID. Writes are buffered; Flush after each scan.
+type Exporter struct {
+	path    string
+	discard bool
+
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	err error
+}
+
+// NewExporter opens path for appending. With discard set, only open ports
+// are exported.
+func NewExporter(path string, discard bool) (*Exporter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{path: path, discard: discard, f: f, w: bufio.NewWriterSize(f, 64<<10)}, nil
+}
+
+// Write exports r. Errors are logged once until the next successful Flush.
+func (e *Exporter) Write(scanID string, r ScanResult) {
+	if e == nil || (e.discard && !r.Open) {
+		return
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		slog.Error("encoding exported result", "error", err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// The result's own fields follow the scan ID
+	fmt.Fprintf(e.w, `{"scan_id":%q,`, scanID)
+	e.w.Write(data[1:])
+	if err := e.w.WriteByte('\n'); err != nil && e.err == nil {
+		e.err = err
+		slog.Error("writing export file", "path", e.path, "error", err)
+	}
+}
+
+// Flush writes buffered results to the file
+func (e *Exporter) Flush() error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	e.err = nil
+	return nil
+}
+
+// Close flushes and closes the file
+func (e *Exporter) Close() error {
+	if e == nil {
+		return nil
+	}
+	err := e.Flush()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if cerr := e.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestResultStoreRetention tests bounding and discarding closed results
+// while still counting them
+func TestResultStoreRetention(t *testing.T) {
+	originalOutputs, originalBaseline := outputs, baseline
+	defer func() { outputs, baseline = originalOutputs, originalBaseline }()
+	baseline = &Baseline{Rules: []BaselineRule{{Name: "db", Targets: []string{"10.0.0.9"}, Required: []int{5432}}}}
+	if err := baseline.validate(); err != nil {
+		t.Fatal(err)
+	}
+	add := func(rs *ResultStore) {
+		for i := 1; i <= 4; i++ {
+			rs.Add(ScanResult{IP: "10.0.0.1", Port: i, Error: errors.New("connection refused")})
+			rs.Add(ScanResult{IP: "10.0.0.2", Port: i, Error: timeoutError{}})
+		}
+		rs.Add(ScanResult{IP: "10.0.0.1", Port: 22, Open: true})
+		rs.Add(ScanResult{IP: "10.0.0.9", Port: 5432, Error: timeoutError{}})
+	}
+
+	for _, tc := range []struct {
+		name   string
+		output OutputConfig
+		kept   int
+	}{
+		{"unbounded", OutputConfig{}, 10},
+		{"bounded", OutputConfig{MaxClosed: 3}, 5},
+		{"discarded", OutputConfig{MaxClosed: 3, DiscardClosed: true}, 2},
+	} {
+		outputs = tc.output
+		rs := NewResultStore()
+		add(rs)
+		if rs.Len() != tc.kept {
+			t.Errorf("%s: kept %d results, want %d", tc.name, rs.Len(), tc.kept)
+		}
+		if states := rs.States(); states[StateOpen] != 1 || states[StateClosed] != 4 || states[StateFiltered] != 5 {
+			t.Errorf("%s: States() = %v", tc.name, states)
+		}
+		// The baseline's required port is kept whatever the limits
+		if _, n := rs.Query(ResultFilter{IP: "10.0.0.9"}, 0, 0); n != 1 {
+			t.Errorf("%s: required port not kept", tc.name)
+		}
+		// 10.0.0.2 never answered
+		if hosts := rs.Hosts(); len(hosts) != 1 || hosts[0].Closed != 4 || hosts[0].Open != 1 {
+			t.Errorf("%s: Hosts() = %+v", tc.name, hosts)
+		}
+	}
+}
+
+// TestSinks tests fanning results out in order
+func TestSinks(t *testing.T) {
+	var got []string
+	sinks := Sinks{
+		SinkFunc(func(r ScanResult) { got = append(got, "a:"+r.IP) }),
+		SinkFunc(func(r ScanResult) { got = append(got, "b:"+r.IP) }),
+	}
+	sinks.Record(ScanResult{IP: "10.0.0.1"})
+	if len(got) != 2 || got[0] != "a:10.0.0.1" || got[1] != "b:10.0.0.1" {
+		t.Errorf("recorded %v", got)
+	}
+}
+
+// TestExporter tests streaming results as JSON lines from several scans
+func TestExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	e, err := NewExporter(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for _, id := range []string{"loop-1", "job-a"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := 1; port <= 100; port++ {
+				e.Write(id, ScanResult{IP: "10.0.0.1", Port: port, Open: port == 22, Error: timeoutError{}})
+			}
+		}()
+	}
+	wg.Wait()
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var id struct {
+			ScanID string `json:"scan_id"`
+		}
+		var r ScanResult
+		if json.Unmarshal(scanner.Bytes(), &id) != nil || json.Unmarshal(scanner.Bytes(), &r) != nil {
+			t.Fatalf("unreadable line %q", scanner.Text())
+		}
+		counts[id.ScanID]++
+		if r.Port == 22 && !r.Open || r.Port != 22 && r.State() != StateFiltered {
+			t.Errorf("line %q decoded as %+v", scanner.Text(), r)
+		}
+	}
+	if counts["loop-1"] != 100 || counts["job-a"] != 100 {
+		t.Errorf("exported %v", counts)
+	}
+
+	// Only open ports when discarding, appended to what is there
+	e, err = NewExporter(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Write("loop-2", ScanResult{IP: "10.0.0.1", Port: 22, Open: true})
+	e.Write("loop-2", ScanResult{IP: "10.0.0.1", Port: 23, Error: timeoutError{}})
+	e.Close()
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 201 {
+		t.Errorf("export file has %d lines, want 201", lines)
+	}
+
+	// A nil exporter does nothing
+	var none *Exporter
+	none.Write("loop-1", ScanResult{})
+	if none.Flush() != nil || none.Close() != nil {
+		t.Error("nil exporter failed")
+	}
+}
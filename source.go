@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// SourceConfig binds outbound probes to local addresses, ports and an
+// interface instead of the default route
+type SourceConfig struct {
+	// IPs are used round-robin, one per probe
+	IPs       []string `yaml:"ips"`
+	Interface string   `yaml:"interface"`
+	// Ports is a local port range such as "40000-40999"
+	Ports string `yaml:"ports"`
+}
+
+// maxSourcePortTries bounds the ports tried per probe when the next one in
+// the range is still in use
+const maxSourcePortTries = 8
+
+// sourceDialer dials from the configured source addresses
+type sourceDialer struct {
+	ips            []net.IP
+	iface          string
+	portLo, portHi int
+	next           atomic.Uint64
+}
+
+// newSourceDialer returns nil when cfg binds nothing
+func newSourceDialer(cfg SourceConfig) (*sourceDialer, error) {
+	if len(cfg.IPs) == 0 && cfg.Interface == "" && cfg.Ports == "" {
+		return nil, nil
+	}
+	var errs []error
+	d := &sourceDialer{iface: cfg.Interface}
+	for _, s := range cfg.IPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			errs = append(errs, fmt.Errorf("invalid source IP %q", s))
+			continue
+		}
+		d.ips = append(d.ips, ip)
+	}
+	if cfg.Ports != "" {
+		lo, hi, err := parsePortRange(cfg.Ports)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		d.portLo, d.portHi = lo, hi
+	}
+	if cfg.Interface != "" {
+		if !bindToDeviceSupported {
+			errs = append(errs, errors.New("binding to an interface is only supported on Linux"))
+		} else if _, err := net.InterfaceByName(cfg.Interface); err != nil {
+			errs = append(errs, fmt.Errorf("interface %q: %w", cfg.Interface, err))
+		}
+	}
+	return d, errors.Join(errs...)
+}
+
+// parsePortRange parses "lo-hi" or a single port
+func parsePortRange(s string) (lo, hi int, err error) {
+	loStr, hiStr, found := strings.Cut(s, "-")
+	if !found {
+		hiStr = loStr
+	}
+	lo, errLo := strconv.Atoi(strings.TrimSpace(loStr))
+	hi, errHi := strconv.Atoi(strings.TrimSpace(hiStr))
+	if errLo != nil || errHi != nil || lo <= 0 || hi > 65535 || lo > hi {
+		return 0, 0, fmt.Errorf("invalid source port range %q", s)
+	}
+	return lo, hi, nil
+}
+
+// Dial is a DialerFunc. Each call takes the next source IP and port; a port
+// still in use by an earlier probe is skipped.
+func (d *sourceDialer) Dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	tries := 1
+	if d.portLo > 0 {
+		tries = min(maxSourcePortTries, d.portHi-d.portLo+1)
+	}
+	var err error
+	for range tries {
+		n := d.next.Add(1) - 1
+		dialer := net.Dialer{Timeout: timeout, Control: d.control}
+		if len(d.ips) > 0 || d.portLo > 0 {
+			local := &net.TCPAddr{}
+			if len(d.ips) > 0 {
+				local.IP = d.ips[n%uint64(len(d.ips))]
+			}
+			if d.portLo > 0 {
+				local.Port = d.portLo + int(n%uint64(d.portHi-d.portLo+1))
+			}
+			dialer.LocalAddr = local
+		}
+		var conn net.Conn
+		if conn, err = dialer.Dial(network, address); !errors.Is(err, syscall.EADDRINUSE) {
+			return conn, err
+		}
+	}
+	return nil, err
+}
+
+func (d *sourceDialer) control(network, address string, c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		if d.portLo > 0 {
+			// Allow reuse of ports still in TIME_WAIT from earlier probes
+			if err = setReuseAddr(fd); err != nil {
+				return
+			}
+		}
+		if d.iface != "" {
+			err = bindToDevice(fd, d.iface)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+package main
+
+import "syscall"
+
+const bindToDeviceSupported = true
+
+// bindToDevice sets SO_BINDTODEVICE, which needs CAP_NET_RAW
+func bindToDevice(fd uintptr, iface string) error {
+	return syscall.BindToDevice(int(fd), iface)
+}
+
+func setReuseAddr(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+}
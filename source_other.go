@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+const bindToDeviceSupported = false
+
+func bindToDevice(fd uintptr, iface string) error {
+	return errors.New("SO_BINDTODEVICE is only supported on Linux")
+}
+
+// setReuseAddr is a no-op; source port ranges rely on the ports having left
+// TIME_WAIT
+func setReuseAddr(fd uintptr) error {
+	return nil
+}
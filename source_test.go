@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestParsePortRange tests source port range parsing
+func TestParsePortRange(t *testing.T) {
+	if lo, hi, err := parsePortRange("40000-40999"); err != nil || lo != 40000 || hi != 40999 {
+		t.Errorf("parsePortRange(40000-40999) = %d, %d, %v", lo, hi, err)
+	}
+	if lo, hi, err := parsePortRange("5353"); err != nil || lo != 5353 || hi != 5353 {
+		t.Errorf("parsePortRange(5353) = %d, %d, %v", lo, hi, err)
+	}
+	for _, bad := range []string{"", "0-10", "10-5", "1-70000", "a-b"} {
+		if _, _, err := parsePortRange(bad); err == nil {
+			t.Errorf("parsePortRange(%q) accepted", bad)
+		}
+	}
+}
+
+// TestSourceDialer tests that probes leave from the configured addresses,
+// round-robin, and from the port range
+func TestSourceDialer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var mu sync.Mutex
+	var remotes []*net.TCPAddr
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			remotes = append(remotes, c.RemoteAddr().(*net.TCPAddr))
+			mu.Unlock()
+			c.Close()
+		}
+	}()
+
+	d, err := newSourceDialer(SourceConfig{IPs: []string{"127.0.0.2", "127.0.0.3"}, Ports: "47100-47199"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		conn, err := d.Dial("tcp", ln.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(remotes)
+		mu.Unlock()
+		if n == 4 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(remotes) != 4 {
+		t.Fatalf("accepted %d connections, want 4", len(remotes))
+	}
+	for i, addr := range remotes {
+		wantIP := []string{"127.0.0.2", "127.0.0.3"}[i%2]
+		if addr.IP.String() != wantIP || addr.Port < 47100 || addr.Port > 47199 {
+			t.Errorf("connection %d came from %s, want %s:47100-47199", i, addr, wantIP)
+		}
+	}
+}
+
+// TestSourceDialerInterface tests binding to the loopback interface
+func TestSourceDialerInterface(t *testing.T) {
+	if !bindToDeviceSupported {
+		t.Skip("SO_BINDTODEVICE is Linux only")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	d, err := newSourceDialer(SourceConfig{Interface: "lo"})
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	conn, err := d.Dial("tcp", ln.Addr().String(), time.Second)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("binding to an interface needs CAP_NET_RAW")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if _, err := newSourceDialer(SourceConfig{Interface: "no-such-nic0"}); err == nil {
+		t.Error("newSourceDialer() accepted a missing interface")
+	}
+}
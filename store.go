@@ -0,0 +1,364 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scan result states as reported by the API
+const (
+	StateOpen     = "open"
+	StateClosed   = "closed"
+	StateFiltered = "filtered"
+	// StateError is a probe that failed in the proxy chain, so the port's
+	// state is unknown
+	StateError = "error"
+)
+
+// State classifies the result as open, closed (refused), filtered (timed
+// out) or error (a proxy hop failed)
+func (r ScanResult) State() string {
+	if r.Open {
+		return StateOpen
+	}
+	var hop hopError
+	if errors.As(r.Error, &hop) {
+		return StateError
+	}
+	var netErr net.Error
+	if errors.As(r.Error, &netErr) && netErr.Timeout() {
+		return StateFiltered
+	}
+	return StateClosed
+}
+
+// Host names the result's host for people: "name (ip)", or just the IP
+func (r ScanResult) Host() string {
+	if r.Hostname == "" {
+		return r.IP
+	}
+	return r.Hostname + " (" + r.IP + ")"
+}
+
+// ResultFilter selects results from a ResultStore. Zero values match everything.
+type ResultFilter struct {
+	IP      string
+	Port    int
+	State   string
+	MinCVSS float64
+}
+
+func (f ResultFilter) match(r ScanResult) bool {
+	if f.IP != "" && f.IP != r.IP {
+		return false
+	}
+	if f.Port != 0 && f.Port != r.Port {
+		return false
+	}
+	if f.State != "" && f.State != r.State() {
+		return false
+	}
+	if f.MinCVSS > 0 && r.CVSS < f.MinCVSS {
+		return false
+	}
+	return true
+}
+
+// ResultStore is a concurrency-safe collection of scan results. Every open
+// port is kept; closed and filtered results are kept up to
+// output.max_closed, or not at all with output.discard_closed, except those
+// a baseline needs. Everything added is counted by state, and by host for
+// up to maxTrackedHosts hosts.
+type ResultStore struct {
+	mu      sync.RWMutex
+	results []ScanResult
+	kept    int // closed and filtered results in results
+	states  map[string]int
+	hosts   map[string]*hostAggregate
+	// silent counts the unanswered probes of hosts that have not answered
+	// yet, which move to the host's aggregate once it does
+	silent map[string]silentHost
+	// truncated is set once a host was left out of hosts or silent
+	truncated bool
+}
+
+// maxTrackedHosts bounds the hosts aggregated and the silent hosts counted
+// by a ResultStore. Beyond it new hosts are only counted by state, except
+// those with an open port, whose results are kept anyway.
+const maxTrackedHosts = 10000
+
+type silentHost struct {
+	filtered, errors int32
+}
+
+func NewResultStore() *ResultStore {
+	return &ResultStore{states: make(map[string]int), hosts: make(map[string]*hostAggregate), silent: make(map[string]silentHost)}
+}
+
+func (s *ResultStore) Add(r ScanResult) {
+	state := r.State()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[state]++
+	// Hosts are aggregated once they answer; until then only their
+	// unanswered probes are counted
+	h := s.hosts[r.IP]
+	switch {
+	case h != nil:
+		h.add(r, state)
+	case state == StateFiltered || state == StateError:
+		c, ok := s.silent[r.IP]
+		if !ok && len(s.silent) >= maxTrackedHosts {
+			s.truncateLocked()
+			break
+		}
+		if state == StateFiltered {
+			c.filtered++
+		} else {
+			c.errors++
+		}
+		s.silent[r.IP] = c
+	case state != StateOpen && len(s.hosts) >= maxTrackedHosts:
+		s.truncateLocked()
+	default:
+		h = &hostAggregate{stats: HostStats{IP: r.IP}}
+		if c, ok := s.silent[r.IP]; ok {
+			h.stats.Filtered, h.stats.Errors = int(c.filtered), int(c.errors)
+			delete(s.silent, r.IP)
+		}
+		s.hosts[r.IP] = h
+		h.add(r, state)
+	}
+
+	if !r.Open && !baseline.needs(r) {
+		if outputs.DiscardClosed || (outputs.MaxClosed > 0 && s.kept >= outputs.MaxClosed) {
+			return
+		}
+		s.kept++
+	}
+	s.results = append(s.results, r)
+}
+
+// truncateLocked records that a host was left out, warning the first time
+func (s *ResultStore) truncateLocked() {
+	if !s.truncated {
+		s.truncated = true
+		slog.Warn("tracking too many hosts, counting further hosts only by state", "limit", maxTrackedHosts)
+	}
+}
+
+func (s *ResultStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = nil
+	s.kept = 0
+	s.states = make(map[string]int)
+	s.hosts = make(map[string]*hostAggregate)
+	s.silent = make(map[string]silentHost)
+	s.truncated = false
+}
+
+// Len returns the number of results kept
+func (s *ResultStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.results)
+}
+
+// States returns how many results of each state were added, kept or not
+func (s *ResultStore) States() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	states := map[string]int{StateOpen: 0, StateClosed: 0, StateFiltered: 0, StateError: 0}
+	for state, n := range s.states {
+		states[state] = n
+	}
+	return states
+}
+
+// Hosts summarizes the results of each host that answered, in address order
+func (s *ResultStore) Hosts() []HostStats {
+	s.mu.RLock()
+	hosts := make([]HostStats, 0, len(s.hosts))
+	for _, h := range s.hosts {
+		hosts = append(hosts, h.summary())
+	}
+	s.mu.RUnlock()
+	sort.Slice(hosts, func(a, b int) bool { return compareIPs(hosts[a].IP, hosts[b].IP) < 0 })
+	return hosts
+}
+
+// HostsTruncated reports whether hosts were left out of Hosts because more
+// than maxTrackedHosts were seen
+func (s *ResultStore) HostsTruncated() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.truncated
+}
+
+// All returns a copy of every kept result
+func (s *ResultStore) All() []ScanResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]ScanResult(nil), s.results...)
+}
+
+// Open returns a copy of the results with an open port
+func (s *ResultStore) Open() []ScanResult {
+	matched, _ := s.Query(ResultFilter{State: StateOpen}, 0, 0)
+	return matched
+}
+
+// Query returns the page of results matching f starting at offset, along with
+// the total number of matches. A limit of 0 returns every match after offset.
+func (s *ResultStore) Query(f ResultFilter, offset, limit int) ([]ScanResult, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var page []ScanResult
+	total := 0
+	for _, r := range s.results {
+		if !f.match(r) {
+			continue
+		}
+		if total >= offset && (limit == 0 || len(page) < limit) {
+			page = append(page, r)
+		}
+		total++
+	}
+	return page, total
+}
+
+// ScanStatus tracks the progress of the current scan iteration
+type ScanStatus struct {
+	mu        sync.RWMutex
+	iteration int
+	startIP   string
+	endIP     string
+	total     uint64
+	completed uint64
+	open      uint64
+	running   bool
+	startedAt time.Time
+	updatedAt time.Time
+	nextRun   time.Time
+}
+
+// StatusSnapshot is the JSON view of a ScanStatus
+type StatusSnapshot struct {
+	Iteration      int        `json:"iteration"`
+	Running        bool       `json:"running"`
+	StartIP        string     `json:"start_ip"`
+	EndIP          string     `json:"end_ip"`
+	Total          uint64     `json:"total"`
+	Completed      uint64     `json:"completed"`
+	Open           uint64     `json:"open"`
+	PercentDone    float64    `json:"percent_done"`
+	RatePerSecond  float64    `json:"rate_per_second"`
+	ETASeconds     float64    `json:"eta_seconds"`
+	ElapsedSeconds float64    `json:"elapsed_seconds"`
+	StartedAt      time.Time  `json:"started_at,omitempty"`
+	NextRun        *time.Time `json:"next_run,omitempty"`
+
+	Schedules     []ScheduleSnapshot `json:"schedules,omitempty"`
+	Notifications *OutboxStats       `json:"notifications,omitempty"`
+}
+
+func NewScanStatus() *ScanStatus {
+	return &ScanStatus{}
+}
+
+func (s *ScanStatus) nextIteration() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.iteration++
+}
+
+// ScanID names the current iteration of the scan loop in logs
+func (s *ScanStatus) ScanID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fmt.Sprintf("loop-%d", s.iteration)
+}
+
+func (s *ScanStatus) begin(startIP, endIP string, total uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startIP = startIP
+	s.endIP = endIP
+	s.total = total
+	s.completed = 0
+	s.open = 0
+	s.running = true
+	s.startedAt = time.Now()
+	s.updatedAt = s.startedAt
+}
+
+func (s *ScanStatus) record(r ScanResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.completed++
+	if r.Open {
+		s.open++
+	}
+	s.updatedAt = time.Now()
+}
+
+func (s *ScanStatus) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	s.updatedAt = time.Now()
+}
+
+// setNextRun records when the scan loop will start its next iteration
+func (s *ScanStatus) setNextRun(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextRun = t
+}
+
+func (s *ScanStatus) Snapshot() StatusSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap := StatusSnapshot{
+		Iteration: s.iteration,
+		Running:   s.running,
+		StartIP:   s.startIP,
+		EndIP:     s.endIP,
+		Total:     s.total,
+		Completed: s.completed,
+		Open:      s.open,
+		StartedAt: s.startedAt,
+	}
+	if !s.nextRun.IsZero() {
+		t := s.nextRun
+		snap.NextRun = &t
+	}
+	if s.startedAt.IsZero() {
+		return snap
+	}
+
+	end := time.Now()
+	if !s.running {
+		end = s.updatedAt
+	}
+	elapsed := end.Sub(s.startedAt)
+	snap.ElapsedSeconds = elapsed.Seconds()
+	if s.total > 0 {
+		snap.PercentDone = float64(s.completed) / float64(s.total) * 100
+	}
+	if elapsed > 0 {
+		snap.RatePerSecond = float64(s.completed) / elapsed.Seconds()
+	}
+	if s.running && snap.RatePerSecond > 0 && s.total > s.completed {
+		snap.ETASeconds = float64(s.total-s.completed) / snap.RatePerSecond
+	}
+	return snap
+}
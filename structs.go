@@ -7,8 +7,17 @@ import (
 
 var brevo Brevo
 var email Email
-var results []ScanResult
+var results = NewResultStore()
+var status = NewScanStatus()
+var events = NewEventBus(eventBufferSize)
+var portStates = NewStateTracker()
+
+// scheduler is set in main once the job manager is running
+var scheduler *Scheduler
 var checkpoints []Checkpoint
+var outputs OutputConfig
+var scope = defaultScope()
+var probeLimiter *rateLimiter
 
 // DialerFunc is a type for the dialer function
 type DialerFunc func(network, address string, timeout time.Duration) (net.Conn, error)
@@ -20,23 +29,45 @@ var dialTimeout DialerFunc = net.DialTimeout
 var updateSleepDuration = 12 * time.Hour
 
 type ScanResult struct {
-	IP    string
-	Port  int
-	Open  bool
-	Error error
+	IP string `json:"ip"`
+	// Hostname is the target's name, or the PTR name of an open host
+	Hostname string   `json:"hostname,omitempty"`
+	Port     int      `json:"port"`
+	Open     bool     `json:"open"`
+	Error    error    `json:"-"`
+	Banner   string   `json:"banner,omitempty"`
+	CPE      string   `json:"cpe,omitempty"`
+	CVEs     []string `json:"cves,omitempty"`
+	CVSS     float64  `json:"cvss,omitempty"`
+	// Route names the proxy route the probe took; empty for direct
+	Route string `json:"route,omitempty"`
+	// Started is when the probe was sent
+	Started time.Time `json:"-"`
+	// Latency is how long the TCP handshake took, through any proxies, or
+	// zero when it did not complete
+	Latency time.Duration `json:"-"`
 }
 
+// Checkpoint records scan progress: the last address launched for a
+// sequential scan, or the last position launched for a randomized one
 type Checkpoint struct {
-	IP string
+	IP       string
+	Position uint64
+	Seed     int64
+	Random   bool
 }
 
 type Email struct {
 	SenderName  string
 	SenderEmail string
-	ToName      string
-	ToEmail     string
+	To          []Contact
+	CC          []Contact
+	BCC         []Contact
 	Subject     string
-	Msg         string
+	// Msg is the plain-text body and HTML the HTML one, rendered from Msg
+	// when empty
+	Msg  string
+	HTML string
 }
 
 type Brevo struct {
@@ -45,15 +76,12 @@ type Brevo struct {
 }
 
 type EmailPayload struct {
-	Sender struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
-	} `json:"sender"`
-	To []struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
-	} `json:"to"`
+	Sender      Contact           `json:"sender"`
+	To          []Contact         `json:"to"`
+	CC          []Contact         `json:"cc,omitempty"`
+	BCC         []Contact         `json:"bcc,omitempty"`
 	Subject     string            `json:"subject"`
+	TextContent string            `json:"textContent,omitempty"`
 	HTMLContent string            `json:"htmlContent"`
 	Headers     map[string]string `json:"headers"`
 }